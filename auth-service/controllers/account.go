@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type ProfileInput struct {
+	DisplayName *string `json:"display_name"`
+	Email       *string `json:"email"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type DeleteAccountInput struct {
+	Password string `json:"password" binding:"required"`
+}
+
+func profile(user *models.User) gin.H {
+	return gin.H{
+		"id":             user.ID,
+		"tenant_id":      user.TenantID,
+		"username":       user.Username,
+		"email":          user.Email,
+		"email_verified": user.EmailVerifiedAt != nil,
+		"display_name":   user.DisplayName,
+		"status":         user.Status,
+		"mfa_enabled":    user.MFAEnabled,
+		"created_at":     user.CreatedAt,
+		"last_login_at":  user.LastLoginAt,
+	}
+}
+
+// Me returns the token details and, for user tokens, the account profile.
+func Me(c *gin.Context) {
+	resp := gin.H{
+		"client_id": c.GetString("client_id"),
+		"user_id":   c.GetString("user_id"),
+		"tenant_id": c.GetString("tenant_id"),
+	}
+	if claims, ok := c.Get("claims"); ok {
+		if exp, err := claims.(jwt.MapClaims).GetExpirationTime(); err == nil && exp != nil {
+			resp["expires_in"] = int64(time.Until(exp.Time).Seconds())
+		}
+	}
+
+	if userID := c.GetString("user_id"); userID != "" {
+		var user models.User
+		if err := database.DB.First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		resp["user"] = profile(&user)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// UpdateProfile changes the user's display name and/or email.
+func UpdateProfile(c *gin.Context) {
+	var input ProfileInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile"})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if input.DisplayName != nil {
+		name := strings.TrimSpace(*input.DisplayName)
+		if len(name) > 64 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "display_name must be at most 64 characters"})
+			return
+		}
+		updates["display_name"] = name
+	}
+	if input.Email != nil {
+		email := utils.NormalizeEmail(*input.Email)
+		if email != "" {
+			if msg := utils.ValidateEmail(email); msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			var count int64
+			database.DB.Model(&models.User{}).Where("tenant_id = ? AND email = ? AND id <> ?", user.TenantID, email, user.ID).Count(&count)
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Email already in use"})
+				return
+			}
+		}
+		if email != user.Email {
+			updates["email"] = email
+			updates["email_verified_at"] = nil
+		}
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusOK, gin.H{"user": profile(user)})
+		return
+	}
+
+	if err := database.DB.Model(user).Updates(updates).Error; err != nil {
+		utils.Logger.Error("Failed to update profile", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	if _, changed := updates["email"]; changed && user.Email != "" {
+		if err := sendVerificationEmail(c.Request.Context(), user); err != nil {
+			utils.Logger.Error("Failed to send verification email", zap.Error(err))
+		}
+	}
+
+	utils.Logger.Info("Profile updated", zap.Uint("user_id", user.ID))
+	c.JSON(http.StatusOK, gin.H{"user": profile(user)})
+}
+
+// ChangePassword sets a new password and revokes every other session.
+func ChangePassword(c *gin.Context) {
+	var input ChangePasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "current_password and new_password are required"})
+		return
+	}
+	if msg := utils.ValidatePassword(input.NewPassword); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)) != nil {
+		auditRequest(c, models.AuditAccountPasswordChanged, models.AuditOutcomeFailure, models.JSONMap{"reason": "bad_password"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), passwordHashCost)
+	if err != nil {
+		utils.Logger.Error("Password hashing failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password hashing failed"})
+		return
+	}
+	if err := database.DB.Model(user).Update("password", string(hashedPassword)).Error; err != nil {
+		utils.Logger.Error("Failed to change password", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	revoked, err := revokeUserSessions(c.Request.Context(), c.GetString("user_id"), c.GetString("session_id"), c.GetString("access_token"))
+	if err != nil {
+		utils.Logger.Error("Failed to revoke sessions after password change", zap.Error(err))
+	}
+
+	utils.Logger.Info("Password changed", zap.Uint("user_id", user.ID), zap.Int64("revoked_sessions", revoked))
+	auditRequest(c, models.AuditAccountPasswordChanged, models.AuditOutcomeSuccess, models.JSONMap{"revoked_sessions": revoked})
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed", "revoked_sessions": revoked})
+}
+
+// DeleteAccount soft-deletes the user after re-checking the password and
+// revokes all of their tokens.
+func DeleteAccount(c *gin.Context) {
+	var input DeleteAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password is required"})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)) != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("status", models.UserStatusDeleted).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to delete account", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+
+	if _, err := revokeUserSessions(c.Request.Context(), c.GetString("user_id"), "", ""); err != nil {
+		utils.Logger.Error("Failed to revoke sessions after account deletion", zap.Error(err))
+	}
+	if _, err := revokeAPIKeys(c.Request.Context(), "user_id = ?", user.ID); err != nil {
+		utils.Logger.Error("Failed to revoke API keys after account deletion", zap.Error(err))
+	}
+
+	utils.Logger.Info("Account deleted", zap.Uint("user_id", user.ID))
+	auditRequest(c, models.AuditAccountDeleted, models.AuditOutcomeSuccess, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	oauth2Errors "github.com/go-oauth2/oauth2/v4/errors"
+	oauth2Server "github.com/go-oauth2/oauth2/v4/server"
+	jwt "github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+var (
+	// APIKeyMaxTTL caps how long an API key can be valid.
+	APIKeyMaxTTL = 365 * 24 * time.Hour
+	// APIKeyTokenTTL is the lifetime of access tokens issued for API keys.
+	APIKeyTokenTTL = 15 * time.Minute
+)
+
+const (
+	apiKeyDefaultTTL = 90 * 24 * time.Hour
+	// maxAPIKeysPerUser counts keys that are neither revoked nor expired.
+	maxAPIKeysPerUser = 25
+	// apiKeyUsageInterval throttles last_used_at updates.
+	apiKeyUsageInterval = time.Minute
+	// amrAPIKey marks tokens obtained with an API key instead of a login.
+	amrAPIKey = "api_key"
+)
+
+var errInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+
+type APIKeyInput struct {
+	Name      string `json:"name" binding:"required"`
+	Scope     string `json:"scope"`
+	ExpiresIn string `json:"expires_in"` // Go duration, e.g. "720h"
+}
+
+// tokenAMR returns the amr claim of the token that authenticated c.
+func tokenAMR(c *gin.Context) []string {
+	var amr []string
+	if claims, ok := c.Value("claims").(jwt.MapClaims); ok {
+		values, _ := claims["amr"].([]interface{})
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				amr = append(amr, s)
+			}
+		}
+	}
+	return amr
+}
+
+// lookupAPIKey returns the usable key and its active owner.
+func lookupAPIKey(key string) (*models.APIKey, *models.User, error) {
+	if !utils.IsAPIKey(key) {
+		return nil, nil, errInvalidAPIKey
+	}
+	var apiKey models.APIKey
+	if err := database.DB.First(&apiKey, "key_hash = ?", utils.HashToken(key)).Error; err != nil {
+		return nil, nil, errInvalidAPIKey
+	}
+	now := time.Now()
+	if !apiKey.IsUsable(now) {
+		return nil, nil, errInvalidAPIKey
+	}
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", apiKey.UserID).Error; err != nil || !user.IsActive() {
+		return nil, nil, errInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyUsageInterval {
+		if err := database.DB.Model(&apiKey).Update("last_used_at", now).Error; err != nil {
+			utils.Logger.Error("Failed to record API key use", zap.Error(err))
+		}
+	}
+	return &apiKey, &user, nil
+}
+
+// CreateAPIKey creates a named, scoped, expiring key for the current user.
+// The key is only returned in this response.
+func CreateAPIKey(c *gin.Context) {
+	var input APIKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	name := strings.TrimSpace(input.Name)
+	if name == "" || len(name) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be between 1 and 64 characters"})
+		return
+	}
+	ttl := apiKeyDefaultTTL
+	if input.ExpiresIn != "" {
+		d, err := time.ParseDuration(input.ExpiresIn)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be a positive duration such as 720h"})
+			return
+		}
+		ttl = d
+	}
+	if ttl > APIKeyMaxTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in must be at most %s", APIKeyMaxTTL)})
+		return
+	}
+
+	// Keys cannot be used to mint further keys, nor widen the caller's scope.
+	if slices.Contains(tokenAMR(c), amrAPIKey) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot create API keys"})
+		return
+	}
+	scope, ok := utils.DownscopeScope(c.GetString("scope"), input.Scope)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be a subset of the current token's scope"})
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	var count int64
+	database.DB.Model(&models.APIKey{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Count(&count)
+	if count >= maxAPIKeysPerUser {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("At most %d API keys can be active", maxAPIKeysPerUser)})
+		return
+	}
+
+	key, keyID, err := utils.GenerateAPIKey()
+	if err != nil {
+		utils.Logger.Error("Failed to generate API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	apiKey := models.APIKey{
+		UserID:    user.ID,
+		TenantID:  user.TenantID,
+		Name:      name,
+		KeyID:     keyID,
+		KeyHash:   utils.HashToken(key),
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := database.DB.Create(&apiKey).Error; err != nil {
+		utils.Logger.Error("Failed to create API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	utils.Logger.Info("API key created", zap.Uint("user_id", user.ID), zap.String("key_id", keyID))
+	auditRequest(c, models.AuditAPIKeyCreated, models.AuditOutcomeSuccess,
+		models.JSONMap{"api_key_id": apiKey.ID, "key_id": keyID, "name": name, "scope": scope, "expires_at": apiKey.ExpiresAt})
+	c.JSON(http.StatusCreated, gin.H{"api_key": key, "key": apiKey})
+}
+
+// ListAPIKeys returns the current user's keys without their values.
+func ListAPIKeys(c *gin.Context) {
+	var keys []models.APIKey
+	if err := database.DB.Where("user_id = ?", c.GetString("user_id")).Order("created_at DESC").Find(&keys).Error; err != nil {
+		utils.Logger.Error("Failed to list API keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// RevokeAPIKey revokes one of the current user's keys together with the
+// access tokens issued for it.
+func RevokeAPIKey(c *gin.Context) {
+	userID := c.GetString("user_id")
+	var apiKey models.APIKey
+	if err := database.DB.First(&apiKey, "id = ? AND user_id = ?", c.Param("id"), userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	revokedTokens, err := revokeAPIKeys(c.Request.Context(), "id = ?", apiKey.ID)
+	if err != nil {
+		utils.Logger.Error("Failed to revoke API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	utils.Logger.Info("API key revoked", zap.String("user_id", userID), zap.String("key_id", apiKey.KeyID))
+	auditRequest(c, models.AuditAPIKeyRevoked, models.AuditOutcomeSuccess,
+		models.JSONMap{"api_key_id": apiKey.ID, "key_id": apiKey.KeyID, "revoked_tokens": revokedTokens})
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked", "revoked_tokens": revokedTokens})
+}
+
+// revokeAPIKeys marks the keys matched by the condition as revoked and
+// revokes the access tokens issued for them.
+func revokeAPIKeys(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var ids []string
+	if err := database.DB.Model(&models.APIKey{}).Where(query, args...).Where("revoked_at IS NULL").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := database.DB.Model(&models.APIKey{}).Where("id IN ?", ids).Update("revoked_at", time.Now()).Error; err != nil {
+		return 0, err
+	}
+	return revokeTokens(ctx, database.DB.Where("api_key_id IN ?", ids))
+}
+
+// revokeAPIKeyToken handles an API key sent to the revocation endpoint, for
+// example by a secret scanner that found it.
+func revokeAPIKeyToken(c *gin.Context, key string) {
+	details := models.JSONMap{"token_id": tokenFingerprint(key)}
+	var apiKey models.APIKey
+	if err := database.DB.First(&apiKey, "key_hash = ?", utils.HashToken(key)).Error; err != nil {
+		auditRequest(c, models.AuditAPIKeyRevoked, models.AuditOutcomeFailure, details)
+		c.Status(http.StatusOK)
+		return
+	}
+
+	details["api_key_id"], details["key_id"] = apiKey.ID, apiKey.KeyID
+	revokedTokens, err := revokeAPIKeys(c.Request.Context(), "id = ?", apiKey.ID)
+	outcome := models.AuditOutcomeSuccess
+	if err != nil {
+		utils.Logger.Error("Failed to revoke API key", zap.Error(err))
+		outcome = models.AuditOutcomeFailure
+		details["error"] = err.Error()
+	}
+	details["revoked_tokens"] = revokedTokens
+	auditRequestFor(c, fmt.Sprint(apiKey.UserID), models.AuditAPIKeyRevoked, outcome, details)
+	c.Status(http.StatusOK)
+}
+
+// apiKeyGrant issues a short-lived access token for an API key. The token
+// has the key's owner as subject and at most the key's scope, and no
+// refresh token: the client presents the key again instead.
+func apiKeyGrant(srv *oauth2Server.Server, r *http.Request, info *utils.TokenRequestInfo) (oauth2.TokenInfo, error) {
+	clientID, clientSecret, err := srv.ClientInfoHandler(r)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := srv.Manager.GetClient(r.Context(), clientID)
+	if err != nil || cli == nil || (cli.GetSecret() != "" && cli.GetSecret() != clientSecret) {
+		return nil, oauth2Errors.ErrInvalidClient
+	}
+
+	apiKey, user, err := lookupAPIKey(r.FormValue("api_key"))
+	if err != nil {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+	return issueAPIKeyToken(srv, r, info, clientID, clientSecret, apiKey, user, APIKeyTokenTTL)
+}
+
+// issueAPIKeyToken generates a token for the key's owner that lives at most
+// ttl and never outlives the key.
+func issueAPIKeyToken(srv *oauth2Server.Server, r *http.Request, info *utils.TokenRequestInfo,
+	clientID, clientSecret string, apiKey *models.APIKey, user *models.User, ttl time.Duration) (oauth2.TokenInfo, error) {
+	if !clientAllowsTenant(clientID, user.TenantID) {
+		return nil, utils.ErrInvalidTenant
+	}
+	scope, ok := utils.DownscopeScope(apiKey.Scope, info.Scope)
+	if !ok {
+		return nil, oauth2Errors.ErrInvalidScope
+	}
+
+	if remaining := time.Until(apiKey.ExpiresAt); remaining < ttl {
+		ttl = remaining
+	}
+	info.AMR = []string{amrAPIKey}
+	info.TenantID = tenantClaim(user.TenantID)
+	info.APIKeyID = fmt.Sprint(apiKey.ID)
+
+	tgr := &oauth2.TokenGenerateRequest{
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		UserID:         fmt.Sprint(user.ID),
+		Scope:          scope,
+		AccessTokenExp: ttl,
+		Request:        r,
+	}
+	return srv.Manager.GenerateAccessToken(r.Context(), oauth2.GrantType(utils.GrantTypeAPIKey), tgr)
+}
+
+// introspectAPIKey answers introspection for an API key presented directly
+// to a resource server.
+func introspectAPIKey(key string) gin.H {
+	apiKey, user, err := lookupAPIKey(key)
+	if err != nil {
+		return gin.H{"active": false}
+	}
+	return gin.H{
+		"active":     true,
+		"token_type": "api_key",
+		"sub":        fmt.Sprint(user.ID),
+		"scope":      apiKey.Scope,
+		"iat":        apiKey.CreatedAt.Unix(),
+		"exp":        apiKey.ExpiresAt.Unix(),
+		"amr":        []string{amrAPIKey},
+		"tenant_id":  tenantClaim(user.TenantID),
+		"api_key_id": fmt.Sprint(apiKey.ID),
+	}
+}
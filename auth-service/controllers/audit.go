@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+func writeAudit(event models.AuditEvent) {
+	if err := database.DB.Create(&event).Error; err != nil {
+		utils.Logger.Error("Failed to write audit event", zap.String("event", event.Event), zap.Error(err))
+	}
+}
+
+// auditRequest records an event for an HTTP request handled by gin. The
+// actor and client come from the authenticated token, if any.
+func auditRequest(c *gin.Context, event, outcome string, details models.JSONMap) {
+	auditRequestFor(c, c.GetString("user_id"), event, outcome, details)
+}
+
+// auditRequestFor is auditRequest for public endpoints, where the actor is
+// identified by the request body rather than a token.
+func auditRequestFor(c *gin.Context, actorID, event, outcome string, details models.JSONMap) {
+	writeAudit(models.AuditEvent{
+		Event:     event,
+		Outcome:   outcome,
+		ActorID:   actorID,
+		ClientID:  c.GetString("client_id"),
+		TenantID:  c.GetString("tenant_id"),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Details:   details,
+	})
+}
+
+// auditTokenRequest records an event raised while serving /oauth/token.
+func auditTokenRequest(info *utils.TokenRequestInfo, event, outcome, actorID, clientID string, details models.JSONMap) {
+	writeAudit(models.AuditEvent{
+		Event:     event,
+		Outcome:   outcome,
+		ActorID:   actorID,
+		ClientID:  clientID,
+		TenantID:  info.TenantID,
+		IP:        info.ClientIP,
+		UserAgent: info.UserAgent,
+		Details:   details,
+	})
+}
+
+// AuditSystem records an event that is not tied to a request, such as
+// clients seeded at startup.
+func AuditSystem(event string, details models.JSONMap) {
+	writeAudit(models.AuditEvent{Event: event, Outcome: models.AuditOutcomeSuccess, ActorID: "system", Details: details})
+}
+
+// tokenFingerprint identifies a token in the audit log without storing it.
+func tokenFingerprint(token string) string {
+	return utils.HashToken(token)[:16]
+}
+
+// auditQuery applies the filters shared by ListAuditEvents and
+// ExportAuditEvents. Tenant admins only see their own tenant's events;
+// platform admins may filter by tenant_id.
+func auditQuery(c *gin.Context) (*gorm.DB, error) {
+	query := database.DB.Model(&models.AuditEvent{})
+	if !c.GetBool("platform_admin") {
+		query = query.Where("tenant_id = ?", tenantClaim(adminTenantID(c)))
+	} else if v := c.Query("tenant_id"); v != "" {
+		query = query.Where("tenant_id = ?", v)
+	}
+	for param, column := range map[string]string{
+		"event":     "event",
+		"outcome":   "outcome",
+		"actor_id":  "actor_id",
+		"client_id": "client_id",
+		"ip":        "ip",
+	} {
+		if v := c.Query(param); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+	for param, op := range map[string]string{"from": ">=", "to": "<"} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			query = query.Where("created_at "+op+" ?", t)
+		}
+	}
+	return query, nil
+}
+
+// ListAuditEvents returns audit events newest first. Pass the returned
+// next_before_id as before_id to fetch the following page.
+func ListAuditEvents(c *gin.Context) {
+	query, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(auditDefaultLimit)))
+	if limit <= 0 || limit > auditMaxLimit {
+		limit = auditDefaultLimit
+	}
+	if v := c.Query("before_id"); v != "" {
+		beforeID, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before_id must be a number"})
+			return
+		}
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("id DESC").Limit(limit).Find(&events).Error; err != nil {
+		utils.Logger.Error("Failed to query audit events", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query audit events"})
+		return
+	}
+
+	resp := gin.H{"events": events}
+	if len(events) == limit {
+		resp["next_before_id"] = events[len(events)-1].ID
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// ExportAuditEvents streams matching events oldest first as CSV or NDJSON.
+func ExportAuditEvents(c *gin.Context) {
+	query, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "ndjson")
+	if format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"})
+		return
+	}
+
+	rows, err := query.Order("id ASC").Rows()
+	if err != nil {
+		utils.Logger.Error("Failed to export audit events", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export audit events"})
+		return
+	}
+	defer rows.Close()
+
+	filename := "audit-" + time.Now().UTC().Format("20060102T150405Z") + "." + format
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	var csvWriter *csv.Writer
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+		csvWriter = csv.NewWriter(c.Writer)
+		csvWriter.Write([]string{"id", "created_at", "event", "outcome", "actor_id", "client_id", "ip", "user_agent", "details"})
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	encoder := json.NewEncoder(c.Writer)
+
+	count := 0
+	for rows.Next() {
+		var event models.AuditEvent
+		if err := database.DB.ScanRows(rows, &event); err != nil {
+			utils.Logger.Error("Failed to scan audit event", zap.Error(err))
+			break
+		}
+		if csvWriter != nil {
+			details, _ := json.Marshal(event.Details)
+			csvWriter.Write([]string{
+				strconv.FormatUint(uint64(event.ID), 10),
+				event.CreatedAt.UTC().Format(time.RFC3339Nano),
+				event.Event, event.Outcome, event.ActorID, event.ClientID, event.IP, event.UserAgent,
+				string(details),
+			})
+		} else {
+			encoder.Encode(event)
+		}
+		if count++; count%500 == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			c.Writer.Flush()
+		}
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+}
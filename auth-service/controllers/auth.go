@@ -1,42 +1,263 @@
 package controllers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	oauth2Errors "github.com/go-oauth2/oauth2/v4/errors"
 	"go.uber.org/zap"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+const passwordHashCost = 14
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash is compared against when the username is unknown, so the
+// response time does not reveal whether an account exists.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), passwordHashCost)
+	})
+	return dummyHash
+}
+
+// RegisterInput is the only data a client can supply when registering.
+type RegisterInput struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Email        string `json:"email"`
+	DisplayName  string `json:"display_name"`
+	InviteCode   string `json:"invite_code"`
+	CaptchaToken string `json:"captcha_token"`
+	Tenant       string `json:"tenant"`
+}
+
+var (
+	// InviteOnly requires a valid invite code to register.
+	InviteOnly bool
+	// Captcha, when set, must accept the captcha_token of every registration.
+	Captcha utils.CaptchaVerifier
+)
+
+func registrationError(c *gin.Context, status int, code, message string, fields map[string]string) {
+	resp := gin.H{"error": code, "message": message}
+	if len(fields) > 0 {
+		resp["fields"] = fields
+	}
+	c.JSON(status, resp)
+}
+
 func Register(c *gin.Context) {
-	var input models.User
+	var input RegisterInput
 	if err := c.ShouldBindJSON(&input); err != nil {
-		utils.Logger.Error("Error on registering user", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		utils.Logger.Warn("Malformed registration request", zap.Error(err))
+		registrationError(c, http.StatusBadRequest, "invalid_request", "Request body must be a JSON object", nil)
+		return
+	}
+
+	username := utils.NormalizeUsername(input.Username)
+	email := utils.NormalizeEmail(input.Email)
+	displayName := strings.TrimSpace(input.DisplayName)
+
+	fields := map[string]string{}
+	if msg := utils.ValidateUsername(username); msg != "" {
+		fields["username"] = msg
+	}
+	if msg := utils.ValidatePassword(input.Password); msg != "" {
+		fields["password"] = msg
+	}
+	if email != "" {
+		if msg := utils.ValidateEmail(email); msg != "" {
+			fields["email"] = msg
+		}
+	}
+	if len(displayName) > 64 {
+		fields["display_name"] = "display_name must be at most 64 characters"
+	}
+	if InviteOnly && input.InviteCode == "" {
+		fields["invite_code"] = "invite_code is required"
+	}
+	tenant, err := resolveTenant("", input.Tenant)
+	if err != nil {
+		fields["tenant"] = "tenant does not exist"
+	}
+	if len(fields) > 0 {
+		registrationError(c, http.StatusBadRequest, "validation_failed", "Some fields are invalid", fields)
 		return
 	}
 
+	if Captcha != nil {
+		ok, err := Captcha.Verify(c.Request.Context(), input.CaptchaToken, c.ClientIP())
+		if err != nil {
+			utils.Logger.Error("Captcha verification failed", zap.Error(err))
+			registrationError(c, http.StatusServiceUnavailable, "captcha_unavailable", "Captcha could not be verified, try again later", nil)
+			return
+		}
+		if !ok {
+			registrationError(c, http.StatusBadRequest, "captcha_failed", "Captcha verification failed", nil)
+			return
+		}
+	}
+
+	var count int64
+	database.DB.Unscoped().Model(&models.User{}).Where("tenant_id = ? AND LOWER(username) = ?", tenant.ID, username).Count(&count)
+	if count > 0 {
+		registrationError(c, http.StatusConflict, "username_taken", "Username is already taken", nil)
+		return
+	}
+	if email != "" {
+		database.DB.Model(&models.User{}).Where("tenant_id = ? AND email = ?", tenant.ID, email).Count(&count)
+		if count > 0 {
+			registrationError(c, http.StatusConflict, "email_taken", "Email is already in use", nil)
+			return
+		}
+	}
+
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), 14)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), passwordHashCost)
 	if err != nil {
 		utils.Logger.Error("Password hashing failed", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password hashing failed"})
+		registrationError(c, http.StatusInternalServerError, "server_error", "Password hashing failed", nil)
 		return
 	}
 
-	user := models.User{Username: input.Username, Password: string(hashedPassword)}
-	result := database.DB.Create(&user)
+	user := models.User{TenantID: tenant.ID, Username: username, Password: string(hashedPassword), Email: email, DisplayName: displayName}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if InviteOnly {
+			if err := consumeInvite(tx, input.InviteCode, tenant.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&user).Error
+	})
 
-	if result.Error != nil {
-		utils.Logger.Error("Error on registering user", zap.Error(result.Error))
-		c.JSON(http.StatusBadRequest, gin.H{"error": result.Error.Error()})
+	switch {
+	case errors.Is(err, errInvalidInvite):
+		registrationError(c, http.StatusForbidden, "invalid_invite", "Invite code is invalid, expired or used up", nil)
 		return
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		registrationError(c, http.StatusConflict, "username_taken", "Username or email is already in use", nil)
+		return
+	case err != nil:
+		utils.Logger.Error("Error on registering user", zap.Error(err))
+		registrationError(c, http.StatusInternalServerError, "server_error", "Could not register user", nil)
+		return
+	}
+
+	if user.Email != "" {
+		if err := sendVerificationEmail(c.Request.Context(), &user); err != nil {
+			utils.Logger.Error("Failed to send verification email", zap.Error(err))
+		}
 	}
 
-	utils.Logger.Info("User registered", zap.String("username", user.Username))
+	utils.Logger.Info("User registered", zap.String("username", user.Username), zap.String("tenant", tenant.Slug))
+	c.Set("tenant_id", tenantClaim(tenant.ID))
+	auditRequestFor(c, fmt.Sprint(user.ID), models.AuditAccountRegistered, models.AuditOutcomeSuccess,
+		models.JSONMap{"username": user.Username, "tenant": tenant.Slug, "invite": input.InviteCode != ""})
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered"})
 }
+
+// PasswordAuthorizationHandler verifies the resource owner credentials of the
+// password grant, applying the brute-force lockout policy. Users with MFA
+// enabled must also send "otp" or "recovery_code", otherwise the grant fails
+// with mfa_required and an mfa_token for the mfa-otp grant.
+func PasswordAuthorizationHandler(ctx context.Context, clientID, username, password string) (string, error) {
+	info := utils.TokenRequestInfoFrom(ctx)
+	ip := info.ClientIP
+
+	tenant, err := resolveTenant(clientID, info.Tenant)
+	if err != nil {
+		auditTokenRequest(info, models.AuditLoginFailure, models.AuditOutcomeFailure, "", clientID,
+			models.JSONMap{"username": username, "tenant": info.Tenant, "reason": "invalid_tenant"})
+		return "", err
+	}
+	info.TenantID = tenantClaim(tenant.ID)
+
+	keys := []string{userLockKey(tenant.ID, username)}
+	if ip != "" {
+		keys = append(keys, ipLockKey(ip))
+	}
+	if retry := lockedFor(keys...); retry > 0 {
+		utils.Logger.Warn("Login attempt while locked", zap.String("client_id", clientID), zap.String("ip", ip))
+		auditTokenRequest(info, models.AuditLoginFailure, models.AuditOutcomeFailure, "", clientID,
+			models.JSONMap{"username": username, "reason": "locked"})
+		return "", &utils.LockoutError{RetryAfter: retry}
+	}
+
+	var user models.User
+	found := database.DB.First(&user, "tenant_id = ? AND LOWER(username) = ?", tenant.ID, utils.NormalizeUsername(username)).Error == nil
+	hash := dummyPasswordHash()
+	if found {
+		hash = []byte(user.Password)
+	}
+
+	passwordOK := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+	if !found {
+		registerLoginFailure(info, clientID, tenant.ID, username, "", "unknown_user")
+		return "", oauth2Errors.ErrInvalidGrant
+	}
+	userID := fmt.Sprint(user.ID)
+	if !passwordOK {
+		registerLoginFailure(info, clientID, tenant.ID, username, userID, "bad_password")
+		return "", oauth2Errors.ErrInvalidGrant
+	}
+
+	if !user.IsActive() {
+		utils.Logger.Warn("Login attempt on inactive account", zap.Uint("user_id", user.ID))
+		auditTokenRequest(info, models.AuditLoginFailure, models.AuditOutcomeFailure, userID, clientID,
+			models.JSONMap{"username": username, "reason": "inactive"})
+		return "", oauth2Errors.ErrInvalidGrant
+	}
+
+	info.AMR = []string{"pwd"}
+
+	if user.MFAEnabled {
+		if info.OTP == "" && info.RecoveryCode == "" {
+			mfaToken, err := utils.NewMFAToken(userID, clientID, info.Scope)
+			if err != nil {
+				return "", err
+			}
+			return "", &utils.MFARequiredError{MFAToken: mfaToken}
+		}
+		amr, ok := verifySecondFactor(&user, info.OTP, info.RecoveryCode)
+		if !ok {
+			registerLoginFailure(info, clientID, tenant.ID, username, userID, "bad_otp")
+			return "", oauth2Errors.ErrInvalidGrant
+		}
+		info.AMR = amr
+	}
+
+	if err := emailVerificationError(clientID, &user); err != nil {
+		auditTokenRequest(info, models.AuditLoginFailure, models.AuditOutcomeFailure, userID, clientID,
+			models.JSONMap{"username": username, "reason": "email_not_verified"})
+		return "", err
+	}
+
+	clearLoginFailures(tenant.ID, username)
+	recordLogin(&user)
+	startSession(info, &user, clientID)
+	auditTokenRequest(info, models.AuditLoginSuccess, models.AuditOutcomeSuccess, userID, clientID,
+		models.JSONMap{"amr": info.AMR, "session_id": info.SessionID})
+	return userID, nil
+}
+
+func recordLogin(user *models.User) {
+	if err := database.DB.Model(user).Update("last_login_at", time.Now()).Error; err != nil {
+		utils.Logger.Error("Failed to record last login", zap.Error(err))
+	}
+}
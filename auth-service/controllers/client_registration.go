@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	oauth2Models "github.com/go-oauth2/oauth2/v4/models"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	// ClientRegistrationToken is the initial access token that authorizes
+	// dynamic client registration. Registration is disabled when empty.
+	ClientRegistrationToken string
+	// RegistrationScopes limits the scopes a registered client may ask for;
+	// empty allows any scope.
+	RegistrationScopes []string
+)
+
+// ClientRegistry is the part of the go-oauth2-pg client store used by
+// dynamic registration.
+type ClientRegistry interface {
+	oauth2.ClientStore
+	Create(info oauth2.ClientInfo) error
+}
+
+// ClientUpdateInput is an RFC 7592 update request: the full metadata plus
+// the client's own identifiers.
+type ClientUpdateInput struct {
+	utils.ClientMetadata
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+func clientRegistrationError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func invalidRegistrationToken(c *gin.Context) {
+	c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	clientRegistrationError(c, http.StatusUnauthorized, "invalid_token", "The access token is missing or invalid")
+}
+
+// registeredClientMetadata returns the metadata of a dynamically registered
+// client, or nil for seeded clients and unknown IDs.
+func registeredClientMetadata(clientID string) *utils.ClientMetadata {
+	var rc models.RegisteredClient
+	if clientID == "" || database.DB.First(&rc, "client_id = ?", clientID).Error != nil {
+		return nil
+	}
+	var meta utils.ClientMetadata
+	if err := json.Unmarshal(rc.Metadata, &meta); err != nil {
+		utils.Logger.Error("Failed to decode client metadata", zap.String("client_id", clientID), zap.Error(err))
+		return &utils.ClientMetadata{}
+	}
+	return &meta
+}
+
+// clientAllowsGrant reports whether clientID may use grantType. Seeded
+// clients are not restricted; registered ones only get the grants they
+// registered.
+func clientAllowsGrant(clientID, grantType string) bool {
+	meta := registeredClientMetadata(clientID)
+	return meta == nil || slices.Contains(meta.GrantTypes, grantType)
+}
+
+// clientAllowsScope reports whether every requested scope was registered by
+// the client. Seeded clients are not restricted.
+func clientAllowsScope(clientID, scope string) bool {
+	meta := registeredClientMetadata(clientID)
+	if meta == nil {
+		return true
+	}
+	_, ok := utils.DownscopeScope(meta.Scope, scope)
+	return ok
+}
+
+// ClientScopeHandler restricts the scope of tokens issued by go-oauth2 to
+// the scope a registered client asked for.
+func ClientScopeHandler(tgr *oauth2.TokenGenerateRequest) (bool, error) {
+	return clientAllowsScope(tgr.ClientID, tgr.Scope), nil
+}
+
+// clientInformation is the RFC 7591 section 3.2.1 response.
+func clientInformation(client oauth2.ClientInfo, meta *utils.ClientMetadata, rc *models.RegisteredClient, registrationToken string) gin.H {
+	resp := gin.H{
+		"client_id":                 client.GetID(),
+		"client_id_issued_at":       rc.CreatedAt.Unix(),
+		"registration_access_token": registrationToken,
+		"registration_client_uri":   PublicURL + "/oauth/register/" + client.GetID(),
+	}
+	if secret := client.GetSecret(); secret != "" {
+		resp["client_secret"] = secret
+		resp["client_secret_expires_at"] = 0
+	}
+
+	raw, _ := json.Marshal(meta)
+	var fields map[string]interface{}
+	_ = json.Unmarshal(raw, &fields)
+	for k, v := range fields {
+		resp[k] = v
+	}
+	return resp
+}
+
+// storeClient builds the go-oauth2 client for the metadata, keeping secret
+// for confidential clients.
+func storeClient(clientID, secret string, meta *utils.ClientMetadata) *oauth2Models.Client {
+	client := &oauth2Models.Client{ID: clientID, Domain: meta.RedirectDomain()}
+	if meta.TokenEndpointAuthMethod == utils.AuthMethodNone {
+		client.Public = true
+	} else {
+		client.Secret = secret
+	}
+	return client
+}
+
+// RegisterClient serves the RFC 7591 client registration endpoint. The
+// caller must present the initial access token as a bearer token.
+func RegisterClient(store ClientRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if ClientRegistrationToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(ClientRegistrationToken)) != 1 {
+			invalidRegistrationToken(c)
+			return
+		}
+
+		var meta utils.ClientMetadata
+		if err := c.ShouldBindJSON(&meta); err != nil {
+			clientRegistrationError(c, http.StatusBadRequest, "invalid_client_metadata", "The request body must be a JSON object")
+			return
+		}
+		if err := meta.Normalize(RegistrationScopes); err != nil {
+			clientRegistrationError(c, http.StatusBadRequest, err.Code, err.Description)
+			return
+		}
+
+		secret, err := utils.RandomToken(32)
+		if err != nil {
+			utils.Logger.Error("Failed to generate client secret", zap.Error(err))
+			clientRegistrationError(c, http.StatusInternalServerError, "server_error", "Failed to register client")
+			return
+		}
+		registrationToken, err := utils.RandomToken(32)
+		if err != nil {
+			utils.Logger.Error("Failed to generate registration access token", zap.Error(err))
+			clientRegistrationError(c, http.StatusInternalServerError, "server_error", "Failed to register client")
+			return
+		}
+
+		client := storeClient(uuid.New().String(), secret, &meta)
+		raw, _ := json.Marshal(meta)
+		rc := models.RegisteredClient{
+			ClientID:              client.ID,
+			Metadata:              raw,
+			RegistrationTokenHash: utils.HashToken(registrationToken),
+		}
+		if err := database.DB.Create(&rc).Error; err != nil {
+			utils.Logger.Error("Failed to save client metadata", zap.Error(err))
+			clientRegistrationError(c, http.StatusInternalServerError, "server_error", "Failed to register client")
+			return
+		}
+		if err := store.Create(client); err != nil {
+			database.DB.Delete(&rc)
+			utils.Logger.Error("Failed to create client", zap.Error(err))
+			clientRegistrationError(c, http.StatusInternalServerError, "server_error", "Failed to register client")
+			return
+		}
+
+		utils.Logger.Info("Client registered", zap.String("client_id", client.ID), zap.Strings("grant_types", meta.GrantTypes))
+		auditRequest(c, models.AuditClientCreated, models.AuditOutcomeSuccess, models.JSONMap{
+			"client_id": client.ID, "client_name": meta.ClientName, "grant_types": meta.GrantTypes, "scope": meta.Scope, "dynamic": true,
+		})
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusCreated, clientInformation(client, &meta, &rc, registrationToken))
+	}
+}
+
+// registrationAccess authenticates an RFC 7592 request: the bearer token
+// must be the registration access token of the client in the path.
+func registrationAccess(c *gin.Context) (*models.RegisteredClient, string, bool) {
+	token := bearerToken(c)
+	var rc models.RegisteredClient
+	if token == "" || database.DB.First(&rc, "registration_token_hash = ?", utils.HashToken(token)).Error != nil ||
+		rc.ClientID != c.Param("client_id") {
+		invalidRegistrationToken(c)
+		return nil, "", false
+	}
+	return &rc, token, true
+}
+
+// GetRegisteredClient returns the current registration of a client.
+func GetRegisteredClient(store ClientRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc, token, ok := registrationAccess(c)
+		if !ok {
+			return
+		}
+		client, err := store.GetByID(c.Request.Context(), rc.ClientID)
+		if err != nil || client == nil {
+			invalidRegistrationToken(c)
+			return
+		}
+		var meta utils.ClientMetadata
+		if err := json.Unmarshal(rc.Metadata, &meta); err != nil {
+			utils.Logger.Error("Failed to decode client metadata", zap.String("client_id", rc.ClientID), zap.Error(err))
+			clientRegistrationError(c, http.StatusInternalServerError, "server_error", "Failed to read client")
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, clientInformation(client, &meta, rc, token))
+	}
+}
+
+// UpdateRegisteredClient replaces the metadata of a client. Switching
+// between a public and a confidential client issues or drops the secret.
+func UpdateRegisteredClient(store ClientRegistry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc, token, ok := registrationAccess(c)
+		if !ok {
+			return
+		}
+		var input ClientUpdateInput
+		if err := c.ShouldBindJSON(&input); err != nil {
+			clientRegistrationError(c, http.StatusBadRequest, "invalid_client_metadata", "The request body must be a JSON object")
+			return
+		}
+		current, err := store.GetByID(c.Request.Context(), rc.ClientID)
+		if err != nil || current == nil {
+			invalidRegistrationToken(c)
+			return
+		}
+		if input.ClientID != rc.ClientID || (input.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(input.ClientSecret), []byte(current.GetSecret())) != 1) {
+			clientRegistrationError(c, http.StatusBadRequest, "invalid_client_metadata", "client_id and client_secret must match the registered client")
+			return
+		}
+		meta := input.ClientMetadata
+		if err := meta.Normalize(RegistrationScopes); err != nil {
+			clientRegistrationError(c, http.StatusBadRequest, err.Code, err.Description)
+			return
+		}
+
+		secret := current.GetSecret()
+		if secret == "" && meta.TokenEndpointAuthMethod != utils.AuthMethodNone {
+			if secret, err = utils.RandomToken(32); err != nil {
+				utils.Logger.Error("Failed to generate client secret", zap.Error(err))
+				clientRegistrationError(c, http.StatusInternalServerError, "server_error", "Failed to update client")
+				return
+			}
+		}
+		client := storeClient(rc.ClientID, secret, &meta)
+		data, _ := json.Marshal(client)
+		raw, _ := json.Marshal(meta)
+
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&models.OAuthClient{ID: client.ID}).
+				Updates(map[string]interface{}{"secret": client.Secret, "domain": client.Domain, "data": data}).Error
+			if err != nil {
+				return err
+			}
+			rc.Metadata = raw
+			rc.UpdatedAt = time.Now()
+			return tx.Save(rc).Error
+		})
+		if err != nil {
+			utils.Logger.Error("Failed to update client", zap.String("client_id", rc.ClientID), zap.Error(err))
+			clientRegistrationError(c, http.StatusInternalServerError, "server_error", "Failed to update client")
+			return
+		}
+
+		utils.Logger.Info("Client updated", zap.String("client_id", client.ID))
+		auditRequest(c, models.AuditClientUpdated, models.AuditOutcomeSuccess, models.JSONMap{
+			"client_id": client.ID, "grant_types": meta.GrantTypes, "scope": meta.Scope,
+		})
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, clientInformation(client, &meta, rc, token))
+	}
+}
+
+// DeleteRegisteredClient removes a client together with its tokens and
+// tenant binding.
+func DeleteRegisteredClient(c *gin.Context) {
+	rc, _, ok := registrationAccess(c)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, q := range []struct {
+			model interface{}
+			where string
+		}{
+			{&models.ClientTenant{}, "client_id = ?"},
+			{&models.OAuthClient{}, "id = ?"},
+			{&models.RegisteredClient{}, "client_id = ?"},
+		} {
+			if err := tx.Where(q.where, rc.ClientID).Delete(q.model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		// Revoke the tokens once the client can no longer be issued new ones.
+		_, err = revokeTokens(c.Request.Context(), database.DB.Where("client_id = ?", rc.ClientID))
+	}
+	if err != nil {
+		utils.Logger.Error("Failed to delete client", zap.String("client_id", rc.ClientID), zap.Error(err))
+		clientRegistrationError(c, http.StatusInternalServerError, "server_error", "Failed to delete client")
+		return
+	}
+
+	utils.Logger.Info("Client deleted", zap.String("client_id", rc.ClientID))
+	auditRequest(c, models.AuditClientDeleted, models.AuditOutcomeSuccess, models.JSONMap{"client_id": rc.ClientID})
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,405 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	oauth2Errors "github.com/go-oauth2/oauth2/v4/errors"
+	oauth2Server "github.com/go-oauth2/oauth2/v4/server"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var (
+	// DeviceCodeTTL is how long a device has to get the user code approved.
+	DeviceCodeTTL = 10 * time.Minute
+	// DevicePollInterval is the minimum polling interval handed to devices.
+	DevicePollInterval = 5 * time.Second
+)
+
+// slowDownStep is added to a device's interval every time it polls too fast.
+const slowDownStep = 5
+
+// The device page is a form posted with the user's password, so every
+// render carries a fresh CSRF token that must come back both in the form
+// and in this cookie.
+const (
+	deviceCSRFCookie = "device_csrf"
+	deviceCSRFMaxAge = time.Hour
+)
+
+var errInvalidUserCode = errors.New("invalid or expired code")
+
+type DeviceVerifyInput struct {
+	UserCode string `json:"user_code" binding:"required"`
+	Approve  bool   `json:"approve"`
+}
+
+// DeviceAuthorization serves the device authorization endpoint (RFC 8628
+// section 3.1).
+func DeviceAuthorization(srv *oauth2Server.Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, clientSecret, _ := srv.ClientInfoHandler(c.Request)
+		cli, err := srv.Manager.GetClient(c.Request.Context(), clientID)
+		if err != nil || cli == nil || (cli.GetSecret() != "" && cli.GetSecret() != clientSecret) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			return
+		}
+		if !clientAllowsGrant(clientID, utils.GrantTypeDeviceCode) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unauthorized_client"})
+			return
+		}
+		if !clientAllowsScope(clientID, c.PostForm("scope")) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_scope"})
+			return
+		}
+
+		deviceCode, err := utils.RandomToken(32)
+		if err != nil {
+			utils.Logger.Error("Failed to generate device code", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		auth := models.DeviceAuthorization{
+			DeviceCodeHash: utils.HashToken(deviceCode),
+			ClientID:       clientID,
+			Scope:          c.PostForm("scope"),
+			Status:         models.DeviceStatusPending,
+			Interval:       int(DevicePollInterval.Seconds()),
+			ExpiresAt:      time.Now().Add(DeviceCodeTTL),
+		}
+		// User codes are short, so retry on the rare collision.
+		for attempt := 0; attempt < 3; attempt++ {
+			if auth.UserCode, err = utils.GenerateUserCode(); err != nil {
+				break
+			}
+			if err = database.DB.Create(&auth).Error; !errors.Is(err, gorm.ErrDuplicatedKey) {
+				break
+			}
+		}
+		if err != nil {
+			utils.Logger.Error("Failed to create device authorization", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+
+		verificationURI := PublicURL + "/device"
+		writeTokenResponse(c, map[string]interface{}{
+			"device_code":               deviceCode,
+			"user_code":                 auth.UserCode,
+			"verification_uri":          verificationURI,
+			"verification_uri_complete": verificationURI + "?user_code=" + auth.UserCode,
+			"expires_in":                int(DeviceCodeTTL.Seconds()),
+			"interval":                  auth.Interval,
+		}, nil, http.StatusOK)
+	}
+}
+
+// deviceCodeGrant answers a device polling /oauth/token (RFC 8628 section 3.4).
+func deviceCodeGrant(srv *oauth2Server.Server, r *http.Request, info *utils.TokenRequestInfo) (oauth2.TokenInfo, error) {
+	clientID, clientSecret, err := srv.ClientInfoHandler(r)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := srv.Manager.GetClient(r.Context(), clientID)
+	if err != nil || cli == nil || (cli.GetSecret() != "" && cli.GetSecret() != clientSecret) {
+		return nil, oauth2Errors.ErrInvalidClient
+	}
+
+	var auth models.DeviceAuthorization
+	err = database.DB.First(&auth, "device_code_hash = ?", utils.HashToken(r.FormValue("device_code"))).Error
+	if err != nil || auth.ClientID != clientID {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+
+	now := time.Now()
+	if now.After(auth.ExpiresAt) {
+		return nil, utils.ErrExpiredToken
+	}
+
+	switch auth.Status {
+	case models.DeviceStatusDenied:
+		return nil, utils.ErrDeviceAccessDenied
+	case models.DeviceStatusPending:
+		if auth.LastPolledAt != nil && now.Sub(*auth.LastPolledAt) < time.Duration(auth.Interval)*time.Second {
+			database.DB.Model(&auth).Updates(map[string]interface{}{"interval": auth.Interval + slowDownStep, "last_polled_at": now})
+			return nil, utils.ErrSlowDown
+		}
+		database.DB.Model(&auth).Update("last_polled_at", now)
+		return nil, utils.ErrAuthorizationPending
+	case models.DeviceStatusApproved:
+	default:
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+
+	// The device code is single-use.
+	result := database.DB.Model(&models.DeviceAuthorization{}).
+		Where("id = ? AND status = ?", auth.ID, models.DeviceStatusApproved).
+		Update("status", models.DeviceStatusConsumed)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", auth.UserID).Error; err != nil || !user.IsActive() {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+	if !clientAllowsTenant(clientID, user.TenantID) {
+		return nil, utils.ErrInvalidTenant
+	}
+
+	info.AMR = strings.Fields(auth.AMR)
+	info.TenantID = tenantClaim(user.TenantID)
+	startSession(info, &user, clientID)
+	tgr := &oauth2.TokenGenerateRequest{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		UserID:       auth.UserID,
+		Scope:        auth.Scope,
+		Request:      r,
+	}
+	return srv.Manager.GenerateAccessToken(r.Context(), oauth2.PasswordCredentials, tgr)
+}
+
+// pendingDeviceAuthorization looks up an unexpired, undecided request by the
+// code the user typed.
+func pendingDeviceAuthorization(userCode string) (*models.DeviceAuthorization, error) {
+	var auth models.DeviceAuthorization
+	err := database.DB.First(&auth, "user_code = ? AND status = ? AND expires_at > ?",
+		utils.NormalizeUserCode(userCode), models.DeviceStatusPending, time.Now()).Error
+	if err != nil {
+		return nil, errInvalidUserCode
+	}
+	return &auth, nil
+}
+
+// decideDeviceAuthorization records the user's answer. It fails when the
+// request was decided or expired in the meantime.
+func decideDeviceAuthorization(auth *models.DeviceAuthorization, userID string, amr []string, approve bool) error {
+	status := models.DeviceStatusDenied
+	if approve {
+		status = models.DeviceStatusApproved
+	}
+	result := database.DB.Model(&models.DeviceAuthorization{}).
+		Where("id = ? AND status = ? AND expires_at > ?", auth.ID, models.DeviceStatusPending, time.Now()).
+		Updates(map[string]interface{}{"status": status, "user_id": userID, "amr": strings.Join(amr, " ")})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInvalidUserCode
+	}
+	return nil
+}
+
+func auditDeviceDecision(c *gin.Context, auth *models.DeviceAuthorization, userID string, approve bool) {
+	event := models.AuditDeviceApproved
+	if !approve {
+		event = models.AuditDeviceDenied
+	}
+	writeAudit(models.AuditEvent{
+		Event:     event,
+		Outcome:   models.AuditOutcomeSuccess,
+		ActorID:   userID,
+		ClientID:  auth.ClientID,
+		TenantID:  c.GetString("tenant_id"),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Details:   models.JSONMap{"device_authorization_id": auth.ID, "scope": auth.Scope},
+	})
+}
+
+// VerifyDevice lets a signed-in user approve or deny a device by user code.
+func VerifyDevice(c *gin.Context) {
+	var input DeviceVerifyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_code is required"})
+		return
+	}
+	auth, err := pendingDeviceAuthorization(input.UserCode)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if err := decideDeviceAuthorization(auth, userID, tokenAMR(c), input.Approve); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errInvalidUserCode.Error()})
+		return
+	}
+	auditDeviceDecision(c, auth, userID, input.Approve)
+	c.JSON(http.StatusOK, gin.H{"message": "Device decision recorded", "client_id": auth.ClientID, "scope": auth.Scope})
+}
+
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+<h1>Connect a device</h1>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+{{if .Error}}<p style="color:#b00">{{.Error}}</p>{{end}}
+{{if not .Done}}
+<form method="post" action="/device">
+  <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+  <label>Code <input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label><br>
+  {{if .ClientID}}<p>{{.ClientID}} asks for access{{if .Scope}} to: {{.Scope}}{{end}}.</p>{{end}}
+  <label>Organization <input name="tenant" value="{{.Tenant}}" autocomplete="organization" placeholder="default"></label><br>
+  <label>Username <input name="username" value="{{.Username}}" autocomplete="username" required></label><br>
+  <label>Password <input name="password" type="password" autocomplete="current-password" required></label><br>
+  {{if .NeedOTP}}<label>Authenticator code <input name="otp" autocomplete="one-time-code"></label><br>
+  <label>or recovery code <input name="recovery_code" autocomplete="off"></label><br>{{end}}
+  <button name="action" value="approve">Allow</button>
+  <button name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type devicePageData struct {
+	UserCode string
+	ClientID string
+	Scope    string
+	Tenant   string
+	Username string
+	NeedOTP  bool
+	Message  string
+	Error    string
+	Done     bool
+	// CSRFToken is set by renderDevicePage.
+	CSRFToken string
+}
+
+func renderDevicePage(c *gin.Context, status int, data devicePageData) {
+	if !data.Done {
+		token, err := utils.RandomToken(32)
+		if err != nil {
+			utils.Logger.Error("Failed to generate CSRF token", zap.Error(err))
+			c.String(http.StatusInternalServerError, "Internal error")
+			return
+		}
+		data.CSRFToken = token
+		c.SetSameSite(http.SameSiteStrictMode)
+		c.SetCookie(deviceCSRFCookie, token, int(deviceCSRFMaxAge.Seconds()), "/device", "", strings.HasPrefix(PublicURL, "https://"), true)
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := devicePage.Execute(c.Writer, data); err != nil {
+		utils.Logger.Error("Failed to render device page", zap.Error(err))
+	}
+}
+
+// validDeviceCSRF reports whether the posted form carries the CSRF token of
+// the cookie set when it was rendered.
+func validDeviceCSRF(c *gin.Context) bool {
+	cookie, err := c.Cookie(deviceCSRFCookie)
+	token := c.PostForm("csrf_token")
+	return err == nil && token != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(token)) == 1
+}
+
+// DevicePage shows the user code verification form.
+func DevicePage(c *gin.Context) {
+	data := devicePageData{UserCode: c.Query("user_code")}
+	if data.UserCode != "" {
+		if auth, err := pendingDeviceAuthorization(data.UserCode); err == nil {
+			data.UserCode, data.ClientID, data.Scope = auth.UserCode, auth.ClientID, auth.Scope
+		}
+	}
+	renderDevicePage(c, http.StatusOK, data)
+}
+
+// SubmitDevicePage authenticates the user with their password (and second
+// factor) and records whether they allow the device.
+func SubmitDevicePage(c *gin.Context) {
+	data := devicePageData{UserCode: c.PostForm("user_code"), Tenant: c.PostForm("tenant"), Username: c.PostForm("username")}
+	if !validDeviceCSRF(c) {
+		utils.Logger.Warn("Device page CSRF check failed", zap.String("ip", c.ClientIP()))
+		data.Error = "The form has expired, please submit it again."
+		renderDevicePage(c, http.StatusForbidden, data)
+		return
+	}
+	auth, err := pendingDeviceAuthorization(data.UserCode)
+	if err != nil {
+		data.Error = "The code is invalid or has expired."
+		renderDevicePage(c, http.StatusBadRequest, data)
+		return
+	}
+	data.UserCode, data.ClientID, data.Scope = auth.UserCode, auth.ClientID, auth.Scope
+
+	info := &utils.TokenRequestInfo{
+		ClientIP:     c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Scope:        auth.Scope,
+		OTP:          c.PostForm("otp"),
+		RecoveryCode: c.PostForm("recovery_code"),
+		Tenant:       data.Tenant,
+	}
+	ctx := utils.WithTokenRequestInfo(context.Background(), info)
+	userID, err := PasswordAuthorizationHandler(ctx, auth.ClientID, data.Username, c.PostForm("password"))
+
+	var mfaErr *utils.MFARequiredError
+	var lockErr *utils.LockoutError
+	switch {
+	case errors.As(err, &mfaErr):
+		data.NeedOTP = true
+		data.Message = "Enter the code from your authenticator app."
+		renderDevicePage(c, http.StatusOK, data)
+		return
+	case errors.As(err, &lockErr):
+		data.Error = "Too many failed attempts, try again later."
+		renderDevicePage(c, http.StatusTooManyRequests, data)
+		return
+	case errors.Is(err, utils.ErrInvalidTenant):
+		data.Error = "Unknown organization."
+		renderDevicePage(c, http.StatusBadRequest, data)
+		return
+	case errors.Is(err, utils.ErrEmailNotVerified):
+		data.Error = "Verify your email address before connecting this device."
+		renderDevicePage(c, http.StatusForbidden, data)
+		return
+	case err != nil:
+		data.NeedOTP = info.OTP != "" || info.RecoveryCode != ""
+		data.Error = "Invalid username, password or code."
+		renderDevicePage(c, http.StatusUnauthorized, data)
+		return
+	}
+
+	approve := c.PostForm("action") != "deny"
+	if err := decideDeviceAuthorization(auth, userID, info.AMR, approve); err != nil {
+		data.Error = "The code is invalid or has expired."
+		renderDevicePage(c, http.StatusBadRequest, data)
+		return
+	}
+	c.Set("tenant_id", info.TenantID)
+	auditDeviceDecision(c, auth, userID, approve)
+
+	data.Done = true
+	data.Message = "Device connected. You can return to your device."
+	if !approve {
+		data.Message = "Request denied. The device was not connected."
+	}
+	renderDevicePage(c, http.StatusOK, data)
+}
+
+// PurgeExpiredDeviceAuthorizations deletes device requests that expired
+// more than an hour ago, every interval.
+func PurgeExpiredDeviceAuthorizations(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := database.DB.Where("expires_at < ?", time.Now().Add(-time.Hour)).Delete(&models.DeviceAuthorization{}).Error
+		if err != nil {
+			utils.Logger.Error("Failed to purge device authorizations", zap.Error(err))
+		}
+	}
+}
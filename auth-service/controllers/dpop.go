@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/middleware"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/RanggaNehemia/golang-microservices/dpop"
+	"github.com/gin-gonic/gin"
+	oauth2Errors "github.com/go-oauth2/oauth2/v4/errors"
+	oauth2Server "github.com/go-oauth2/oauth2/v4/server"
+)
+
+// DPoPClients must present a DPoP proof at the token endpoint, so all of
+// their tokens are sender-constrained. Other clients may opt in per request.
+var DPoPClients []string
+
+var dpopReplay = dpop.NewReplayCache()
+
+// bindDPoPProof verifies the DPoP header of a token request, if any, and
+// records the key thumbprint so the issued token is bound to it.
+func bindDPoPProof(c *gin.Context, info *utils.TokenRequestInfo) error {
+	proof := c.GetHeader("DPoP")
+	if proof == "" {
+		if slices.Contains(DPoPClients, c.PostForm("client_id")) {
+			return errors.New("DPoP proof required for this client")
+		}
+		return nil
+	}
+
+	now := time.Now()
+	p, err := dpop.VerifyProof(proof, http.MethodPost, PublicURL+c.Request.URL.Path, "", now)
+	if err != nil {
+		return err
+	}
+	if dpopReplay.Seen(p.JTI, now) {
+		return errors.New("DPoP proof has been used before")
+	}
+	info.JKT = p.JKT
+	return nil
+}
+
+// checkRefreshBinding rejects refreshing a token of a revoked session, or a
+// DPoP-bound token without a proof from the same key.
+func checkRefreshBinding(srv *oauth2Server.Server, r *http.Request, info *utils.TokenRequestInfo) error {
+	ti, err := srv.Manager.LoadRefreshToken(r.Context(), r.FormValue("refresh_token"))
+	if err != nil {
+		// Unknown, expired or revoked
+		return oauth2Errors.ErrInvalidGrant
+	}
+	if sid := utils.TokenExtension(ti).Get("sid"); sid != "" && !middleware.SessionActive(sid) {
+		return oauth2Errors.ErrInvalidGrant
+	}
+	if jkt := utils.TokenExtension(ti).Get("jkt"); jkt != "" && jkt != info.JKT {
+		return oauth2Errors.ErrInvalidGrant
+	}
+	return nil
+}
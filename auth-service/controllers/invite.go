@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var errInvalidInvite = errors.New("invalid invite code")
+
+type InviteInput struct {
+	Note      string `json:"note"`
+	MaxUses   int    `json:"max_uses"`
+	ExpiresIn string `json:"expires_in"` // Go duration, e.g. "72h"
+}
+
+// consumeInvite uses one slot of the invite code inside tx. Invites are only
+// valid for the tenant they were created in.
+func consumeInvite(tx *gorm.DB, code string, tenantID uint) error {
+	result := tx.Model(&models.InviteCode{}).
+		Where("code_hash = ? AND tenant_id = ? AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)", utils.HashToken(code), tenantID, time.Now()).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != 1 {
+		return errInvalidInvite
+	}
+	return nil
+}
+
+// CreateInvite lets an admin mint a registration invite code for their
+// tenant. The code is only returned in this response.
+func CreateInvite(c *gin.Context) {
+	var input InviteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite"})
+		return
+	}
+	if input.MaxUses <= 0 {
+		input.MaxUses = 1
+	}
+
+	invite := models.InviteCode{
+		Note:      input.Note,
+		MaxUses:   input.MaxUses,
+		TenantID:  adminTenantID(c),
+		CreatedBy: c.GetString("user_id"),
+	}
+	if input.ExpiresIn != "" {
+		d, err := time.ParseDuration(input.ExpiresIn)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be a positive duration such as 72h"})
+			return
+		}
+		expiresAt := time.Now().Add(d)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	code, err := utils.RandomToken(12)
+	if err != nil {
+		utils.Logger.Error("Failed to generate invite code", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+	invite.CodeHash = utils.HashToken(code)
+
+	if err := database.DB.Create(&invite).Error; err != nil {
+		utils.Logger.Error("Failed to create invite", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	utils.Logger.Info("Invite created", zap.Uint("invite_id", invite.ID), zap.String("admin_id", invite.CreatedBy))
+	auditRequest(c, models.AuditAdminInviteCreated, models.AuditOutcomeSuccess, models.JSONMap{"invite_id": invite.ID, "max_uses": invite.MaxUses})
+	c.JSON(http.StatusCreated, gin.H{"code": code, "invite": invite})
+}
+
+// ListInvites returns the invite codes of the admin's tenant without their
+// values.
+func ListInvites(c *gin.Context) {
+	var invites []models.InviteCode
+	if err := database.DB.Where("tenant_id = ?", adminTenantID(c)).Order("created_at DESC").Find(&invites).Error; err != nil {
+		utils.Logger.Error("Failed to list invites", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invites"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Lockout is the brute-force policy applied to the password grant.
+var Lockout = utils.DefaultLockoutPolicy()
+
+// userLockKey is per tenant, as the same username can exist in several.
+func userLockKey(tenantID uint, username string) string {
+	return fmt.Sprintf("user:%d:%s", tenantID, utils.NormalizeUsername(username))
+}
+
+func ipLockKey(ip string) string {
+	return "ip:" + ip
+}
+
+// lockedFor returns the longest remaining lockout among the given keys.
+func lockedFor(keys ...string) time.Duration {
+	var attempts []models.LoginAttempt
+	if err := database.DB.Where("key IN ?", keys).Find(&attempts).Error; err != nil {
+		utils.Logger.Error("Failed to read login attempts", zap.Error(err))
+		return 0
+	}
+
+	now := time.Now()
+	var remaining time.Duration
+	for _, a := range attempts {
+		if a.LockedUntil != nil && a.LockedUntil.After(now) {
+			if d := a.LockedUntil.Sub(now); d > remaining {
+				remaining = d
+			}
+		}
+	}
+	return remaining
+}
+
+// recordFailure increments the failure counter for key and locks it once the
+// threshold is reached. It reports whether this failure caused a new lockout.
+func recordFailure(key string, threshold int) (models.LoginAttempt, bool, error) {
+	var attempt models.LoginAttempt
+	locked := false
+	now := time.Now()
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginAttempt{Key: key}).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&attempt, "key = ?", key).Error; err != nil {
+			return err
+		}
+
+		stillLocked := attempt.LockedUntil != nil && attempt.LockedUntil.After(now)
+		if !stillLocked && now.Sub(attempt.LastFailureAt) > Lockout.FailureWindow {
+			attempt.Failures = 0
+		}
+		attempt.Failures++
+		attempt.LastFailureAt = now
+
+		if d := Lockout.LockoutFor(attempt.Failures, threshold); d > 0 {
+			until := now.Add(d)
+			attempt.LockedUntil = &until
+			locked = true
+		}
+		return tx.Save(&attempt).Error
+	})
+	return attempt, locked, err
+}
+
+// registerLoginFailure counts a failed login against the username and client
+// IP and records it in the audit log. actorID is empty for unknown users.
+func registerLoginFailure(info *utils.TokenRequestInfo, clientID string, tenantID uint, username, actorID, reason string) {
+	ip := info.ClientIP
+	auditTokenRequest(info, models.AuditLoginFailure, models.AuditOutcomeFailure, actorID, clientID,
+		models.JSONMap{"username": username, "reason": reason})
+
+	keys := map[string]int{userLockKey(tenantID, username): Lockout.UserThreshold}
+	if ip != "" {
+		keys[ipLockKey(ip)] = Lockout.IPThreshold
+	}
+
+	for key, threshold := range keys {
+		attempt, locked, err := recordFailure(key, threshold)
+		if err != nil {
+			utils.Logger.Error("Failed to record login failure", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		if locked {
+			utils.Logger.Warn("Login locked out",
+				zap.String("event", "auth.lockout"),
+				zap.String("key", key),
+				zap.String("client_id", clientID),
+				zap.String("ip", ip),
+				zap.Int("failures", attempt.Failures),
+				zap.Time("locked_until", *attempt.LockedUntil),
+			)
+			auditTokenRequest(info, models.AuditLoginLockout, models.AuditOutcomeFailure, actorID, clientID,
+				models.JSONMap{"key": key, "failures": attempt.Failures, "locked_until": attempt.LockedUntil})
+		}
+	}
+}
+
+// clearLoginFailures resets the username counter after a successful login.
+// The IP counter is left to expire on its own so that one valid account
+// cannot be used to mask credential stuffing from the same address.
+func clearLoginFailures(tenantID uint, username string) {
+	if err := database.DB.Where("key = ?", userLockKey(tenantID, username)).Delete(&models.LoginAttempt{}).Error; err != nil {
+		utils.Logger.Error("Failed to clear login failures", zap.Error(err))
+	}
+}
+
+type UnlockInput struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+}
+
+// UnlockLogin lets an admin clear the lockout on a username and/or IP. The
+// username is looked up in the admin's tenant.
+func UnlockLogin(c *gin.Context) {
+	var input UnlockInput
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Username == "" && input.IP == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username or ip is required"})
+		return
+	}
+
+	var keys []string
+	if input.Username != "" {
+		keys = append(keys, userLockKey(adminTenantID(c), input.Username))
+	}
+	if input.IP != "" {
+		keys = append(keys, ipLockKey(input.IP))
+	}
+
+	result := database.DB.Where("key IN ?", keys).Delete(&models.LoginAttempt{})
+	if result.Error != nil {
+		utils.Logger.Error("Failed to unlock login", zap.Error(result.Error))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock login"})
+		return
+	}
+
+	utils.Logger.Info("Login unlocked",
+		zap.String("event", "auth.unlock"),
+		zap.String("admin_id", c.GetString("user_id")),
+		zap.Strings("keys", keys),
+	)
+	auditRequest(c, models.AuditAdminUnlock, models.AuditOutcomeSuccess, models.JSONMap{"keys": keys, "cleared": result.RowsAffected})
+	c.JSON(http.StatusOK, gin.H{"message": "Login unlocked", "cleared": result.RowsAffected})
+}
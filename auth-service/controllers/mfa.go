@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer        = "auth-service"
+	recoveryCodeCount = 10
+)
+
+// errTOTPUsed is returned when a concurrent request used the TOTP code, or
+// a later one, first.
+var errTOTPUsed = errors.New("TOTP code already used")
+
+type MFACodeInput struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+func currentUser(c *gin.Context) (*models.User, bool) {
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User token required"})
+		return nil, false
+	}
+	return &user, true
+}
+
+// verifySecondFactor checks a TOTP code or an unused recovery code for user
+// and returns the authentication methods to record in the token.
+func verifySecondFactor(user *models.User, otp, recoveryCode string) ([]string, bool) {
+	if otp != "" {
+		step, ok := utils.ValidateTOTP(user.TOTPSecret, otp, time.Now(), user.TOTPLastCounter)
+		if !ok {
+			return nil, false
+		}
+		// Only one of concurrent requests with the same code may use it.
+		result := database.DB.Model(&models.User{}).
+			Where("id = ? AND totp_last_counter < ?", user.ID, step).
+			Update("totp_last_counter", step)
+		if result.Error != nil {
+			utils.Logger.Error("Failed to store TOTP counter", zap.Error(result.Error))
+			return nil, false
+		}
+		if result.RowsAffected == 0 {
+			utils.Logger.Warn("TOTP code replayed", zap.Uint("user_id", user.ID))
+			return nil, false
+		}
+		user.TOTPLastCounter = step
+		return []string{"pwd", "otp", "mfa"}, true
+	}
+
+	if recoveryCode != "" {
+		result := database.DB.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(recoveryCode)).
+			Update("used_at", time.Now())
+		if result.Error != nil || result.RowsAffected != 1 {
+			return nil, false
+		}
+		utils.Logger.Info("Recovery code used", zap.Uint("user_id", user.ID))
+		return []string{"pwd", "mfa"}, true
+	}
+
+	return nil, false
+}
+
+// replaceRecoveryCodes invalidates the user's existing recovery codes and
+// returns a fresh set in plain text. They are only ever shown once.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if err := tx.Create(&models.RecoveryCode{UserID: userID, CodeHash: utils.HashToken(code)}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. MFA is only enabled
+// once the secret is confirmed with ConfirmTOTP.
+func EnrollTOTP(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		utils.Logger.Error("Failed to generate TOTP secret", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate TOTP secret"})
+		return
+	}
+	if err := database.DB.Model(user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_counter": 0}).Error; err != nil {
+		utils.Logger.Error("Failed to save TOTP secret", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save TOTP secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(totpIssuer, user.Username, secret),
+	})
+}
+
+// ConfirmTOTP enables MFA after the user proves the authenticator app works,
+// and returns the initial recovery codes.
+func ConfirmTOTP(c *gin.Context) {
+	var input MFACodeInput
+	if err := c.ShouldBindJSON(&input); err != nil || input.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code is required"})
+		return
+	}
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP enrollment not started"})
+		return
+	}
+
+	step, valid := utils.ValidateTOTP(user.TOTPSecret, input.Code, time.Now(), user.TOTPLastCounter)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).
+			Where("id = ? AND mfa_enabled = ? AND totp_last_counter < ?", user.ID, false, step).
+			Updates(map[string]interface{}{"mfa_enabled": true, "totp_last_counter": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errTOTPUsed
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if errors.Is(err, errTOTPUsed) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+	if err != nil {
+		utils.Logger.Error("Failed to enable MFA", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+		return
+	}
+
+	utils.Logger.Info("MFA enabled", zap.Uint("user_id", user.ID))
+	auditRequest(c, models.AuditAccountMFAEnabled, models.AuditOutcomeSuccess, models.JSONMap{"method": "totp"})
+	c.JSON(http.StatusOK, gin.H{"message": "MFA enabled", "recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes; requires a second factor.
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var input MFACodeInput
+	_ = c.ShouldBindJSON(&input)
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+	if _, valid := verifySecondFactor(user, input.Code, input.RecoveryCode); !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to regenerate recovery codes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate recovery codes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableMFA turns MFA off; requires a second factor.
+func DisableMFA(c *gin.Context) {
+	var input MFACodeInput
+	_ = c.ShouldBindJSON(&input)
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+	if _, valid := verifySecondFactor(user, input.Code, input.RecoveryCode); !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{"mfa_enabled": false, "totp_secret": "", "totp_last_counter": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		utils.Logger.Error("Failed to disable MFA", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable MFA"})
+		return
+	}
+
+	utils.Logger.Info("MFA disabled", zap.Uint("user_id", user.ID))
+	auditRequest(c, models.AuditAccountMFADisabled, models.AuditOutcomeSuccess, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "MFA disabled"})
+}
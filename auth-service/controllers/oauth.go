@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/middleware"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	"gorm.io/gorm"
+)
+
+// RevokeToken serves the revocation endpoint (RFC 7009).
+func RevokeToken(store oauth2.TokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.PostForm("token")
+		hint := c.PostForm("token_type_hint")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+
+		if utils.IsAPIKey(token) {
+			revokeAPIKeyToken(c, token)
+			return
+		}
+
+		ctx := c.Request.Context()
+		// Try to revoke by the hinted type, or fall back
+		tokenType := "refresh_token"
+		ti, _ := store.GetByRefresh(ctx, token)
+		if hint != "refresh_token" || ti == nil {
+			if access, _ := store.GetByAccess(ctx, token); access != nil {
+				ti, tokenType = access, "access_token"
+			}
+		}
+
+		details := models.JSONMap{"token_id": tokenFingerprint(token), "token_type_hint": hint}
+		if ti == nil {
+			// RFC 7009 answers 200 for unknown tokens as well.
+			auditRequest(c, models.AuditTokenRevoked, models.AuditOutcomeFailure, details)
+			c.Status(http.StatusOK)
+			return
+		}
+
+		var err error
+		if tokenType == "access_token" {
+			err = store.RemoveByAccess(ctx, token)
+		} else {
+			err = store.RemoveByRefresh(ctx, token)
+		}
+		outcome := models.AuditOutcomeSuccess
+		if err != nil {
+			outcome = models.AuditOutcomeFailure
+			details["error"] = err.Error()
+		}
+		details["token_type"] = tokenType
+		writeAudit(models.AuditEvent{
+			Event:     models.AuditTokenRevoked,
+			Outcome:   outcome,
+			ActorID:   ti.GetUserID(),
+			ClientID:  ti.GetClientID(),
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Details:   details,
+		})
+		c.Status(http.StatusOK)
+	}
+}
+
+// revokeTokens removes the tokens matched by query on the issued token index
+// from the token store, which also drops them from the index. It returns
+// the number of tokens revoked.
+func revokeTokens(ctx context.Context, query *gorm.DB) (int64, error) {
+	var tokens []models.IssuedToken
+	if err := query.Model(&models.IssuedToken{}).Find(&tokens).Error; err != nil {
+		return 0, err
+	}
+	for _, t := range tokens {
+		if t.Access != "" {
+			if err := middleware.TokenStore.RemoveByAccess(ctx, t.Access); err != nil {
+				return 0, err
+			}
+		}
+		if t.Refresh != "" {
+			if err := middleware.TokenStore.RemoveByRefresh(ctx, t.Refresh); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return int64(len(tokens)), nil
+}
+
+// IntrospectToken serves the introspection endpoint (RFC 7662).
+func IntrospectToken(store oauth2.TokenStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.PostForm("token")
+		if token == "" {
+			utils.Logger.Warn("Invalid Introspect request")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
+			return
+		}
+		if utils.IsAPIKey(token) {
+			c.JSON(http.StatusOK, introspectAPIKey(token))
+			return
+		}
+		ti, err := store.GetByAccess(c.Request.Context(), token)
+		if err != nil || ti == nil {
+			// inactive token
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+		// Check expiry, and that the session was not revoked meanwhile
+		active := ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).After(time.Now())
+		sid := utils.TokenExtension(ti).Get("sid")
+		if active && sid != "" {
+			active = middleware.SessionActive(sid)
+		}
+		resp := gin.H{"active": active}
+		if active {
+			resp["client_id"] = ti.GetClientID()
+			resp["sub"] = ti.GetUserID()
+			resp["scope"] = ti.GetScope()
+			// Resource servers authorize admin routes on the user's
+			// current role.
+			if userID := ti.GetUserID(); userID != "" {
+				var user models.User
+				if database.DB.Select("role").First(&user, "id = ?", userID).Error == nil {
+					resp["role"] = user.Role
+				}
+			}
+			resp["iat"] = ti.GetAccessCreateAt().Unix()
+			resp["exp"] = ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix()
+			if amr := utils.TokenExtension(ti)["amr"]; len(amr) > 0 {
+				resp["amr"] = amr
+			}
+			if act := utils.TokenExtension(ti)["act"]; len(act) > 0 {
+				resp["act"] = utils.ActorClaim(act)
+			}
+			if jkt := utils.TokenExtension(ti).Get("jkt"); jkt != "" {
+				resp["token_type"] = "DPoP"
+				resp["cnf"] = gin.H{"jkt": jkt}
+			}
+			if tenantID := utils.TokenExtension(ti).Get("tenant_id"); tenantID != "" {
+				resp["tenant_id"] = tenantID
+			}
+			if keyID := utils.TokenExtension(ti).Get("api_key_id"); keyID != "" {
+				resp["api_key_id"] = keyID
+			}
+			if sid != "" {
+				resp["sid"] = sid
+				touchSession(sid, "")
+			}
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
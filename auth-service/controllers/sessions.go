@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// sessionUsageInterval throttles last_used_at updates.
+	sessionUsageInterval = time.Minute
+	// endedSessionRetention is how long sessions without live tokens are
+	// kept before they are purged.
+	endedSessionRetention = 30 * 24 * time.Hour
+)
+
+// startSession opens a session for a successful login and ties the tokens
+// about to be issued to it.
+func startSession(info *utils.TokenRequestInfo, user *models.User, clientID string) {
+	now := time.Now()
+	session := models.Session{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		TenantID:   user.TenantID,
+		ClientID:   clientID,
+		UserAgent:  info.UserAgent,
+		IP:         info.ClientIP,
+		AMR:        strings.Join(info.AMR, " "),
+		LastUsedAt: now,
+		CreatedAt:  now,
+	}
+	if err := database.DB.Create(&session).Error; err != nil {
+		utils.Logger.Error("Failed to create session", zap.Error(err))
+		return
+	}
+	info.SessionID = session.ID
+}
+
+// touchSession records that a token of the session was used, at most once
+// per sessionUsageInterval. ip is the client's address, or "" when the
+// caller is a resource server rather than the client.
+func touchSession(id, ip string) {
+	now := time.Now()
+	updates := map[string]interface{}{"last_used_at": now}
+	if ip != "" {
+		updates["ip"] = ip
+	}
+	err := database.DB.Model(&models.Session{}).
+		Where("id = ? AND last_used_at < ?", id, now.Add(-sessionUsageInterval)).
+		Updates(updates).Error
+	if err != nil {
+		utils.Logger.Error("Failed to record session use", zap.Error(err))
+	}
+}
+
+// revokeSessions marks the sessions matched by query as revoked and revokes
+// their tokens. It returns the number of sessions revoked.
+func revokeSessions(ctx context.Context, query *gorm.DB) (int64, error) {
+	var ids []string
+	if err := query.Model(&models.Session{}).Where("revoked_at IS NULL").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := database.DB.Model(&models.Session{}).Where("id IN ?", ids).Update("revoked_at", time.Now()).Error; err != nil {
+		return 0, err
+	}
+	_, err := revokeTokens(ctx, database.DB.Where("session_id IN ?", ids))
+	return int64(len(ids)), err
+}
+
+// revokeUserSessions revokes every session of the user except keepSID (pass
+// "" to revoke all) and their tokens. Tokens outside any session, such as
+// API key tokens, are revoked too unless their access token is keepAccess.
+// Downstream services see the change on their next introspection.
+func revokeUserSessions(ctx context.Context, userID, keepSID, keepAccess string) (int64, error) {
+	query := database.DB.Where("user_id = ?", userID)
+	if keepSID != "" {
+		query = query.Where("id <> ?", keepSID)
+	}
+	n, err := revokeSessions(ctx, query)
+	if err != nil {
+		return n, err
+	}
+
+	tokens := database.DB.Where("user_id = ? AND session_id = ''", userID)
+	if keepAccess != "" {
+		tokens = tokens.Where("access <> ?", keepAccess)
+	}
+	_, err = revokeTokens(ctx, tokens)
+	return n, err
+}
+
+// ListSessions returns the user's active sessions: those that are not
+// revoked and still have a live token.
+func ListSessions(c *gin.Context) {
+	var sessions []models.Session
+	err := database.DB.
+		Where("user_id = ? AND revoked_at IS NULL", c.GetString("user_id")).
+		Where("EXISTS (SELECT 1 FROM issued_tokens WHERE session_id = sessions.id AND expires_at > ?)", time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		utils.Logger.Error("Failed to list sessions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	current := c.GetString("session_id")
+	resp := make([]gin.H, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, gin.H{
+			"id":           s.ID,
+			"client_id":    s.ClientID,
+			"user_agent":   s.UserAgent,
+			"ip":           s.IP,
+			"amr":          strings.Fields(s.AMR),
+			"created_at":   s.CreatedAt,
+			"last_used_at": s.LastUsedAt,
+			"current":      s.ID == current,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": resp})
+}
+
+// RevokeSession revokes one of the user's sessions by id; "current" names
+// the calling session, which logs it out.
+func RevokeSession(c *gin.Context) {
+	id := c.Param("id")
+	if id == "current" {
+		id = c.GetString("session_id")
+	}
+	userID := c.GetString("user_id")
+
+	n, err := revokeSessions(c.Request.Context(), database.DB.Where("id = ? AND user_id = ?", id, userID))
+	if err != nil {
+		utils.Logger.Error("Failed to revoke session", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	utils.Logger.Info("Session revoked", zap.String("user_id", userID), zap.String("session_id", id))
+	auditRequest(c, models.AuditAccountSessionsRevoked, models.AuditOutcomeSuccess, models.JSONMap{"session_id": id})
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions revokes every session except the calling one, or all
+// of them with ?all=true (logout everywhere).
+func RevokeOtherSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	all := c.Query("all") == "true"
+	keepSID, keepAccess := c.GetString("session_id"), c.GetString("access_token")
+	if all {
+		keepSID, keepAccess = "", ""
+	}
+
+	n, err := revokeUserSessions(c.Request.Context(), userID, keepSID, keepAccess)
+	if err != nil {
+		utils.Logger.Error("Failed to revoke sessions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	utils.Logger.Info("Sessions revoked", zap.String("user_id", userID), zap.Int64("count", n), zap.Bool("all", all))
+	auditRequest(c, models.AuditAccountSessionsRevoked, models.AuditOutcomeSuccess, models.JSONMap{"revoked": n, "all": all})
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions revoked", "revoked": n})
+}
+
+// PurgeEndedSessions periodically deletes sessions that were revoked, or
+// whose tokens all expired, more than endedSessionRetention ago.
+func PurgeEndedSessions(interval time.Duration) {
+	for range time.Tick(interval) {
+		cutoff := time.Now().Add(-endedSessionRetention)
+		err := database.DB.
+			Where("revoked_at < ? OR (last_used_at < ? AND NOT EXISTS (SELECT 1 FROM issued_tokens WHERE session_id = sessions.id))",
+				cutoff, cutoff).
+			Delete(&models.Session{}).Error
+		if err != nil {
+			utils.Logger.Error("Failed to purge sessions", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type TenantInput struct {
+	Slug string `json:"slug" binding:"required"`
+	Name string `json:"name"`
+}
+
+type ClientTenantInput struct {
+	ClientID string `json:"client_id" binding:"required"`
+}
+
+// tenantClaim is the form of a tenant ID in tokens and downstream services.
+func tenantClaim(id uint) string {
+	return fmt.Sprint(id)
+}
+
+// clientTenantID returns the tenant a client is bound to, if any.
+func clientTenantID(clientID string) (uint, bool) {
+	var binding models.ClientTenant
+	if err := database.DB.First(&binding, "client_id = ?", clientID).Error; err != nil {
+		return 0, false
+	}
+	return binding.TenantID, true
+}
+
+// resolveTenant picks the tenant a login happens in: the tenant the client
+// is bound to, otherwise the one named by slug, otherwise the default
+// tenant. A slug that contradicts the client's binding is rejected.
+func resolveTenant(clientID, slug string) (*models.Tenant, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+
+	var tenant models.Tenant
+	var err error
+	if id, bound := clientTenantID(clientID); bound {
+		err = database.DB.First(&tenant, "id = ?", id).Error
+		if err == nil && slug != "" && slug != tenant.Slug {
+			return nil, utils.ErrInvalidTenant
+		}
+	} else {
+		if slug == "" {
+			slug = models.DefaultTenantSlug
+		}
+		err = database.DB.First(&tenant, "slug = ?", slug).Error
+	}
+	if err != nil || !tenant.IsActive() {
+		return nil, utils.ErrInvalidTenant
+	}
+	return &tenant, nil
+}
+
+// clientAllowsTenant reports whether tokens of the given tenant may be
+// issued to clientID. Unbound clients serve every tenant.
+func clientAllowsTenant(clientID string, tenantID uint) bool {
+	id, bound := clientTenantID(clientID)
+	return !bound || id == tenantID
+}
+
+// adminTenantID is the tenant an admin acts on. Platform admins may pick
+// another tenant with the "tenant_id" query parameter.
+func adminTenantID(c *gin.Context) uint {
+	if c.GetBool("platform_admin") {
+		if id, err := strconv.ParseUint(c.Query("tenant_id"), 10, 64); err == nil {
+			return uint(id)
+		}
+	}
+	return c.GetUint("admin_tenant_id")
+}
+
+// ListTenants returns every tenant.
+func ListTenants(c *gin.Context) {
+	var tenants []models.Tenant
+	if err := database.DB.Order("id").Find(&tenants).Error; err != nil {
+		utils.Logger.Error("Failed to list tenants", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tenants"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenants": tenants})
+}
+
+// CreateTenant adds a tenant that users can then register into.
+func CreateTenant(c *gin.Context) {
+	var input TenantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slug is required"})
+		return
+	}
+	slug := strings.ToLower(strings.TrimSpace(input.Slug))
+	if msg := utils.ValidateTenantSlug(slug); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		name = slug
+	}
+
+	tenant := models.Tenant{Slug: slug, Name: name, Status: models.TenantStatusActive}
+	err := database.DB.Create(&tenant).Error
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "Tenant already exists"})
+		return
+	case err != nil:
+		utils.Logger.Error("Failed to create tenant", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tenant"})
+		return
+	}
+
+	utils.Logger.Info("Tenant created", zap.Uint("tenant_id", tenant.ID), zap.String("slug", tenant.Slug))
+	auditRequest(c, models.AuditAdminTenantCreated, models.AuditOutcomeSuccess, models.JSONMap{"tenant_id": tenant.ID, "slug": tenant.Slug})
+	c.JSON(http.StatusCreated, gin.H{"tenant": tenant})
+}
+
+// BindClientTenant restricts an OAuth client to the tenant in the path.
+func BindClientTenant(c *gin.Context) {
+	var input ClientTenantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_id is required"})
+		return
+	}
+	var tenant models.Tenant
+	if err := database.DB.First(&tenant, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+
+	binding := models.ClientTenant{ClientID: input.ClientID, TenantID: tenant.ID}
+	if err := database.DB.Save(&binding).Error; err != nil {
+		utils.Logger.Error("Failed to bind client to tenant", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to bind client"})
+		return
+	}
+
+	utils.Logger.Info("Client bound to tenant", zap.String("client_id", input.ClientID), zap.Uint("tenant_id", tenant.ID))
+	auditRequest(c, models.AuditAdminClientBound, models.AuditOutcomeSuccess, models.JSONMap{"client_id": input.ClientID, "tenant_id": tenant.ID})
+	c.JSON(http.StatusOK, gin.H{"client_id": binding.ClientID, "tenant_id": binding.TenantID})
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	oauth2Errors "github.com/go-oauth2/oauth2/v4/errors"
+	oauth2Server "github.com/go-oauth2/oauth2/v4/server"
+)
+
+// TokenHandler serves /oauth/token. Standard grants are delegated to srv;
+// grant types the library does not know about are dispatched here.
+func TokenHandler(srv *oauth2Server.Server) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info := &utils.TokenRequestInfo{
+			ClientIP:     c.ClientIP(),
+			UserAgent:    c.Request.UserAgent(),
+			Scope:        c.PostForm("scope"),
+			OTP:          c.PostForm("otp"),
+			RecoveryCode: c.PostForm("recovery_code"),
+			Tenant:       c.PostForm("tenant"),
+		}
+		r := c.Request.WithContext(utils.WithTokenRequestInfo(c.Request.Context(), info))
+		grantType := r.FormValue("grant_type")
+		info.GrantType = grantType
+
+		if err := bindDPoPProof(c, info); err != nil {
+			auditTokenRequest(info, models.AuditTokenDenied, models.AuditOutcomeFailure, "", c.PostForm("client_id"),
+				models.JSONMap{"grant_type": grantType, "error": "invalid_dpop_proof", "reason": err.Error()})
+			writeTokenResponse(c, map[string]interface{}{"error": "invalid_dpop_proof", "error_description": err.Error()}, nil, http.StatusBadRequest)
+			return
+		}
+
+		var ti oauth2.TokenInfo
+		var err error
+		switch {
+		case !clientAllowsGrant(c.PostForm("client_id"), grantType):
+			err = oauth2Errors.ErrUnauthorizedClient
+		case grantType == utils.GrantTypeMFAOTP:
+			ti, err = mfaOTPGrant(srv, r, info)
+		case grantType == utils.GrantTypeTokenExchange:
+			ti, err = tokenExchangeGrant(srv, r, info)
+		case grantType == utils.GrantTypeDeviceCode:
+			ti, err = deviceCodeGrant(srv, r, info)
+		case grantType == utils.GrantTypeAPIKey:
+			ti, err = apiKeyGrant(srv, r, info)
+		default:
+			var gt oauth2.GrantType
+			var tgr *oauth2.TokenGenerateRequest
+			gt, tgr, err = srv.ValidationTokenRequest(r)
+			if err == nil && gt == oauth2.Refreshing {
+				err = checkRefreshBinding(srv, r, info)
+			}
+			if err == nil && gt == oauth2.ClientCredentials {
+				if id, bound := clientTenantID(tgr.ClientID); bound {
+					info.TenantID = tenantClaim(id)
+				}
+			}
+			if err == nil {
+				ti, err = srv.GetAccessToken(r.Context(), gt, tgr)
+			}
+		}
+
+		if err != nil {
+			data, status, header := srv.GetErrorData(err)
+			var mfaErr *utils.MFARequiredError
+			if errors.As(err, &mfaErr) {
+				data["mfa_token"] = mfaErr.MFAToken
+			}
+			// Device polling is expected to fail until the user decides.
+			if !errors.Is(err, utils.ErrAuthorizationPending) && !errors.Is(err, utils.ErrSlowDown) {
+				auditTokenRequest(info, models.AuditTokenDenied, models.AuditOutcomeFailure, "", c.PostForm("client_id"),
+					models.JSONMap{"grant_type": grantType, "error": data["error"], "status": status})
+			}
+			writeTokenResponse(c, data, header, status)
+			return
+		}
+
+		event := models.AuditTokenIssued
+		details := models.JSONMap{"grant_type": grantType, "scope": ti.GetScope(), "token_id": tokenFingerprint(ti.GetAccess())}
+		data := srv.GetTokenData(ti)
+		switch grantType {
+		case oauth2.Refreshing.String():
+			event = models.AuditTokenRefreshed
+		case utils.GrantTypeTokenExchange:
+			event = models.AuditTokenExchanged
+			details["subject_token_id"] = tokenFingerprint(c.PostForm("subject_token"))
+			data["issued_token_type"] = utils.TokenTypeAccessToken
+		}
+		if utils.TokenExtension(ti).Get("jkt") != "" {
+			data["token_type"] = "DPoP"
+		}
+		if info.APIKeyID != "" {
+			details["api_key_id"] = info.APIKeyID
+		}
+		if sid := utils.TokenExtension(ti).Get("sid"); sid != "" {
+			details["session_id"] = sid
+			if grantType == oauth2.Refreshing.String() {
+				touchSession(sid, info.ClientIP)
+			}
+		}
+		auditTokenRequest(info, event, models.AuditOutcomeSuccess, ti.GetUserID(), ti.GetClientID(), details)
+		writeTokenResponse(c, data, nil, http.StatusOK)
+	}
+}
+
+func writeTokenResponse(c *gin.Context, data map[string]interface{}, header http.Header, status int) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	for key := range header {
+		c.Header(key, header.Get(key))
+	}
+	c.JSON(status, data)
+}
+
+// mfaOTPGrant completes a password login that was answered with mfa_required.
+func mfaOTPGrant(srv *oauth2Server.Server, r *http.Request, info *utils.TokenRequestInfo) (oauth2.TokenInfo, error) {
+	clientID, clientSecret, err := srv.ClientInfoHandler(r)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, tokenClientID, scope, err := utils.ParseMFAToken(r.FormValue("mfa_token"))
+	if err != nil || tokenClientID != clientID {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", userID).Error; err != nil || !user.MFAEnabled || !user.IsActive() {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+	info.TenantID = tenantClaim(user.TenantID)
+
+	keys := []string{userLockKey(user.TenantID, user.Username)}
+	if info.ClientIP != "" {
+		keys = append(keys, ipLockKey(info.ClientIP))
+	}
+	if retry := lockedFor(keys...); retry > 0 {
+		return nil, &utils.LockoutError{RetryAfter: retry}
+	}
+
+	amr, ok := verifySecondFactor(&user, info.OTP, info.RecoveryCode)
+	if !ok {
+		registerLoginFailure(info, clientID, user.TenantID, user.Username, userID, "bad_otp")
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+	if err := emailVerificationError(clientID, &user); err != nil {
+		auditTokenRequest(info, models.AuditLoginFailure, models.AuditOutcomeFailure, userID, clientID,
+			models.JSONMap{"username": user.Username, "reason": "email_not_verified"})
+		return nil, err
+	}
+	clearLoginFailures(user.TenantID, user.Username)
+	recordLogin(&user)
+	info.AMR = amr
+	startSession(info, &user, clientID)
+	auditTokenRequest(info, models.AuditLoginSuccess, models.AuditOutcomeSuccess, userID, clientID,
+		models.JSONMap{"amr": amr, "session_id": info.SessionID})
+
+	tgr := &oauth2.TokenGenerateRequest{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		UserID:       userID,
+		Scope:        scope,
+		Request:      r,
+	}
+	return srv.GetAccessToken(r.Context(), oauth2.PasswordCredentials, tgr)
+}
+
+// TokenErrorResponse maps errors raised by our grant handlers to token error
+// responses, or returns nil for any other error.
+func TokenErrorResponse(err error) *oauth2Errors.Response {
+	var lockErr *utils.LockoutError
+	if errors.As(err, &lockErr) {
+		re := oauth2Errors.NewResponse(oauth2Errors.ErrInvalidGrant, http.StatusTooManyRequests)
+		re.Description = "Too many failed login attempts, try again later"
+		re.SetHeader("Retry-After", strconv.Itoa(int(math.Ceil(lockErr.RetryAfter.Seconds()))))
+		return re
+	}
+
+	var mfaErr *utils.MFARequiredError
+	if errors.As(err, &mfaErr) {
+		re := oauth2Errors.NewResponse(mfaErr, http.StatusForbidden)
+		re.Description = "Multi-factor authentication required"
+		return re
+	}
+
+	if errors.Is(err, utils.ErrInvalidTenant) {
+		re := oauth2Errors.NewResponse(err, http.StatusBadRequest)
+		re.Description = "The tenant does not exist or is not available to this client"
+		return re
+	}
+
+	if errors.Is(err, utils.ErrInvalidTarget) {
+		re := oauth2Errors.NewResponse(err, http.StatusBadRequest)
+		re.Description = "The requested audience is not available to this client"
+		return re
+	}
+
+	for _, deviceErr := range []error{utils.ErrAuthorizationPending, utils.ErrSlowDown, utils.ErrExpiredToken, utils.ErrDeviceAccessDenied} {
+		if errors.Is(err, deviceErr) {
+			return oauth2Errors.NewResponse(deviceErr, http.StatusBadRequest)
+		}
+	}
+
+	if errors.Is(err, utils.ErrEmailNotVerified) {
+		re := oauth2Errors.NewResponse(err, http.StatusForbidden)
+		re.Description = "The email address of the account must be verified for this client"
+		return re
+	}
+	return nil
+}
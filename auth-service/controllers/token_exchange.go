@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/go-oauth2/oauth2/v4"
+	oauth2Errors "github.com/go-oauth2/oauth2/v4/errors"
+	oauth2Server "github.com/go-oauth2/oauth2/v4/server"
+)
+
+var (
+	// TokenExchangeClients may exchange user tokens for tokens of their own.
+	TokenExchangeClients []string
+	// TokenExchangeTTL caps the lifetime of exchanged tokens; they never
+	// outlive the subject token either.
+	TokenExchangeTTL = 5 * time.Minute
+)
+
+// tokenExchangeGrant implements RFC 8693 for on-behalf-of calls: a backend
+// client trades a user's access token for a token with itself as audience,
+// the user as subject and itself in the "act" claim.
+func tokenExchangeGrant(srv *oauth2Server.Server, r *http.Request, info *utils.TokenRequestInfo) (oauth2.TokenInfo, error) {
+	clientID, clientSecret, err := srv.ClientInfoHandler(r)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := srv.Manager.GetClient(r.Context(), clientID)
+	if err != nil || cli.GetSecret() == "" || cli.GetSecret() != clientSecret {
+		return nil, oauth2Errors.ErrInvalidClient
+	}
+	if !slices.Contains(TokenExchangeClients, clientID) {
+		return nil, oauth2Errors.ErrUnauthorizedClient
+	}
+
+	subjectToken := r.FormValue("subject_token")
+	subjectType := r.FormValue("subject_token_type")
+	if subjectToken == "" || (!isExchangeTokenType(subjectType) && subjectType != utils.TokenTypeAPIKey) {
+		return nil, oauth2Errors.ErrInvalidRequest
+	}
+	if t := r.FormValue("requested_token_type"); t != "" && !isExchangeTokenType(t) {
+		return nil, oauth2Errors.ErrInvalidRequest
+	}
+	if aud := r.FormValue("audience"); aud != "" && aud != clientID {
+		return nil, utils.ErrInvalidTarget
+	}
+
+	if subjectType == utils.TokenTypeAPIKey {
+		return exchangeAPIKey(srv, r, info, clientID, clientSecret, subjectToken)
+	}
+
+	subject, err := srv.Manager.LoadAccessToken(r.Context(), subjectToken)
+	if err != nil || subject.GetUserID() == "" {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+	var user models.User
+	if err := database.DB.First(&user, "id = ?", subject.GetUserID()).Error; err != nil || !user.IsActive() {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+	if !clientAllowsTenant(clientID, user.TenantID) {
+		return nil, utils.ErrInvalidTenant
+	}
+	info.TenantID = tenantClaim(user.TenantID)
+
+	scope, ok := utils.DownscopeScope(subject.GetScope(), info.Scope)
+	if !ok {
+		return nil, oauth2Errors.ErrInvalidScope
+	}
+
+	ttl := time.Until(subject.GetAccessCreateAt().Add(subject.GetAccessExpiresIn()))
+	if ttl > TokenExchangeTTL {
+		ttl = TokenExchangeTTL
+	}
+
+	ext := utils.TokenExtension(subject)
+	info.AMR = ext["amr"]
+	info.Actors = append([]string{clientID}, ext["act"]...)
+	info.SessionID = ext.Get("sid")
+
+	tgr := &oauth2.TokenGenerateRequest{
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		UserID:         subject.GetUserID(),
+		Scope:          scope,
+		AccessTokenExp: ttl,
+		Request:        r,
+	}
+	return srv.Manager.GenerateAccessToken(r.Context(), oauth2.GrantType(utils.GrantTypeTokenExchange), tgr)
+}
+
+// exchangeAPIKey is token exchange for an API key that a user sent straight
+// to the client: the result is a key token with the client as actor.
+func exchangeAPIKey(srv *oauth2Server.Server, r *http.Request, info *utils.TokenRequestInfo, clientID, clientSecret, key string) (oauth2.TokenInfo, error) {
+	apiKey, user, err := lookupAPIKey(key)
+	if err != nil {
+		return nil, oauth2Errors.ErrInvalidGrant
+	}
+	info.Actors = []string{clientID}
+	return issueAPIKeyToken(srv, r, info, clientID, clientSecret, apiKey, user, min(APIKeyTokenTTL, TokenExchangeTTL))
+}
+
+func isExchangeTokenType(t string) bool {
+	return t == utils.TokenTypeAccessToken || t == utils.TokenTypeJWT
+}
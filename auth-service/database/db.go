@@ -3,6 +3,7 @@ package database
 import (
 	"os"
 
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
@@ -19,11 +20,71 @@ func ConnectDatabase() {
 	}
 
 	dsn := os.Getenv("GORM_DATABASE_URL")
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		utils.Logger.Fatal("Failed to connect to PostgreSQL", zap.Error(err))
 	}
 
+	if err := Migrate(db); err != nil {
+		utils.Logger.Fatal("Failed to migrate authentication database", zap.Error(err))
+	}
+
 	DB = db
 	utils.Logger.Info("Authentication Database Migrated")
 }
+
+// Migrate creates or updates the tables owned by auth-service.
+func Migrate(db *gorm.DB) error {
+	err := db.AutoMigrate(
+		&models.Tenant{},
+		&models.ClientTenant{},
+		&models.User{},
+		&models.LoginAttempt{},
+		&models.RecoveryCode{},
+		&models.UserToken{},
+		&models.InviteCode{},
+		&models.AuditEvent{},
+		&models.RateLimitBucket{},
+		&models.DeviceAuthorization{},
+		&models.APIKey{},
+		&models.RegisteredClient{},
+		&models.Session{},
+		&models.IssuedToken{},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Existing users belong to the default tenant.
+	err = db.Exec(`INSERT INTO tenants (id, slug, name, status, created_at, updated_at)
+VALUES (?, ?, 'Default', 'active', NOW(), NOW()) ON CONFLICT DO NOTHING`, models.DefaultTenantID, models.DefaultTenantSlug).Error
+	if err != nil {
+		return err
+	}
+	if err := db.Exec("SELECT setval(pg_get_serial_sequence('tenants', 'id'), (SELECT MAX(id) FROM tenants))").Error; err != nil {
+		return err
+	}
+
+	// Usernames and emails are unique per tenant, usernames regardless of case.
+	err = db.Exec(`
+ALTER TABLE users DROP CONSTRAINT IF EXISTS uni_users_username;
+DROP INDEX IF EXISTS idx_users_username_lower;
+DROP INDEX IF EXISTS idx_users_email;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_username_lower ON users (tenant_id, LOWER(username));
+`).Error
+	if err != nil {
+		return err
+	}
+
+	// The audit log is append-only.
+	return db.Exec(`
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+`).Error
+}
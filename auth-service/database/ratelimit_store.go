@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rateLimitBucketTTL is how long an untouched bucket is kept. It must exceed
+// the time any configured bucket needs to refill.
+const rateLimitBucketTTL = 24 * time.Hour
+
+// RateLimitStore keeps token buckets in Postgres so that limits hold across
+// replicas.
+type RateLimitStore struct {
+	db *gorm.DB
+}
+
+// NewRateLimitStore returns a store on db that deletes idle buckets every
+// gcInterval.
+func NewRateLimitStore(db *gorm.DB, gcInterval time.Duration) *RateLimitStore {
+	s := &RateLimitStore{db: db}
+	go func() {
+		for range time.Tick(gcInterval) {
+			err := s.db.Where("refilled_at < ?", time.Now().Add(-rateLimitBucketTTL)).Delete(&models.RateLimitBucket{}).Error
+			if err != nil {
+				utils.Logger.Error("Failed to prune rate limit buckets", zap.Error(err))
+			}
+		}
+	}()
+	return s
+}
+
+// Take locks the buckets in key order, so that concurrent requests cannot
+// deadlock, and updates them in one transaction.
+func (s *RateLimitStore) Take(ctx context.Context, keys []string, limits []utils.RateLimit) ([]utils.RateLimitResult, error) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return keys[order[a]] < keys[order[b]] })
+
+	var results []utils.RateLimitResult
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		rows := make([]models.RateLimitBucket, len(keys))
+		for i, j := range order {
+			rows[i] = models.RateLimitBucket{Key: keys[j], Tokens: float64(limits[j].Burst), RefilledAt: now}
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error; err != nil {
+			return err
+		}
+		var stored []models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key IN ?", keys).Order("key").Find(&stored).Error; err != nil {
+			return err
+		}
+		byKey := make(map[string]models.RateLimitBucket, len(stored))
+		for _, b := range stored {
+			byKey[b.Key] = b
+		}
+
+		buckets := make([]utils.TokenBucket, len(keys))
+		for i, key := range keys {
+			b := byKey[key]
+			buckets[i] = utils.TokenBucket{Tokens: b.Tokens, Last: b.RefilledAt, Limit: limits[i]}
+		}
+		results = utils.TakeTokens(buckets, now)
+		for _, i := range order {
+			b := models.RateLimitBucket{Key: keys[i], Tokens: buckets[i].Tokens, RefilledAt: buckets[i].Last}
+			if err := tx.Save(&b).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return results, err
+}
@@ -4,8 +4,6 @@ import (
 	"log"
 	"os"
 
-	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
-
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -14,13 +12,13 @@ var TestDB *gorm.DB
 
 func InitTestDB() {
 	dsn := os.Getenv("GORM_TEST_DATABASE_URL")
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatalf("Failed to connect to test Postgres: %v", err)
 	}
 
 	db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public;")
-	db.AutoMigrate(&models.User{})
+	Migrate(db)
 
 	TestDB = db
 	DB = db
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/go-oauth2/oauth2/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// IndexedTokenStore wraps a token store and records every access and
+// refresh token it holds in the issued_tokens index, so that the tokens of
+// a user, client, session or API key can be found and revoked.
+type IndexedTokenStore struct {
+	oauth2.TokenStore
+	db *gorm.DB
+}
+
+// NewIndexedTokenStore returns store indexed in db. Index entries of expired
+// tokens are deleted every gcInterval.
+func NewIndexedTokenStore(db *gorm.DB, store oauth2.TokenStore, gcInterval time.Duration) *IndexedTokenStore {
+	s := &IndexedTokenStore{TokenStore: store, db: db}
+	go func() {
+		for range time.Tick(gcInterval) {
+			err := s.db.Where("expires_at < ?", time.Now()).Delete(&models.IssuedToken{}).Error
+			if err != nil {
+				utils.Logger.Error("Failed to prune issued tokens", zap.Error(err))
+			}
+		}
+	}()
+	return s
+}
+
+func (s *IndexedTokenStore) Create(ctx context.Context, info oauth2.TokenInfo) error {
+	if err := s.TokenStore.Create(ctx, info); err != nil {
+		return err
+	}
+	if info.GetAccess() == "" && info.GetRefresh() == "" {
+		// Authorization codes are short-lived and single-use.
+		return nil
+	}
+
+	ext := utils.TokenExtension(info)
+	token := models.IssuedToken{
+		Access:    info.GetAccess(),
+		Refresh:   info.GetRefresh(),
+		UserID:    info.GetUserID(),
+		ClientID:  info.GetClientID(),
+		SessionID: ext.Get("sid"),
+		APIKeyID:  ext.Get("api_key_id"),
+		ExpiresAt: info.GetAccessCreateAt().Add(info.GetAccessExpiresIn()),
+	}
+	if info.GetRefresh() != "" {
+		token.ExpiresAt = info.GetRefreshCreateAt().Add(info.GetRefreshExpiresIn())
+	}
+	return s.db.WithContext(ctx).Create(&token).Error
+}
+
+func (s *IndexedTokenStore) RemoveByAccess(ctx context.Context, access string) error {
+	if err := s.TokenStore.RemoveByAccess(ctx, access); err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Where("access = ?", access).Delete(&models.IssuedToken{}).Error
+}
+
+func (s *IndexedTokenStore) RemoveByRefresh(ctx context.Context, refresh string) error {
+	if err := s.TokenStore.RemoveByRefresh(ctx, refresh); err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Where("refresh = ?", refresh).Delete(&models.IssuedToken{}).Error
+}
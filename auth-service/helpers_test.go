@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// serve runs req through the router and decodes the JSON response, if any.
+func serve(req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w, body
+}
+
+// formRequest builds a form-encoded POST to path.
+func formRequest(path string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// postForm posts form to path.
+func postForm(path string, form url.Values) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return serve(formRequest(path, form))
+}
+
+// tokenRequest posts form to the token endpoint.
+func tokenRequest(form url.Values) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return postForm("/oauth/token", form)
+}
+
+// passwordForm is a password grant request of webclient.
+func passwordForm(username, password string) url.Values {
+	return url.Values{
+		"grant_type":    {"password"},
+		"client_id":     {"webclient"},
+		"client_secret": {"webclientsecret"},
+		"username":      {username},
+		"password":      {password},
+	}
+}
+
+// login logs the user in through webclient and returns the token response.
+func login(t *testing.T, username, password string) map[string]interface{} {
+	t.Helper()
+	w, body := tokenRequest(passwordForm(username, password))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	return body
+}
+
+// call sends body, if not nil, as JSON to path with the bearer token.
+func call(method, path, token string, body interface{}) (int, map[string]interface{}) {
+	var r io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		r = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, r)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w, resp := serve(req)
+	return w.Code, resp
+}
+
+// introspect returns the introspection response for token.
+func introspect(token string) map[string]interface{} {
+	_, body := postForm("/oauth/introspect", url.Values{"token": {token}})
+	return body
+}
+
+// createUser stores a user of the default tenant with the given password.
+func createUser(username, password string) models.User {
+	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	user := models.User{Username: username, Password: string(hash)}
+	database.DB.Create(&user)
+	return user
+}
+
+// refreshForm is a refresh token grant request of webclient.
+func refreshForm(refresh string) url.Values {
+	return url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {"webclient"},
+		"client_secret": {"webclientsecret"},
+		"refresh_token": {refresh},
+	}
+}
+
+// adminToken creates an admin of the default tenant and returns an access
+// token of theirs.
+func adminToken(t *testing.T, username string) string {
+	user := createUser(username, username+"-password")
+	database.DB.Model(&user).Update("role", models.RoleAdmin)
+	return login(t, username, username+"-password")["access_token"].(string)
+}
@@ -2,11 +2,12 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/RanggaNehemia/golang-microservices/auth-service/controllers"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/middleware"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/routes"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/tracing"
@@ -21,7 +22,6 @@ import (
 	"github.com/vgarvardt/go-pg-adapter/pgx4adapter"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	zap "go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func main() {
@@ -34,8 +34,38 @@ func main() {
 	defer utils.SyncLogger()
 
 	cfg := utils.Load()
+	controllers.Lockout = cfg.Lockout
+	controllers.Mailer = utils.NewMailer(cfg.Mail)
+	controllers.PublicURL = cfg.PublicURL
+	controllers.VerifiedEmailClients = cfg.VerifiedEmailClients
+	controllers.InviteOnly = cfg.InviteOnly
+	controllers.TokenExchangeClients = cfg.TokenExchangeClients
+	controllers.TokenExchangeTTL = cfg.TokenExchangeTTL
+	controllers.DeviceCodeTTL = cfg.DeviceCodeTTL
+	controllers.DevicePollInterval = cfg.DevicePollInterval
+	controllers.DPoPClients = cfg.DPoPClients
+	controllers.APIKeyMaxTTL = cfg.APIKeyMaxTTL
+	controllers.APIKeyTokenTTL = cfg.APIKeyTokenTTL
+	controllers.ClientRegistrationToken = cfg.ClientRegistrationToken
+	controllers.RegistrationScopes = cfg.RegistrationScopes
+	middleware.PublicURL = cfg.PublicURL
+	if cfg.CaptchaVerifyURL != "" {
+		controllers.Captcha = utils.NewHTTPCaptchaVerifier(cfg.CaptchaVerifyURL, cfg.CaptchaSecret)
+	}
 
 	database.ConnectDatabase()
+	go controllers.PurgeExpiredDeviceAuthorizations(time.Hour)
+	go controllers.PurgeEndedSessions(time.Hour)
+
+	middleware.RateLimits = cfg.RateLimits
+	switch cfg.RateLimitStore {
+	case "postgres":
+		middleware.RateLimitStore = database.NewRateLimitStore(database.DB, time.Hour)
+	case "memory":
+		middleware.RateLimitStore = utils.NewMemoryRateLimitStore()
+	default:
+		utils.Logger.Fatal("Unknown RATE_LIMIT_STORE", zap.String("store", cfg.RateLimitStore))
+	}
 
 	ctx := context.Background()
 
@@ -49,16 +79,31 @@ func main() {
 
 	manager := manage.NewDefaultManager()
 
-	manager.SetAuthorizeCodeTokenCfg(manage.DefaultAuthorizeCodeTokenCfg)
-	manager.SetRefreshTokenCfg(manage.DefaultRefreshTokenCfg)
+	// Default lifetimes; per-client and per-grant overrides are applied by
+	// the access generator below.
+	userTokenCfg := &manage.Config{AccessTokenExp: cfg.TokenTTL, RefreshTokenExp: cfg.RefreshTokenTTL, IsGenerateRefresh: true}
+	manager.SetAuthorizeCodeTokenCfg(userTokenCfg)
+	manager.SetPasswordTokenCfg(userTokenCfg)
+	manager.SetClientTokenCfg(&manage.Config{AccessTokenExp: cfg.TokenTTL})
+	// Refresh tokens rotate but keep the lifetime of the original login.
+	manager.SetRefreshTokenCfg(&manage.RefreshingConfig{
+		AccessTokenExp:     cfg.TokenTTL,
+		IsGenerateRefresh:  true,
+		IsRemoveAccess:     true,
+		IsRemoveRefreshing: true,
+	})
+	manager.SetExtractExtensionHandler(utils.ExtractTokenExtension)
 
 	// Token
-	tokenStore, err := pg.NewTokenStore(adapter, pg.WithTokenStoreGCInterval(time.Minute))
+	pgTokenStore, err := pg.NewTokenStore(adapter, pg.WithTokenStoreGCInterval(time.Minute))
 	if err != nil {
 		utils.Logger.Fatal("Failed to create token store", zap.Error(err))
 	}
-	defer tokenStore.Close()
+	defer pgTokenStore.Close()
+	// Indexed by user, client, session and API key for revocation
+	tokenStore := database.NewIndexedTokenStore(database.DB, pgTokenStore, time.Hour)
 	manager.MapTokenStorage(tokenStore)
+	middleware.TokenStore = tokenStore
 
 	//Client
 	clientStore, err := pg.NewClientStore(adapter)
@@ -67,13 +112,15 @@ func main() {
 	}
 	manager.MapClientStorage(clientStore)
 
-	// JWT token generator
-	manager.MapAccessGenerate(utils.NewCustomJWTAccessGenerate(
-		[]byte(cfg.SecretKey),
-		jwt.SigningMethodHS512,
+	// JWT or opaque tokens, by client
+	manager.MapAccessGenerate(utils.NewPolicyAccessGenerate(
+		utils.NewCustomJWTAccessGenerate([]byte(cfg.SecretKey), jwt.SigningMethodHS512),
+		cfg.TokenPolicies,
 	))
 
-	utils.SeedOAuthClients(ctx, pgxConn)
+	for _, clientID := range utils.SeedOAuthClients(ctx, pgxConn) {
+		controllers.AuditSystem(models.AuditClientCreated, models.JSONMap{"client_id": clientID})
+	}
 
 	// Create the OAuth2 server with default configuration.
 	srv := oauth2Server.NewServer(oauth2Server.NewConfig(), manager)
@@ -87,20 +134,12 @@ func main() {
 		return id, secret, nil
 	})
 
-	srv.SetPasswordAuthorizationHandler(func(ctx context.Context, clientID, username, password string) (userID string, err error) {
-		ctx = context.WithValue(ctx, utils.ClientIDKey, clientID)
-
-		var user models.User
-		if result := database.DB.First(&user, "username = ?", username); result.Error != nil {
-			return "", oauth2Errors.ErrInvalidGrant
-		}
-		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
-			return "", oauth2Errors.ErrInvalidGrant
-		}
-		userID = fmt.Sprint(user.ID)
-		return userID, nil
-	})
+	srv.SetPasswordAuthorizationHandler(controllers.PasswordAuthorizationHandler)
+	srv.SetClientScopeHandler(controllers.ClientScopeHandler)
 	srv.SetInternalErrorHandler(func(err error) *oauth2Errors.Response {
+		if re := controllers.TokenErrorResponse(err); re != nil {
+			return re
+		}
 		utils.Logger.Error("OAuth2 Internal Error", zap.Error(err))
 		return nil
 	})
@@ -119,83 +158,24 @@ func main() {
 	})
 
 	routes.RegisterAuthRoutes(r)
+	routes.RegisterAdminRoutes(r)
 
 	oauth := r.Group("/oauth")
 	{
-		oauth.POST("/token", func(c *gin.Context) {
-			srv.HandleTokenRequest(c.Writer, c.Request)
-		})
+		oauth.POST("/token", middleware.ClientAuth(clientStore), middleware.RateLimit("token"), controllers.TokenHandler(srv))
+		oauth.POST("/device_authorization", middleware.ClientAuth(clientStore), middleware.RateLimit("device"), controllers.DeviceAuthorization(srv))
 		oauth.GET("/authorize", func(c *gin.Context) {
 			srv.HandleAuthorizeRequest(c.Writer, c.Request)
 		})
 
-		// — Revocation endpoint (RFC 7009) —
-		oauth.POST("/revoke", func(c *gin.Context) {
-			token := c.PostForm("token")
-			hint := c.PostForm("token_type_hint")
-			if token == "" {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
-				return
-			}
-			// Try to revoke by the hinted type, or fall back
-			var err error
-			switch hint {
-			case "access_token":
-				err = tokenStore.RemoveByAccess(c.Request.Context(), token)
-			case "refresh_token":
-				err = tokenStore.RemoveByRefresh(c.Request.Context(), token)
-			default:
-				err = tokenStore.RemoveByAccess(c.Request.Context(), token)
-				if err != nil {
-					err = tokenStore.RemoveByRefresh(c.Request.Context(), token)
-				}
-			}
-			utils.Logger.Info("Token revoked", zap.String("token", token))
-			c.Status(http.StatusOK)
-		})
+		oauth.POST("/revoke", controllers.RevokeToken(tokenStore))
+		oauth.POST("/introspect", middleware.ClientAuth(clientStore), middleware.RateLimit("introspect"), controllers.IntrospectToken(tokenStore))
 
-		// — Introspection endpoint (RFC 7662) —
-		oauth.POST("/introspect", func(c *gin.Context) {
-			token := c.PostForm("token")
-			if token == "" {
-				utils.Logger.Warn("Invalid Introspect request")
-				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request"})
-				return
-			}
-			ti, err := tokenStore.GetByAccess(c.Request.Context(), token)
-			if err != nil || ti == nil {
-				// inactive token
-				c.JSON(http.StatusOK, gin.H{"active": false})
-				return
-			}
-			// Check expiry
-			active := ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).After(time.Now())
-			resp := gin.H{"active": active}
-			if active {
-				resp["client_id"] = ti.GetClientID()
-				resp["sub"] = ti.GetUserID()
-				resp["scope"] = ti.GetScope()
-				resp["iat"] = ti.GetAccessCreateAt().Unix()
-				resp["exp"] = ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix()
-			}
-			utils.Logger.Info("Token introspected", zap.String("token", token))
-			c.JSON(http.StatusOK, resp)
-		})
+		oauth.POST("/register", middleware.RateLimit("register"), controllers.RegisterClient(clientStore))
+		oauth.GET("/register/:client_id", controllers.GetRegisteredClient(clientStore))
+		oauth.PUT("/register/:client_id", controllers.UpdateRegisteredClient(clientStore))
+		oauth.DELETE("/register/:client_id", controllers.DeleteRegisteredClient)
 	}
 
-	// — Protected example —
-	r.GET("/auth/me", func(c *gin.Context) {
-		ti, err := srv.ValidationBearerToken(c.Request)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{
-			"client_id":  ti.GetClientID(),
-			"user_id":    ti.GetUserID(),
-			"expires_in": int64(ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Sub(time.Now()).Seconds()),
-		})
-	})
-
 	r.Run(":" + cfg.Port)
 }
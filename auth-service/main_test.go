@@ -4,15 +4,28 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/RanggaNehemia/golang-microservices/auth-service/controllers"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/middleware"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/routes"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
@@ -21,57 +34,83 @@ import (
 	"github.com/go-oauth2/oauth2/v4/manage"
 	oauth2Models "github.com/go-oauth2/oauth2/v4/models"
 	oauth2Server "github.com/go-oauth2/oauth2/v4/server"
-	oauth2Store "github.com/go-oauth2/oauth2/v4/store"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v4"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	pg "github.com/vgarvardt/go-oauth2-pg/v4"
+	"github.com/vgarvardt/go-pg-adapter/pgx4adapter"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	srv    *oauth2Server.Server
-	router *gin.Engine
-	ctx    = context.Background()
+	srv            *oauth2Server.Server
+	router         *gin.Engine
+	accessGenerate *utils.PolicyAccessGenerate
+	ctx            = context.Background()
 )
 
 func TestMain(m *testing.M) {
 	_ = godotenv.Load(".env.test")
+	utils.SecretKey = []byte(os.Getenv("SECRET_KEY"))
 
 	// Init in‑memory GORM DB (only User table)
 	database.InitTestDB()
-	database.DB.AutoMigrate(&models.User{})
+	database.Migrate(database.DB)
 	defer database.CloseTestDB()
 
 	// Build OAuth2 manager with in‑memory stores
 	manager := manage.NewDefaultManager()
 	manager.SetAuthorizeCodeTokenCfg(manage.DefaultAuthorizeCodeTokenCfg)
 	manager.SetRefreshTokenCfg(manage.DefaultRefreshTokenCfg)
+	manager.SetExtractExtensionHandler(utils.ExtractTokenExtension)
 
-	// In‑memory token store
-	memTokenStore, _ := oauth2Store.NewMemoryTokenStore()
-	manager.MapTokenStorage(memTokenStore)
-
-	// In‑memory client store + seed a client
-	memClientStore := oauth2Store.NewClientStore()
-	memClientStore.Set("webclient", &oauth2Models.Client{
-		ID:     "webclient",
-		Secret: "webclientsecret",
-		Domain: "",
-		UserID: "",
-	})
-	manager.MapClientStorage(memClientStore)
+	// Tokens and clients live in the pg stores, like in production, so that
+	// revocation and dynamic registration can be exercised end to end.
+	pgxConn, err := pgx.Connect(ctx, os.Getenv("GORM_TEST_DATABASE_URL"))
+	if err != nil {
+		log.Fatalf("Failed to connect pgx to test Postgres: %v", err)
+	}
+	defer pgxConn.Close(ctx)
+	adapter := pgx4adapter.NewConn(pgxConn)
+	pgTokenStore, err := pg.NewTokenStore(adapter)
+	if err != nil {
+		log.Fatalf("Failed to create token store: %v", err)
+	}
+	tokenStore := database.NewIndexedTokenStore(database.DB, pgTokenStore, time.Hour)
+	manager.MapTokenStorage(tokenStore)
+	middleware.TokenStore = tokenStore
 
-	// JWT generator
-	manager.MapAccessGenerate(utils.NewCustomJWTAccessGenerate(
-		[]byte(os.Getenv("SECRET_KEY")),
-		jwt.SigningMethodHS512,
-	))
+	clientStore, err := pg.NewClientStore(adapter)
+	if err != nil {
+		log.Fatalf("Failed to create client store: %v", err)
+	}
+	for _, cli := range []*oauth2Models.Client{
+		{ID: "webclient", Secret: "webclientsecret"},
+		{ID: "trade-service", Secret: "tradeservicesecret"},
+		{ID: "trading-cli", Public: true},
+		{ID: "acme-desk", Secret: "acmedesksecret"},
+	} {
+		if err := clientStore.Create(cli); err != nil {
+			log.Fatalf("Failed to seed client %s: %v", cli.ID, err)
+		}
+	}
+	manager.MapClientStorage(clientStore)
+	controllers.TokenExchangeClients = []string{"trade-service"}
+
+	// Token generator; tests may change its policies
+	accessGenerate = utils.NewPolicyAccessGenerate(
+		utils.NewCustomJWTAccessGenerate([]byte(os.Getenv("SECRET_KEY")), jwt.SigningMethodHS512),
+		utils.TokenPolicies{},
+	)
+	manager.MapAccessGenerate(accessGenerate)
 
 	// OAuth2 server
 	srv = oauth2Server.NewServer(oauth2Server.NewConfig(), manager)
 	srv.SetClientInfoHandler(oauth2Server.ClientFormHandler)
-	srv.SetPasswordAuthorizationHandler(passwordGrantHandler)
-	srv.SetInternalErrorHandler(func(err error) *oauth2Errors.Response { return nil })
+	srv.SetPasswordAuthorizationHandler(controllers.PasswordAuthorizationHandler)
+	srv.SetClientScopeHandler(controllers.ClientScopeHandler)
+	srv.SetInternalErrorHandler(controllers.TokenErrorResponse)
 	srv.SetResponseErrorHandler(func(re *oauth2Errors.Response) {})
 
 	// Gin router
@@ -80,70 +119,30 @@ func TestMain(m *testing.M) {
 	router.Use(gin.Recovery())
 
 	routes.RegisterAuthRoutes(router)
+	routes.RegisterAdminRoutes(router)
 
 	oauth := router.Group("/oauth")
 	{
-		oauth.POST("/token", func(c *gin.Context) {
-			srv.HandleTokenRequest(c.Writer, c.Request)
-		})
-		oauth.POST("/revoke", func(c *gin.Context) {
-			token := c.PostForm("token")
-			_ = memTokenStore.RemoveByAccess(ctx, token)
-			_ = memTokenStore.RemoveByRefresh(ctx, token)
-			c.Status(http.StatusOK)
-		})
-		oauth.POST("/introspect", func(c *gin.Context) {
-			tkn := c.PostForm("token")
-			ti, _ := memTokenStore.GetByAccess(ctx, tkn)
-			active := ti != nil && ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).After(time.Now())
-			resp := gin.H{"active": active}
-			if active {
-				resp["client_id"] = ti.GetClientID()
-				resp["sub"] = ti.GetUserID()
-				resp["scope"] = ti.GetScope()
-				resp["iat"] = ti.GetAccessCreateAt().Unix()
-				resp["exp"] = ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix()
-			}
-			c.JSON(http.StatusOK, resp)
-		})
+		oauth.POST("/token", middleware.ClientAuth(clientStore), middleware.RateLimit("token"), controllers.TokenHandler(srv))
+		oauth.POST("/device_authorization", middleware.ClientAuth(clientStore), middleware.RateLimit("device"), controllers.DeviceAuthorization(srv))
+		oauth.POST("/revoke", controllers.RevokeToken(tokenStore))
+		oauth.POST("/introspect", middleware.ClientAuth(clientStore), middleware.RateLimit("introspect"), controllers.IntrospectToken(tokenStore))
+		oauth.POST("/register", controllers.RegisterClient(clientStore))
+		oauth.GET("/register/:client_id", controllers.GetRegisteredClient(clientStore))
+		oauth.PUT("/register/:client_id", controllers.UpdateRegisteredClient(clientStore))
+		oauth.DELETE("/register/:client_id", controllers.DeleteRegisteredClient)
 	}
 
-	// Protected example
-	router.GET("/auth/me", func(c *gin.Context) {
-		ti, err := srv.ValidationBearerToken(c.Request)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{
-			"client_id":  ti.GetClientID(),
-			"user_id":    ti.GetUserID(),
-			"expires_in": int64(ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Sub(time.Now()).Seconds()),
-		})
-	})
-
 	os.Exit(m.Run())
 }
 
-// passwordGrantHandler checks username/password against GORM user table
-func passwordGrantHandler(_ context.Context, clientID, username, password string) (string, error) {
-	var u models.User
-	if database.DB.First(&u, "username = ?", username).Error != nil {
-		return "", oauth2Errors.ErrInvalidGrant
-	}
-	if bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
-		return "", oauth2Errors.ErrInvalidGrant
-	}
-	return fmt.Sprint(u.ID), nil
-}
-
 // --- Now your tests follow exactly as before ---
 
 func TestRegister_Success(t *testing.T) {
 	// fresh user table
 	database.DB.Exec("DELETE FROM users")
 
-	payload := map[string]string{"username": "alice", "password": "pw123"}
+	payload := map[string]string{"username": "alice", "password": "pw123456"}
 	b, _ := json.Marshal(payload)
 
 	req := httptest.NewRequest(http.MethodPost, "/auth/register", bytes.NewReader(b))
@@ -158,3 +157,1078 @@ func TestRegister_Success(t *testing.T) {
 }
 
 // ... and so on for Token, Me, Revoke, Introspect ...
+
+func TestToken_LockoutAfterRepeatedFailures(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	prev := controllers.Lockout
+	controllers.Lockout.UserThreshold = 2
+	defer func() { controllers.Lockout = prev }()
+
+	// Unknown username behaves exactly like a wrong password
+	w, _ := tokenRequest(passwordForm("mallory", "wrong"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = tokenRequest(passwordForm("mallory", "wrong"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Threshold reached: further attempts are rejected without checking the password
+	w, _ = tokenRequest(passwordForm("mallory", "wrong"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestToken_MFAChallenge(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw123"), bcrypt.MinCost)
+	secret, _ := utils.GenerateTOTPSecret()
+	database.DB.Create(&models.User{Username: "bob", Password: string(hash), MFAEnabled: true, TOTPSecret: secret})
+
+	// Correct password without a second factor triggers the challenge
+	w, body := tokenRequest(passwordForm("bob", "pw123"))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "mfa_required", body["error"])
+	mfaToken, _ := body["mfa_token"].(string)
+	assert.NotEmpty(t, mfaToken)
+
+	// Completing the challenge yields a token whose amr includes mfa
+	code, _ := utils.TOTPCode(secret, utils.TOTPCounter(time.Now()))
+	w, body = tokenRequest(url.Values{
+		"grant_type":    {utils.GrantTypeMFAOTP},
+		"client_id":     {"webclient"},
+		"client_secret": {"webclientsecret"},
+		"mfa_token":     {mfaToken},
+		"otp":           {code},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(body["access_token"].(string), claims)
+	assert.NoError(t, err)
+	assert.Contains(t, claims["amr"], "mfa")
+
+	// A code is used once, even by concurrent requests
+	code, _ = utils.TOTPCode(secret, utils.TOTPCounter(time.Now())+1)
+	form := passwordForm("bob", "pw123")
+	form.Set("otp", code)
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w, _ := tokenRequest(form); w.Code == http.StatusOK {
+				succeeded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), succeeded.Load())
+}
+
+func TestMe_ReturnsProfile(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw123456"), bcrypt.MinCost)
+	database.DB.Create(&models.User{Username: "carol", Password: string(hash), DisplayName: "Carol"})
+
+	token := login(t, "carol", "pw123456")
+	status, resp := call(http.MethodGet, "/auth/me", token["access_token"].(string), nil)
+	assert.Equal(t, http.StatusOK, status)
+
+	user, _ := resp["user"].(map[string]interface{})
+	assert.Equal(t, "webclient", resp["client_id"])
+	assert.Equal(t, "carol", user["username"])
+	assert.Equal(t, "Carol", user["display_name"])
+	assert.NotNil(t, user["last_login_at"])
+}
+
+func TestPasswordReset_SingleUseToken(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM user_tokens")
+
+	mailer := &utils.MemoryMailer{}
+	controllers.Mailer = mailer
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("oldpassword"), bcrypt.MinCost)
+	database.DB.Create(&models.User{Username: "dave", Password: string(hash), Email: "dave@example.com"})
+
+	// Unknown addresses get the same answer and no mail. Mail is sent in
+	// the background.
+	status, _ := call(http.MethodPost, "/auth/password/forgot", "", gin.H{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, status)
+	status, _ = call(http.MethodPost, "/auth/password/forgot", "", gin.H{"email": "dave@example.com"})
+	assert.Equal(t, http.StatusAccepted, status)
+
+	var msg utils.Message
+	assert.Eventually(t, func() bool {
+		var ok bool
+		msg, ok = mailer.Last("dave@example.com")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, mailer.Messages(), 1)
+	token := regexp.MustCompile(`code: (\S+)`).FindStringSubmatch(msg.Body)[1]
+
+	// The password policy applies, and the token stays usable
+	status, _ = call(http.MethodPost, "/auth/password/reset", "", gin.H{"token": token, "new_password": "short"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	reset := gin.H{"token": token, "new_password": "newpassword"}
+	status, _ = call(http.MethodPost, "/auth/password/reset", "", reset)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = call(http.MethodPost, "/auth/password/reset", "", reset)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	var user models.User
+	database.DB.First(&user, "username = ?", "dave")
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("newpassword")))
+
+	// The emailed link opens a form that resets the password too
+	status, _ = call(http.MethodPost, "/auth/password/forgot", "", gin.H{"email": "dave@example.com"})
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Eventually(t, func() bool { return len(mailer.Messages()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	msg, _ = mailer.Last("dave@example.com")
+	link, err := url.Parse(regexp.MustCompile(`http\S+`).FindString(msg.Body))
+	assert.NoError(t, err)
+	token = link.Query().Get("token")
+
+	w, _ := serve(httptest.NewRequest(http.MethodGet, link.RequestURI(), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `value="`+token+`"`)
+	assert.Equal(t, "no-referrer", w.Header().Get("Referrer-Policy"))
+
+	w, _ = postForm("/auth/password/reset", url.Values{"token": {token}, "new_password": {"otherpassword"}, "confirm_password": {"otherpasswort"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = postForm("/auth/password/reset", url.Values{"token": {token}, "new_password": {"otherpassword"}, "confirm_password": {"otherpassword"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Your password has been reset")
+	w, _ = postForm("/auth/password/reset", url.Values{"token": {token}, "new_password": {"thirdpassword"}, "confirm_password": {"thirdpassword"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	database.DB.First(&user, "username = ?", "dave")
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("otherpassword")))
+}
+
+func TestRegister_ValidationAndDuplicates(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+
+	status, body := call(http.MethodPost, "/auth/register", "", gin.H{"username": "a!", "password": "pw123456"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "validation_failed", body["error"])
+	assert.Contains(t, body["fields"], "username")
+
+	// The password policy applies
+	status, body = call(http.MethodPost, "/auth/register", "", gin.H{"username": "erin", "password": "pw123"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body["fields"], "password")
+
+	// gorm.Model fields in the payload are ignored
+	status, _ = call(http.MethodPost, "/auth/register", "", gin.H{"username": "Erin", "password": "pw123456", "ID": 4242})
+	assert.Equal(t, http.StatusCreated, status)
+	var user models.User
+	database.DB.First(&user, "username = ?", "erin")
+	assert.NotEqual(t, uint(4242), user.ID)
+
+	// Uniqueness is case-insensitive and does not leak database errors
+	status, body = call(http.MethodPost, "/auth/register", "", gin.H{"username": "ERIN", "password": "pw123456"})
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "username_taken", body["error"])
+}
+
+func TestAudit_RecordsLoginAndIsAppendOnly(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	user := createUser("grace", "pw123")
+
+	req := formRequest("/oauth/token", passwordForm("grace", "nope"))
+	req.Header.Set("User-Agent", "audit-test")
+	serve(req)
+
+	var event models.AuditEvent
+	err := database.DB.Where("event = ? AND actor_id = ?", models.AuditLoginFailure, fmt.Sprint(user.ID)).
+		Order("id DESC").First(&event).Error
+	assert.NoError(t, err)
+	assert.Equal(t, models.AuditOutcomeFailure, event.Outcome)
+	assert.Equal(t, "webclient", event.ClientID)
+	assert.Equal(t, "audit-test", event.UserAgent)
+	assert.Equal(t, "bad_password", event.Details["reason"])
+
+	// The table is append-only
+	assert.Error(t, database.DB.Model(&event).Update("outcome", models.AuditOutcomeSuccess).Error)
+	assert.Error(t, database.DB.Delete(&event).Error)
+}
+
+func TestRateLimit_IntrospectReturns429(t *testing.T) {
+	prevStore, prevLimits := middleware.RateLimitStore, middleware.RateLimits
+	middleware.RateLimitStore = utils.NewMemoryRateLimitStore()
+	middleware.RateLimits = map[string]utils.RateLimitPolicy{
+		"introspect": {
+			utils.RateLimitByClient: {Rate: 1.0 / 60, Burst: 3},
+			utils.RateLimitByIP:     {Rate: 1.0 / 60, Burst: 2},
+		},
+	}
+	defer func() { middleware.RateLimitStore, middleware.RateLimits = prevStore, prevLimits }()
+
+	form := url.Values{"token": {"abc"}}
+	w, _ := postForm("/oauth/introspect", form)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Remaining"))
+	w, _ = postForm("/oauth/introspect", form)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, _ = postForm("/oauth/introspect", form)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	// Authenticated clients are limited by client_id, not by address
+	authenticated := func(secret string) *httptest.ResponseRecorder {
+		req := formRequest("/oauth/introspect", form)
+		req.SetBasicAuth("trade-service", secret)
+		w, _ := serve(req)
+		return w
+	}
+	assert.Equal(t, http.StatusUnauthorized, authenticated("wrong").Code)
+	for i := 0; i < 3; i++ {
+		w = authenticated("tradeservicesecret")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, http.StatusTooManyRequests, authenticated("tradeservicesecret").Code)
+}
+
+func TestRateLimit_ClientBucketRequiresClientAuth(t *testing.T) {
+	prevStore, prevLimits := middleware.RateLimitStore, middleware.RateLimits
+	middleware.RateLimitStore = utils.NewMemoryRateLimitStore()
+	middleware.RateLimits = map[string]utils.RateLimitPolicy{
+		"token": {utils.RateLimitByClient: {Rate: 1.0 / 60, Burst: 2}},
+	}
+	defer func() { middleware.RateLimitStore, middleware.RateLimits = prevStore, prevLimits }()
+
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	createUser("rita", "pw123")
+
+	// Claiming webclient without its secret takes nothing from its bucket
+	for i := 0; i < 5; i++ {
+		form := passwordForm("rita", "pw123")
+		form.Set("client_secret", "wrong")
+		w, body := tokenRequest(form)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "invalid_client", body["error"])
+
+		form.Del("client_secret")
+		w, _ = tokenRequest(form)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	login(t, "rita", "pw123")
+	w, _ := tokenRequest(passwordForm("rita", "pw123"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+	w, _ = tokenRequest(passwordForm("rita", "pw123"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestRateLimit_PasswordRecoveryRoutes(t *testing.T) {
+	prevStore, prevLimits := middleware.RateLimitStore, middleware.RateLimits
+	middleware.RateLimitStore = utils.NewMemoryRateLimitStore()
+	middleware.RateLimits = map[string]utils.RateLimitPolicy{
+		"password_forgot": {
+			utils.RateLimitByEmail: {Rate: 1.0 / 3600, Burst: 2},
+			utils.RateLimitByIP:    {Rate: 1.0 / 3600, Burst: 3},
+		},
+		"password_reset": {utils.RateLimitByIP: {Rate: 1.0 / 3600, Burst: 2}},
+		"email_verify":   {utils.RateLimitByIP: {Rate: 1.0 / 3600, Burst: 2}},
+	}
+	defer func() { middleware.RateLimitStore, middleware.RateLimits = prevStore, prevLimits }()
+	controllers.Mailer = &utils.MemoryMailer{}
+
+	forgot := func(email string) int {
+		status, _ := call(http.MethodPost, "/auth/password/forgot", "", gin.H{"email": email})
+		return status
+	}
+
+	// Each address gets a few mails, however it is spelled
+	assert.Equal(t, http.StatusAccepted, forgot("victim@example.com"))
+	assert.Equal(t, http.StatusAccepted, forgot(" Victim@Example.com"))
+	assert.Equal(t, http.StatusTooManyRequests, forgot("VICTIM@example.com"))
+
+	// and each client address a few requests in all
+	assert.Equal(t, http.StatusAccepted, forgot("other@example.com"))
+	assert.Equal(t, http.StatusTooManyRequests, forgot("third@example.com"))
+
+	for _, path := range []string{"/auth/password/reset", "/auth/email/verify"} {
+		for i := 0; i < 2; i++ {
+			status, _ := call(http.MethodPost, path, "", gin.H{"token": "guess", "new_password": "guesspassword"})
+			assert.Equal(t, http.StatusBadRequest, status, path)
+		}
+		status, _ := call(http.MethodPost, path, "", gin.H{"token": "guess", "new_password": "guesspassword"})
+		assert.Equal(t, http.StatusTooManyRequests, status, path)
+	}
+}
+
+func TestTokenExchange_OnBehalfOfUser(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	user := createUser("heidi", "pw123")
+
+	form := passwordForm("heidi", "pw123")
+	form.Set("scope", "trade data:read")
+	_, body := tokenRequest(form)
+	userToken, _ := body["access_token"].(string)
+	assert.NotEmpty(t, userToken)
+
+	exchange := url.Values{
+		"grant_type":         {utils.GrantTypeTokenExchange},
+		"client_id":          {"trade-service"},
+		"client_secret":      {"tradeservicesecret"},
+		"subject_token":      {userToken},
+		"subject_token_type": {utils.TokenTypeAccessToken},
+		"scope":              {"data:read"},
+	}
+	w, body := tokenRequest(exchange)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, utils.TokenTypeAccessToken, body["issued_token_type"])
+	assert.Nil(t, body["refresh_token"])
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(body["access_token"].(string), claims)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprint(user.ID), claims["sub"])
+	assert.Equal(t, "trade-service", claims["aud"])
+	assert.Equal(t, "data:read", claims["scope"])
+	assert.Equal(t, map[string]interface{}{"sub": "trade-service"}, claims["act"])
+
+	// Scopes can only shrink
+	exchange.Set("scope", "admin")
+	w, _ = tokenRequest(exchange)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Only allowed clients may exchange
+	exchange.Set("scope", "")
+	exchange.Set("client_id", "webclient")
+	exchange.Set("client_secret", "webclientsecret")
+	w, _ = tokenRequest(exchange)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestDeviceFlow_PollApproveAndToken(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	createUser("ivan", "pw123")
+
+	w, body := postForm("/oauth/device_authorization", url.Values{"client_id": {"trading-cli"}, "scope": {"trade"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	deviceCode, _ := body["device_code"].(string)
+	userCode, _ := body["user_code"].(string)
+	assert.NotEmpty(t, deviceCode)
+	assert.Contains(t, body["verification_uri_complete"], userCode)
+
+	poll := url.Values{"grant_type": {utils.GrantTypeDeviceCode}, "client_id": {"trading-cli"}, "device_code": {deviceCode}}
+	w, body = tokenRequest(poll)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "authorization_pending", body["error"])
+
+	// Polling again right away is too fast
+	_, body = tokenRequest(poll)
+	assert.Equal(t, "slow_down", body["error"])
+
+	// The page is submitted with the CSRF token it was rendered with,
+	// which must match its cookie
+	submit := func(form url.Values, withCSRF bool) *httptest.ResponseRecorder {
+		page, _ := serve(httptest.NewRequest(http.MethodGet, "/device?user_code="+userCode, nil))
+		assert.Contains(t, page.Body.String(), "trading-cli asks for access")
+		token := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(page.Body.String())[1]
+		if withCSRF {
+			form.Set("csrf_token", token)
+		}
+		req := formRequest("/device", form)
+		for _, cookie := range page.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		w, _ := serve(req)
+		return w
+	}
+	approve := url.Values{"user_code": {userCode}, "username": {"ivan"}, "password": {"pw123"}, "action": {"approve"}}
+
+	w = submit(approve, false)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = postForm("/device", url.Values{"user_code": {userCode}, "username": {"ivan"}, "password": {"pw123"}, "action": {"approve"}, "csrf_token": {"forged"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Wrong password does not approve the device
+	w = submit(url.Values{"user_code": {strings.ToLower(userCode)}, "username": {"ivan"}, "password": {"nope"}, "action": {"approve"}}, true)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = submit(approve, true)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Device connected")
+
+	w, body = tokenRequest(poll)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, body["access_token"])
+	assert.NotEmpty(t, body["refresh_token"])
+	assert.Equal(t, "trade", body["scope"])
+
+	// Device codes are single-use
+	_, body = tokenRequest(poll)
+	assert.Equal(t, "invalid_grant", body["error"])
+}
+
+func TestDPoP_BindsTokenToKey(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	createUser("judy", "pw123")
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	proof := func(method, htu, accessToken, nonce string) string {
+		claims := jwt.MapClaims{"htm": method, "htu": htu, "iat": time.Now().Unix(), "jti": fmt.Sprint(time.Now().UnixNano())}
+		if accessToken != "" {
+			sum := sha256.Sum256([]byte(accessToken))
+			claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+		}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}
+		signed, _ := token.SignedString(key)
+		return signed
+	}
+
+	req := formRequest("/oauth/token", passwordForm("judy", "pw123"))
+	req.Header.Set("DPoP", proof(http.MethodPost, controllers.PublicURL+"/oauth/token", "", ""))
+	w, body := serve(req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "DPoP", body["token_type"])
+	accessToken := body["access_token"].(string)
+
+	claims := jwt.MapClaims{}
+	jwt.NewParser().ParseUnverified(accessToken, claims)
+	assert.NotEmpty(t, claims["cnf"])
+
+	me := func(scheme, dpop string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+		req.Header.Set("Authorization", scheme+" "+accessToken)
+		if dpop != "" {
+			req.Header.Set("DPoP", dpop)
+		}
+		w, _ := serve(req)
+		return w
+	}
+
+	// A bound token cannot be used as a plain bearer token
+	assert.Equal(t, http.StatusUnauthorized, me("Bearer", "").Code)
+
+	// A proof without a server nonce is answered with one to use
+	w = me("DPoP", proof(http.MethodGet, middleware.PublicURL+"/auth/me", accessToken, ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), `error="use_dpop_nonce"`)
+	nonce := w.Header().Get("DPoP-Nonce")
+	assert.NotEmpty(t, nonce)
+	assert.Equal(t, http.StatusUnauthorized, me("DPoP", proof(http.MethodGet, middleware.PublicURL+"/auth/me", accessToken, "made-up")).Code)
+
+	good := proof(http.MethodGet, middleware.PublicURL+"/auth/me", accessToken, nonce)
+	w = me("DPoP", good)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("DPoP-Nonce"))
+	// Proofs cannot be replayed
+	assert.Equal(t, http.StatusUnauthorized, me("DPoP", good).Code)
+	// Proofs are tied to method and URL
+	assert.Equal(t, http.StatusUnauthorized, me("DPoP", proof(http.MethodPost, middleware.PublicURL+"/auth/me", accessToken, nonce)).Code)
+}
+
+func TestTenants_IsolateUsersAndTokens(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	database.DB.Exec("DELETE FROM client_tenants")
+	database.DB.Exec("DELETE FROM tenants WHERE id <> ?", models.DefaultTenantID)
+
+	acme := models.Tenant{Slug: "acme", Name: "Acme"}
+	assert.NoError(t, database.DB.Create(&acme).Error)
+	database.DB.Create(&models.ClientTenant{ClientID: "acme-desk", TenantID: acme.ID})
+
+	// The same username can be registered in both tenants
+	for _, reg := range []gin.H{
+		{"username": "ivan", "password": "default-pw"},
+		{"username": "ivan", "password": "acme-password", "tenant": "acme"},
+	} {
+		status, body := call(http.MethodPost, "/auth/register", "", reg)
+		assert.Equal(t, http.StatusCreated, status, body)
+	}
+
+	tenantLogin := func(clientID, secret, tenant, password string) (int, map[string]interface{}) {
+		form := passwordForm("ivan", password)
+		form.Set("client_id", clientID)
+		form.Set("client_secret", secret)
+		form.Set("tenant", tenant)
+		w, body := tokenRequest(form)
+		return w.Code, body
+	}
+	tenantOf := func(body map[string]interface{}) interface{} {
+		claims := jwt.MapClaims{}
+		_, _, err := jwt.NewParser().ParseUnverified(body["access_token"].(string), claims)
+		assert.NoError(t, err)
+		return claims["tenant_id"]
+	}
+
+	status, body := tenantLogin("webclient", "webclientsecret", "acme", "acme-password")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, fmt.Sprint(acme.ID), tenantOf(body))
+
+	status, body = tenantLogin("webclient", "webclientsecret", "", "default-pw")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, fmt.Sprint(models.DefaultTenantID), tenantOf(body))
+
+	// One tenant's password does not open the other tenant's account
+	status, body = tenantLogin("webclient", "webclientsecret", "", "acme-password")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	// A client bound to a tenant only logs users into that tenant
+	status, body = tenantLogin("acme-desk", "acmedesksecret", "", "acme-password")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, fmt.Sprint(acme.ID), tenantOf(body))
+	status, body = tenantLogin("acme-desk", "acmedesksecret", "default", "default-pw")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_tenant", body["error"])
+
+	status, body = tenantLogin("webclient", "webclientsecret", "nope", "acme-password")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_tenant", body["error"])
+}
+
+func TestAPIKeys_CreateExchangeIntrospectRevoke(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	database.DB.Exec("DELETE FROM api_keys")
+
+	user := createUser("judy", "pw123456")
+
+	form := passwordForm("judy", "pw123456")
+	form.Set("scope", "trade data:read")
+	_, body := tokenRequest(form)
+	userToken := body["access_token"].(string)
+
+	// Scope can only shrink and the key is shown once
+	status, _ := call(http.MethodPost, "/auth/api-keys", userToken, gin.H{"name": "bot", "scope": "admin"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, body = call(http.MethodPost, "/auth/api-keys", userToken, gin.H{"name": "bot", "scope": "trade", "expires_in": "24h"})
+	assert.Equal(t, http.StatusCreated, status)
+	apiKey, _ := body["api_key"].(string)
+	assert.True(t, utils.IsAPIKey(apiKey))
+	keyID := body["key"].(map[string]interface{})["id"]
+
+	var stored models.APIKey
+	database.DB.First(&stored)
+	assert.Equal(t, utils.HashToken(apiKey), stored.KeyHash)
+	req := httptest.NewRequest(http.MethodGet, "/auth/api-keys", nil)
+	req.Header.Set("Authorization", "Bearer "+userToken)
+	w, _ := serve(req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), apiKey)
+
+	// The key is exchangeable for a short-lived access token
+	keyForm := url.Values{
+		"grant_type": {utils.GrantTypeAPIKey},
+		"client_id":  {"trading-cli"},
+		"api_key":    {apiKey},
+	}
+	w, body = tokenRequest(keyForm)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, body["refresh_token"])
+	assert.LessOrEqual(t, body["expires_in"].(float64), controllers.APIKeyTokenTTL.Seconds())
+	keyToken := body["access_token"].(string)
+	claims := jwt.MapClaims{}
+	jwt.NewParser().ParseUnverified(keyToken, claims)
+	assert.Equal(t, fmt.Sprint(user.ID), claims["sub"])
+	assert.Equal(t, "trade", claims["scope"])
+	assert.Equal(t, []interface{}{"api_key"}, claims["amr"])
+
+	// Tokens from a key cannot mint more keys
+	status, _ = call(http.MethodPost, "/auth/api-keys", keyToken, gin.H{"name": "again"})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// Resource servers can introspect the key itself
+	body = introspect(apiKey)
+	assert.Equal(t, true, body["active"])
+	assert.Equal(t, fmt.Sprint(user.ID), body["sub"])
+	assert.Equal(t, fmt.Sprint(models.DefaultTenantID), body["tenant_id"])
+
+	// Revoking the key revokes the tokens issued for it
+	status, body = call(http.MethodDelete, fmt.Sprintf("/auth/api-keys/%v", keyID), userToken, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), body["revoked_tokens"])
+
+	assert.Equal(t, false, introspect(apiKey)["active"])
+	assert.Equal(t, false, introspect(keyToken)["active"])
+	_, body = tokenRequest(keyForm)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	var events []models.AuditEvent
+	database.DB.Where("event IN ?", []string{models.AuditAPIKeyCreated, models.AuditAPIKeyRevoked}).Order("id").Find(&events)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, models.AuditAPIKeyCreated, events[0].Event)
+		assert.Equal(t, models.AuditAPIKeyRevoked, events[1].Event)
+		assert.NotContains(t, fmt.Sprint(events[0].Details), apiKey)
+	}
+}
+
+func TestClientRegistration_Lifecycle(t *testing.T) {
+	prev := controllers.ClientRegistrationToken
+	controllers.ClientRegistrationToken = "initial-access-token"
+	defer func() { controllers.ClientRegistrationToken = prev }()
+
+	metadata := map[string]interface{}{
+		"client_name": "Quant Bot",
+		"grant_types": []string{"client_credentials"},
+		"scope":       "data:read",
+	}
+
+	// The initial access token is required and metadata is validated
+	status, _ := call(http.MethodPost, "/oauth/register", "", metadata)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, body := call(http.MethodPost, "/oauth/register", "initial-access-token",
+		map[string]interface{}{"grant_types": []string{"password"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_client_metadata", body["error"])
+
+	status, body = call(http.MethodPost, "/oauth/register", "initial-access-token", metadata)
+	assert.Equal(t, http.StatusCreated, status)
+	clientID, _ := body["client_id"].(string)
+	secret, _ := body["client_secret"].(string)
+	regToken, _ := body["registration_access_token"].(string)
+	assert.NotEmpty(t, secret)
+	assert.Equal(t, "Quant Bot", body["client_name"])
+	assert.True(t, strings.HasSuffix(body["registration_client_uri"].(string), "/oauth/register/"+clientID))
+
+	var rc models.RegisteredClient
+	database.DB.First(&rc, "client_id = ?", clientID)
+	assert.Equal(t, utils.HashToken(regToken), rc.RegistrationTokenHash)
+
+	// The registered client can use its grant within its scope only
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"scope":         {"data:read"},
+	}
+	w, body := tokenRequest(form)
+	assert.Equal(t, http.StatusOK, w.Code)
+	clientToken, _ := body["access_token"].(string)
+	assert.NotEmpty(t, clientToken)
+	form.Set("scope", "trade")
+	_, body = tokenRequest(form)
+	assert.Equal(t, "invalid_scope", body["error"])
+	form.Set("grant_type", "password")
+	form.Set("username", "nobody")
+	form.Set("password", "nothing")
+	_, body = tokenRequest(form)
+	assert.Equal(t, "unauthorized_client", body["error"])
+
+	// Read and update need the client's own registration access token
+	status, _ = call(http.MethodGet, "/oauth/register/"+clientID, "initial-access-token", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, body = call(http.MethodGet, "/oauth/register/"+clientID, regToken, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, secret, body["client_secret"])
+	assert.Equal(t, "data:read", body["scope"])
+
+	metadata["client_id"] = clientID
+	metadata["client_secret"] = "not-the-secret"
+	status, body = call(http.MethodPut, "/oauth/register/"+clientID, regToken, metadata)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "invalid_client_metadata", body["error"])
+	delete(metadata, "client_secret")
+	metadata["scope"] = "data:read trade"
+	status, body = call(http.MethodPut, "/oauth/register/"+clientID, regToken, metadata)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "data:read trade", body["scope"])
+	assert.Equal(t, secret, body["client_secret"])
+	form.Set("grant_type", "client_credentials")
+	w, _ = tokenRequest(form)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Deleting the client removes it from the client store and revokes its
+	// tokens
+	status, _ = call(http.MethodDelete, "/oauth/register/"+clientID, regToken, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Equal(t, false, introspect(clientToken)["active"])
+	status, _ = call(http.MethodGet, "/oauth/register/"+clientID, regToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	w, _ = tokenRequest(form)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+
+	var events []models.AuditEvent
+	database.DB.Where("event IN ? AND details->>'client_id' = ?",
+		[]string{models.AuditClientCreated, models.AuditClientUpdated, models.AuditClientDeleted}, clientID).Order("id").Find(&events)
+	assert.Len(t, events, 3)
+}
+
+func TestTokenPolicies_OpaqueTokensAndLifetimes(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	accessGenerate.Policies = utils.TokenPolicies{
+		Grants: map[string]utils.TokenPolicy{
+			"password": {AccessTTL: 30 * time.Minute, RefreshTTL: 720 * time.Hour},
+		},
+		Clients: map[string]utils.TokenPolicy{
+			"trade-service": {AccessTTL: 5 * time.Minute, Format: utils.TokenFormatOpaque},
+		},
+	}
+	defer func() { accessGenerate.Policies = utils.TokenPolicies{} }()
+
+	createUser("kate", "pw123456")
+
+	// Web sessions follow the password grant override and stay JWTs
+	body := login(t, "kate", "pw123456")
+	webToken, _ := body["access_token"].(string)
+	assert.True(t, utils.IsJWT(webToken))
+	assert.Equal(t, (30 * time.Minute).Seconds(), body["expires_in"])
+	claims := jwt.MapClaims{}
+	jwt.NewParser().ParseUnverified(webToken, claims)
+	exp, _ := claims.GetExpirationTime()
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), exp.Time, 5*time.Second)
+	ti, err := middleware.TokenStore.GetByAccess(ctx, webToken)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 720*time.Hour, ti.GetRefreshExpiresIn())
+	}
+
+	// Machine tokens of trade-service are short-lived references
+	_, body = tokenRequest(url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {"trade-service"},
+		"client_secret": {"tradeservicesecret"},
+	})
+	machineToken, _ := body["access_token"].(string)
+	assert.NotEmpty(t, machineToken)
+	assert.False(t, utils.IsJWT(machineToken))
+	assert.Equal(t, (5 * time.Minute).Seconds(), body["expires_in"])
+
+	body = introspect(machineToken)
+	assert.Equal(t, true, body["active"])
+	assert.Equal(t, "trade-service", body["client_id"])
+
+	// Opaque user tokens work against auth-service's own endpoints
+	accessGenerate.Policies.Clients["webclient"] = utils.TokenPolicy{Format: utils.TokenFormatOpaque}
+	body = login(t, "kate", "pw123456")
+	opaqueToken, _ := body["access_token"].(string)
+	assert.False(t, utils.IsJWT(opaqueToken))
+
+	status, body := call(http.MethodGet, "/auth/me", opaqueToken, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "kate", body["user"].(map[string]interface{})["username"])
+}
+
+func TestSessions_ListRevokeAndLogoutEverywhere(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	database.DB.Exec("DELETE FROM sessions")
+
+	createUser("liam", "pw123456")
+
+	loginFrom := func(userAgent string) map[string]interface{} {
+		req := formRequest("/oauth/token", passwordForm("liam", "pw123456"))
+		req.Header.Set("User-Agent", userAgent)
+		w, body := serve(req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return body
+	}
+
+	laptop := loginFrom("laptop")
+	phone := loginFrom("phone")
+	tablet := loginFrom("tablet")
+	laptopToken := laptop["access_token"].(string)
+
+	// Refreshing stays in the same session
+	w, refreshed := tokenRequest(refreshForm(phone["refresh_token"].(string)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	phoneToken := refreshed["access_token"].(string)
+	phoneSID := introspect(phoneToken)["sid"]
+	assert.NotEmpty(t, phoneSID)
+
+	status, body := call(http.MethodGet, "/auth/sessions", laptopToken, nil)
+	assert.Equal(t, http.StatusOK, status)
+	sessions, _ := body["sessions"].([]interface{})
+	assert.Len(t, sessions, 3)
+	agents := map[string]bool{}
+	for _, s := range sessions {
+		session := s.(map[string]interface{})
+		agents[session["user_agent"].(string)] = session["current"].(bool)
+		assert.NotEmpty(t, session["last_used_at"])
+	}
+	assert.Equal(t, map[string]bool{"laptop": true, "phone": false, "tablet": false}, agents)
+
+	// Revoking one session kills its access and refresh tokens
+	status, _ = call(http.MethodDelete, fmt.Sprintf("/auth/sessions/%v", phoneSID), laptopToken, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, false, introspect(phoneToken)["active"])
+	status, _ = call(http.MethodGet, "/auth/me", phoneToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	w, body = tokenRequest(refreshForm(refreshed["refresh_token"].(string)))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	assert.Equal(t, "invalid_grant", body["error"])
+
+	// Logging out everywhere includes the calling session
+	assert.Equal(t, true, introspect(tablet["access_token"].(string))["active"])
+	status, body = call(http.MethodDelete, "/auth/sessions?all=true", laptopToken, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(2), body["revoked"])
+	assert.Equal(t, false, introspect(tablet["access_token"].(string))["active"])
+	assert.Equal(t, false, introspect(laptopToken)["active"])
+	status, _ = call(http.MethodGet, "/auth/sessions", laptopToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestSessions_RevokedSessionRejectedEvenWithLiveTokens(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	database.DB.Exec("DELETE FROM sessions")
+
+	createUser("olivia", "pw123456")
+	body := login(t, "olivia", "pw123456")
+	accessToken := body["access_token"].(string)
+	sid := introspect(accessToken)["sid"]
+	assert.NotEmpty(t, sid)
+
+	// Marking the session revoked is enough, even if its tokens are still
+	// in the store
+	database.DB.Model(&models.Session{}).Where("id = ?", sid).Update("revoked_at", time.Now())
+	status, _ := call(http.MethodGet, "/auth/me", accessToken, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	w, body := tokenRequest(refreshForm(body["refresh_token"].(string)))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "invalid_grant", body["error"])
+}
+
+func TestAccount_ChangePasswordRevokesOtherSessions(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	createUser("mia", "old-password")
+
+	current := login(t, "mia", "old-password")
+	other := login(t, "mia", "old-password")
+	currentToken := current["access_token"].(string)
+
+	status, _ := call(http.MethodPost, "/auth/me/password", currentToken,
+		map[string]string{"current_password": "old-password", "new_password": "short"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = call(http.MethodPost, "/auth/me/password", currentToken,
+		map[string]string{"current_password": "wrong-password", "new_password": "new-password"})
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, body := call(http.MethodPost, "/auth/me/password", currentToken,
+		map[string]string{"current_password": "old-password", "new_password": "new-password"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), body["revoked_sessions"])
+
+	// The other session is gone, refresh token included; the caller's stays
+	assert.Equal(t, false, introspect(other["access_token"].(string))["active"])
+	w, _ := tokenRequest(refreshForm(other["refresh_token"].(string)))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, introspect(currentToken)["active"])
+
+	w, _ = tokenRequest(passwordForm("mia", "old-password"))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	login(t, "mia", "new-password")
+}
+
+func TestAccount_DeleteRevokesTokens(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	database.DB.Exec("DELETE FROM api_keys")
+	createUser("noah", "noah-password")
+
+	token := login(t, "noah", "noah-password")["access_token"].(string)
+	status, created := call(http.MethodPost, "/auth/api-keys", token, map[string]interface{}{"name": "bot"})
+	assert.Equal(t, http.StatusCreated, status)
+	w, keyToken := tokenRequest(url.Values{
+		"grant_type": {utils.GrantTypeAPIKey},
+		"client_id":  {"trading-cli"},
+		"api_key":    {created["api_key"].(string)},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	status, _ = call(http.MethodDelete, "/auth/me", token, map[string]string{"password": "wrong-password"})
+	assert.Equal(t, http.StatusForbidden, status)
+	status, _ = call(http.MethodDelete, "/auth/me", token, map[string]string{"password": "noah-password"})
+	assert.Equal(t, http.StatusOK, status)
+
+	assert.Equal(t, false, introspect(token)["active"])
+	assert.Equal(t, false, introspect(keyToken["access_token"].(string))["active"])
+	status, _ = call(http.MethodGet, "/auth/me", token, nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	w, _ = tokenRequest(passwordForm("noah", "noah-password"))
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestAdmin_RequiresAdminRole(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	createUser("oscar", "oscar-password")
+	token := login(t, "oscar", "oscar-password")["access_token"].(string)
+
+	status, _ := call(http.MethodGet, "/admin/invites", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, body := call(http.MethodGet, "/admin/invites", token, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "Admin access required", body["error"])
+}
+
+func TestAdmin_UnlockLogin(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	prev := controllers.Lockout
+	controllers.Lockout.UserThreshold = 2
+	defer func() { controllers.Lockout = prev }()
+
+	admin := adminToken(t, "root")
+	createUser("peggy", "peggy-password")
+	for i := 0; i < 2; i++ {
+		tokenRequest(passwordForm("peggy", "wrong"))
+	}
+	w, _ := tokenRequest(passwordForm("peggy", "peggy-password"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	status, _ := call(http.MethodPost, "/admin/lockouts/unlock", admin, gin.H{})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, body := call(http.MethodPost, "/admin/lockouts/unlock", admin, gin.H{"username": "peggy"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, float64(1), body["cleared"])
+
+	w, _ = tokenRequest(passwordForm("peggy", "peggy-password"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdmin_Invites(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	database.DB.Exec("DELETE FROM invite_codes")
+
+	controllers.InviteOnly = true
+	defer func() { controllers.InviteOnly = false }()
+
+	admin := adminToken(t, "root")
+	status, _ := call(http.MethodPost, "/admin/invites", admin, gin.H{"expires_in": "soon"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, body := call(http.MethodPost, "/admin/invites", admin, gin.H{"note": "new desk", "expires_in": "72h"})
+	assert.Equal(t, http.StatusCreated, status)
+	code, _ := body["code"].(string)
+	assert.NotEmpty(t, code)
+
+	status, body = call(http.MethodGet, "/admin/invites", admin, nil)
+	assert.Equal(t, http.StatusOK, status)
+	if invites, ok := body["invites"].([]interface{}); assert.True(t, ok) && assert.Len(t, invites, 1) {
+		invite := invites[0].(map[string]interface{})
+		assert.Equal(t, "new desk", invite["note"])
+		assert.NotContains(t, invite, "code_hash")
+	}
+
+	// Registration needs the code, which is used up after max_uses
+	status, body = call(http.MethodPost, "/auth/register", "", gin.H{"username": "quinn", "password": "quinn-password"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body["fields"], "invite_code")
+	status, _ = call(http.MethodPost, "/auth/register", "", gin.H{"username": "quinn", "password": "quinn-password", "invite_code": code})
+	assert.Equal(t, http.StatusCreated, status)
+	status, body = call(http.MethodPost, "/auth/register", "", gin.H{"username": "rupert", "password": "rupert-password", "invite_code": code})
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "invalid_invite", body["error"])
+}
+
+func TestAdmin_AuditListAndExport(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+
+	admin := adminToken(t, "root")
+	createUser("sybil", "sybil-password")
+	login(t, "sybil", "sybil-password")
+	tokenRequest(passwordForm("sybil", "wrong"))
+
+	status, body := call(http.MethodGet, "/admin/audit?event="+models.AuditLoginFailure+"&limit=1", admin, nil)
+	assert.Equal(t, http.StatusOK, status)
+	events, _ := body["events"].([]interface{})
+	if assert.Len(t, events, 1) {
+		event := events[0].(map[string]interface{})
+		assert.Equal(t, models.AuditLoginFailure, event["event"])
+		assert.Equal(t, models.AuditOutcomeFailure, event["outcome"])
+	}
+	assert.NotNil(t, body["next_before_id"])
+	status, _ = call(http.MethodGet, "/admin/audit?from=yesterday", admin, nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit/export?format=csv&event="+models.AuditLoginSuccess, nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	w, _ := serve(req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "id,created_at,event,outcome"))
+	assert.GreaterOrEqual(t, len(lines), 3)
+	for _, line := range lines[1:] {
+		assert.Contains(t, line, models.AuditLoginSuccess)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/audit/export?format=xml", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	w, _ = serve(req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAdmin_Tenants(t *testing.T) {
+	database.DB.Exec("DELETE FROM users")
+	database.DB.Exec("DELETE FROM login_attempts")
+	database.DB.Exec("DELETE FROM client_tenants")
+	database.DB.Exec("DELETE FROM tenants WHERE id <> ?", models.DefaultTenantID)
+
+	admin := adminToken(t, "root")
+	status, _ := call(http.MethodPost, "/admin/tenants", admin, gin.H{"slug": "Not A Slug"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, body := call(http.MethodPost, "/admin/tenants", admin, gin.H{"slug": "globex", "name": "Globex"})
+	assert.Equal(t, http.StatusCreated, status)
+	tenant, _ := body["tenant"].(map[string]interface{})
+	tenantID := fmt.Sprint(tenant["id"])
+	status, _ = call(http.MethodPost, "/admin/tenants", admin, gin.H{"slug": "globex"})
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, body = call(http.MethodGet, "/admin/tenants", admin, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, body["tenants"], 2)
+
+	status, _ = call(http.MethodPost, "/admin/tenants/9999/clients", admin, gin.H{"client_id": "globex-desk"})
+	assert.Equal(t, http.StatusNotFound, status)
+	status, body = call(http.MethodPost, "/admin/tenants/"+tenantID+"/clients", admin, gin.H{"client_id": "globex-desk"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "globex-desk", body["client_id"])
+
+	// Admins of other tenants do not manage tenants
+	status, _ = call(http.MethodPost, "/auth/register", "", gin.H{"username": "tina", "password": "tina-password", "tenant": "globex"})
+	assert.Equal(t, http.StatusCreated, status)
+	database.DB.Model(&models.User{}).Where("username = ?", "tina").Update("role", models.RoleAdmin)
+	form := passwordForm("tina", "tina-password")
+	form.Set("tenant", "globex")
+	_, body = tokenRequest(form)
+	tenantAdmin, _ := body["access_token"].(string)
+	status, _ = call(http.MethodGet, "/admin/invites", tenantAdmin, nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = call(http.MethodGet, "/admin/tenants", tenantAdmin, nil)
+	assert.Equal(t, http.StatusForbidden, status)
+}
@@ -1,15 +1,32 @@
 package middleware
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/RanggaNehemia/golang-microservices/auth-service/database"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/models"
 	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/RanggaNehemia/golang-microservices/dpop"
 	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
 	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
 )
 
+// TokenStore, when set, is consulted so that revoked tokens are rejected
+// even though their signature and expiry are still valid.
+var TokenStore oauth2.TokenStore
+
+// PublicURL is the externally visible base URL, against which the htu of
+// DPoP proofs is checked.
+var PublicURL = "http://localhost:8080"
+
+var dpopReplay = dpop.NewReplayCache()
+
 func JWTAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -22,7 +39,11 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 		}
 
 		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		scheme := ""
+		if len(parts) == 2 {
+			scheme = strings.ToLower(parts[0])
+		}
+		if scheme != "bearer" && scheme != "dpop" {
 			utils.Logger.Warn("Malformed Authorization header")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization format must be Bearer <token>"})
 			c.Abort()
@@ -31,21 +52,161 @@ func JWTAuthMiddleware() gin.HandlerFunc {
 
 		tokenString := parts[1]
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return utils.SecretKey, nil
-		})
+		var claims jwt.MapClaims
+		if utils.IsJWT(tokenString) {
+			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+				return utils.SecretKey, nil
+			})
+
+			if err != nil || !token.Valid {
+				utils.Logger.Warn("Invalid or expired token", zap.Error(err))
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				c.Abort()
+				return
+			}
 
-		if err != nil || !token.Valid {
-			utils.Logger.Warn("Invalid or expired token", zap.Error(err))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			if TokenStore != nil {
+				if ti, err := TokenStore.GetByAccess(c.Request.Context(), tokenString); err != nil || ti == nil {
+					utils.Logger.Warn("Token not found in store")
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revoked"})
+					c.Abort()
+					return
+				}
+			}
+			claims = token.Claims.(jwt.MapClaims)
+		} else {
+			var ok bool
+			if claims, ok = opaqueTokenClaims(c, tokenString); !ok {
+				utils.Logger.Warn("Invalid or expired opaque token")
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				c.Abort()
+				return
+			}
+		}
+
+		if sid, _ := claims["sid"].(string); sid != "" && !SessionActive(sid) {
+			utils.Logger.Warn("Token of a revoked session", zap.String("session_id", sid))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked"})
 			c.Abort()
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		c.Set("user_id", claims["user_id"])
-		c.Set("username", claims["username"])
+		if err := checkDPoP(c, scheme, tokenString, claims); err != nil {
+			return
+		}
+
+		userID, _ := claims["sub"].(string)
+		c.Set("user_id", userID)
+		c.Set("client_id", claims["aud"])
+		c.Set("scope", claims["scope"])
+		c.Set("access_token", tokenString)
+		if tenantID, ok := claims["tenant_id"].(string); ok {
+			c.Set("tenant_id", tenantID)
+		}
+		if sid, ok := claims["sid"].(string); ok {
+			c.Set("session_id", sid)
+		}
+		c.Set("claims", claims)
+
+		c.Next()
+	}
+}
 
+// SessionActive reports whether the login session exists and is not
+// revoked.
+func SessionActive(id string) bool {
+	var count int64
+	database.DB.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", id).Count(&count)
+	return count > 0
+}
+
+// opaqueTokenClaims resolves an opaque access token through the token store
+// into the claims a JWT for it would carry.
+func opaqueTokenClaims(c *gin.Context, tokenString string) (jwt.MapClaims, bool) {
+	if TokenStore == nil {
+		return nil, false
+	}
+	ti, err := TokenStore.GetByAccess(c.Request.Context(), tokenString)
+	if err != nil || ti == nil || ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Before(time.Now()) {
+		return nil, false
+	}
+
+	// Round-trip through JSON so that the claims have the same types as
+	// those of a parsed JWT.
+	raw, err := json.Marshal(utils.TokenClaims(ti))
+	if err != nil {
+		return nil, false
+	}
+	var claims jwt.MapClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, false
+	}
+	return claims, true
+}
+
+// checkDPoP enforces the key binding of DPoP-bound tokens (those with a
+// cnf.jkt claim), see dpop.Check. Every DPoP response carries the next
+// nonce. On failure it aborts the request and returns the error.
+func checkDPoP(c *gin.Context, scheme, tokenString string, claims jwt.MapClaims) error {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	now := time.Now()
+	err := dpop.Check(utils.SecretKey, dpopReplay, dpop.Request{
+		Scheme:      scheme,
+		Proof:       c.GetHeader("DPoP"),
+		Method:      c.Request.Method,
+		URL:         PublicURL + c.Request.URL.Path,
+		AccessToken: tokenString,
+		JKT:         jkt,
+	}, now)
+	if err != nil {
+		code := dpop.CodeInvalidProof
+		var checkErr *dpop.CheckError
+		if errors.As(err, &checkErr) {
+			code = checkErr.Code
+		}
+		utils.Logger.Warn("DPoP check failed", zap.String("error", code), zap.Error(err))
+		c.Header("DPoP-Nonce", dpop.NewNonce(utils.SecretKey, now))
+		c.Header("WWW-Authenticate", dpop.Challenge(code))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": code, "error_description": err.Error()})
+		return err
+	}
+	if jkt != "" {
+		c.Header("DPoP-Nonce", dpop.NewNonce(utils.SecretKey, now))
+	}
+	return nil
+}
+
+// RequireAdmin must run after JWTAuthMiddleware and only lets users with the
+// admin role through.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("user_id")
+		if userID == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "User token required"})
+			return
+		}
+
+		var user models.User
+		if err := database.DB.First(&user, "id = ?", userID).Error; err != nil || user.Role != models.RoleAdmin {
+			utils.Logger.Warn("Admin access denied", zap.String("user_id", userID))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		c.Set("admin_tenant_id", user.TenantID)
+		c.Set("platform_admin", user.TenantID == models.DefaultTenantID)
+		c.Next()
+	}
+}
+
+// RequirePlatformAdmin must run after RequireAdmin and only lets admins of
+// the default tenant through, who manage the tenants themselves.
+func RequirePlatformAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("platform_admin") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Platform admin access required"})
+			return
+		}
 		c.Next()
 	}
 }
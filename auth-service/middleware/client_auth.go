@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-oauth2/oauth2/v4"
+	"go.uber.org/zap"
+)
+
+// ClientAuth authenticates the calling client by HTTP Basic credentials or
+// the client_id and client_secret form fields, and sets client_id for the
+// handlers after it, rate limits included. Requests without credentials,
+// and public clients, which have no secret to prove their client_id with,
+// pass as anonymous; wrong credentials are rejected with 401.
+func ClientAuth(clients oauth2.ClientStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, secret, ok := c.Request.BasicAuth()
+		if !ok {
+			id, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+		}
+		if id == "" && secret == "" {
+			c.Next()
+			return
+		}
+
+		cli, err := clients.GetByID(c.Request.Context(), id)
+		if err == nil && cli.GetSecret() == "" && secret == "" {
+			c.Next()
+			return
+		}
+		if err != nil || cli.GetSecret() == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(cli.GetSecret())) != 1 {
+			utils.Logger.Warn("Client authentication failed", zap.String("client_id", id))
+			c.Header("WWW-Authenticate", `Basic realm="auth-service"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+			return
+		}
+		c.Set("client_id", id)
+		c.Set(clientAuthenticatedKey, true)
+		c.Next()
+	}
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/auth-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"go.uber.org/zap"
+)
+
+// clientAuthenticatedKey is set by ClientAuth on requests of authenticated
+// clients.
+const clientAuthenticatedKey = "client_authenticated"
+
+// maxRateLimitBody is how much of a JSON body RateLimit reads for the
+// email dimension.
+const maxRateLimitBody = 64 << 10
+
+var (
+	// RateLimitStore holds the token buckets; rate limiting is disabled while nil.
+	RateLimitStore utils.RateLimitStore
+	// RateLimits holds the policy of each rate limited route by name.
+	RateLimits = map[string]utils.RateLimitPolicy{}
+)
+
+// RateLimit applies the policy configured for route. Every dimension of the
+// policy has its own bucket per value, e.g. one per client_id; the request
+// is rejected with 429 when any of them is empty, and then takes no token
+// from the others. The X-RateLimit headers describe the bucket closest to
+// its limit.
+func RateLimit(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := RateLimits[route]
+		if RateLimitStore == nil || len(policy) == 0 {
+			c.Next()
+			return
+		}
+
+		var dimensions, keys []string
+		var limits []utils.RateLimit
+		for dimension, limit := range policy {
+			value := rateLimitValue(c, dimension)
+			if value == "" {
+				continue
+			}
+			dimensions = append(dimensions, dimension)
+			keys = append(keys, "rl:"+route+":"+dimension+":"+value)
+			limits = append(limits, limit)
+		}
+		if len(keys) == 0 {
+			c.Next()
+			return
+		}
+		results, err := RateLimitStore.Take(c.Request.Context(), keys, limits)
+		if err != nil {
+			// Fail open: an unavailable store must not take the service down.
+			utils.Logger.Error("Rate limit store failed", zap.String("route", route), zap.Error(err))
+			c.Next()
+			return
+		}
+
+		var tightest *utils.RateLimitResult
+		var denied *utils.RateLimitResult
+		for i := range results {
+			res := &results[i]
+			if tightest == nil || res.Remaining < tightest.Remaining {
+				tightest = res
+			}
+			if !res.Allowed && (denied == nil || res.RetryAfter > denied.RetryAfter) {
+				denied = res
+				utils.Logger.Warn("Rate limit exceeded",
+					zap.String("route", route),
+					zap.String("dimension", dimensions[i]),
+					zap.String("ip", c.ClientIP()),
+				)
+			}
+		}
+
+		if tightest != nil {
+			c.Header("X-RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.ResetAfter)))
+		}
+		if denied != nil {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(denied.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":             "rate_limited",
+				"error_description": "Too many requests, retry later",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitValue returns the caller's value for a dimension, or "" when the
+// request does not carry one.
+func rateLimitValue(c *gin.Context, dimension string) string {
+	switch dimension {
+	case utils.RateLimitByClient:
+		// Only authenticated clients: anyone can claim a client_id, and
+		// would then empty the bucket of its real users.
+		if c.GetBool(clientAuthenticatedKey) {
+			return c.GetString("client_id")
+		}
+	case utils.RateLimitByUser:
+		if id := c.GetString("user_id"); id != "" {
+			return id
+		}
+		if name := c.PostForm("username"); name != "" {
+			return "name:" + strings.ToLower(c.PostForm("tenant")) + ":" + utils.NormalizeUsername(name)
+		}
+	case utils.RateLimitByEmail:
+		if email, tenant := requestEmail(c); email != "" {
+			return strings.ToLower(tenant) + ":" + utils.NormalizeEmail(email)
+		}
+	case utils.RateLimitByIP:
+		// Authenticated clients have buckets of their own; several of
+		// them, e.g. the resource servers, may share an address.
+		if c.GetBool(clientAuthenticatedKey) {
+			return ""
+		}
+		return c.ClientIP()
+	}
+	return ""
+}
+
+// requestEmail returns the email and tenant fields of a form or JSON
+// request. A JSON body is restored for the handler.
+func requestEmail(c *gin.Context) (email, tenant string) {
+	if c.ContentType() != binding.MIMEJSON {
+		return c.PostForm("email"), c.PostForm("tenant")
+	}
+	raw, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRateLimitBody))
+	if err != nil {
+		return "", ""
+	}
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(raw), c.Request.Body))
+	var fields struct {
+		Email  string `json:"email"`
+		Tenant string `json:"tenant"`
+	}
+	json.Unmarshal(raw, &fields)
+	return fields.Email, fields.Tenant
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
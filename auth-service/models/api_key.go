@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// APIKey is a long-lived personal credential for programmatic access. Only
+// the SHA-256 hash of the key is stored; the key is shown once on creation.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"-"`
+	TenantID   uint       `gorm:"not null" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`
+	KeyID      string     `gorm:"uniqueIndex;not null" json:"key_id"`
+	KeyHash    string     `gorm:"uniqueIndex;not null" json:"-"`
+	Scope      string     `json:"scope"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsUsable reports whether the key is neither revoked nor expired.
+func (k *APIKey) IsUsable(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
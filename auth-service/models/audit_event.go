@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Audit event names.
+const (
+	AuditLoginSuccess   = "login.success"
+	AuditLoginFailure   = "login.failure"
+	AuditLoginLockout   = "login.lockout"
+	AuditTokenIssued    = "token.issued"
+	AuditTokenRefreshed = "token.refreshed"
+	AuditTokenDenied    = "token.denied"
+	AuditTokenRevoked   = "token.revoked"
+	AuditTokenExchanged = "token.exchanged"
+	AuditClientCreated  = "client.created"
+	AuditClientUpdated  = "client.updated"
+	AuditClientDeleted  = "client.deleted"
+	AuditDeviceApproved = "device.approved"
+	AuditDeviceDenied   = "device.denied"
+	AuditAPIKeyCreated  = "api_key.created"
+	AuditAPIKeyRevoked  = "api_key.revoked"
+
+	AuditAccountRegistered      = "account.registered"
+	AuditAccountPasswordChanged = "account.password_changed"
+	AuditAccountPasswordReset   = "account.password_reset"
+	AuditAccountEmailVerified   = "account.email_verified"
+	AuditAccountMFAEnabled      = "account.mfa_enabled"
+	AuditAccountMFADisabled     = "account.mfa_disabled"
+	AuditAccountSessionsRevoked = "account.sessions_revoked"
+	AuditAccountDeleted         = "account.deleted"
+
+	AuditAdminUnlock        = "admin.unlock"
+	AuditAdminInviteCreated = "admin.invite_created"
+	AuditAdminTenantCreated = "admin.tenant_created"
+	AuditAdminClientBound   = "admin.client_bound"
+
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEvent is one row of the append-only security audit log. The table
+// rejects UPDATE and DELETE at the database level.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	Event     string    `gorm:"index;not null" json:"event"`
+	Outcome   string    `gorm:"not null" json:"outcome"`
+	ActorID   string    `gorm:"index" json:"actor_id,omitempty"`
+	ClientID  string    `gorm:"index" json:"client_id,omitempty"`
+	TenantID  string    `gorm:"index" json:"tenant_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Details   JSONMap   `gorm:"type:jsonb" json:"details,omitempty"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+	DeviceStatusConsumed = "consumed"
+)
+
+// DeviceAuthorization is the state of one device authorization request
+// (RFC 8628). Only the hash of the device code is stored; the user code is
+// short-lived and typed in by the user.
+type DeviceAuthorization struct {
+	ID             uint   `gorm:"primaryKey"`
+	DeviceCodeHash string `gorm:"uniqueIndex;not null"`
+	UserCode       string `gorm:"uniqueIndex;not null"`
+	ClientID       string `gorm:"index;not null"`
+	Scope          string
+	Status         string `gorm:"not null;default:pending"`
+	UserID         string
+	AMR            string // space separated, copied into the issued token
+	Interval       int    `gorm:"not null"` // seconds between polls
+	LastPolledAt   *time.Time
+	ExpiresAt      time.Time `gorm:"index;not null"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
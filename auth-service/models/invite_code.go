@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// InviteCode gates registration when invite-only mode is enabled. Only the
+// hash of the code is stored.
+type InviteCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	CodeHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	TenantID  uint       `gorm:"not null;default:1;index" json:"tenant_id"`
+	Note      string     `json:"note"`
+	MaxUses   int        `gorm:"not null;default:1" json:"max_uses"`
+	Uses      int        `gorm:"not null;default:0" json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// IssuedToken indexes a token held by the token store by the user, client,
+// session and API key it was issued for. The store can only look tokens up
+// by their value, so revocation finds the tokens here and removes them
+// through the store, which keeps the index in step.
+type IssuedToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	Access    string `gorm:"index"`
+	Refresh   string `gorm:"index"`
+	UserID    string `gorm:"index"`
+	ClientID  string `gorm:"index"`
+	SessionID string `gorm:"index"`
+	APIKeyID  string `gorm:"index"`
+	// ExpiresAt is when the refresh token, or the access token when there
+	// is none, expires.
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
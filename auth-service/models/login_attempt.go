@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// LoginAttempt tracks failed password grant attempts for a single key,
+// either a username ("user:<name>") or a client IP ("ip:<addr>").
+type LoginAttempt struct {
+	ID            uint       `gorm:"primaryKey" json:"-"`
+	Key           string     `gorm:"uniqueIndex;not null" json:"key"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
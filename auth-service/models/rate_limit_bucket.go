@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// RateLimitBucket is a token bucket of the Postgres rate limit store.
+type RateLimitBucket struct {
+	Key        string    `gorm:"primaryKey"`
+	Tokens     float64   `gorm:"not null"`
+	RefilledAt time.Time `gorm:"index;not null"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// RegisteredClient holds the RFC 7591 metadata of a dynamically registered
+// client. The client itself lives in the go-oauth2-pg client store; only the
+// SHA-256 hash of its registration access token is kept.
+type RegisteredClient struct {
+	ClientID              string `gorm:"primaryKey"`
+	Metadata              []byte `gorm:"type:jsonb;not null"`
+	RegistrationTokenHash string `gorm:"uniqueIndex;not null"`
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+// OAuthClient maps the oauth2_clients table owned by the go-oauth2-pg client
+// store, which has no update or delete of its own. The table is created by
+// the store, not by AutoMigrate.
+type OAuthClient struct {
+	ID     string `gorm:"primaryKey"`
+	Secret string
+	Domain string
+	Data   []byte `gorm:"type:jsonb"`
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth2_clients"
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Session is one login of a user. Every token issued from the login, its
+// refreshes and the tokens exchanged from it carry the session ID in their
+// "sid" claim, so that revoking the session revokes all of them.
+type Session struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	UserID     uint       `gorm:"index;not null" json:"-"`
+	TenantID   uint       `gorm:"not null" json:"-"`
+	ClientID   string     `gorm:"not null" json:"client_id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	AMR        string     `json:"amr"` // space separated
+	LastUsedAt time.Time  `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+const (
+	// DefaultTenantID is the tenant of all users and data that predate
+	// multi-tenancy. Admins of this tenant administer the whole platform.
+	DefaultTenantID   uint = 1
+	DefaultTenantSlug      = "default"
+
+	TenantStatusActive   = "active"
+	TenantStatusDisabled = "disabled"
+)
+
+// Tenant is an organization or desk. Users, tokens and downstream data
+// belong to exactly one tenant.
+type Tenant struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Slug      string    `gorm:"uniqueIndex;not null" json:"slug"`
+	Name      string    `gorm:"not null" json:"name"`
+	Status    string    `gorm:"not null;default:active" json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IsActive reports whether the tenant's users may log in.
+func (t *Tenant) IsActive() bool {
+	return t.Status == "" || t.Status == TenantStatusActive
+}
+
+// ClientTenant binds an OAuth client to a single tenant. Clients without a
+// binding are shared by all tenants.
+type ClientTenant struct {
+	ClientID  string    `gorm:"primaryKey" json:"client_id"`
+	TenantID  uint      `gorm:"index;not null" json:"tenant_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,11 +1,37 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusDeleted  = "deleted"
+)
+
 type User struct {
 	gorm.Model
-	Username string `gorm:"unique" json:"username"`
-	Password string `json:"password"`
+	TenantID        uint       `gorm:"not null;default:1;index:idx_users_tenant_email,unique,priority:1,where:email <> ''" json:"-"`
+	Username        string     `json:"username"`
+	Password        string     `json:"password"`
+	Email           string     `gorm:"index:idx_users_tenant_email,unique,priority:2,where:email <> ''" json:"-"`
+	EmailVerifiedAt *time.Time `json:"-"`
+	DisplayName     string     `json:"-"`
+	Status          string     `gorm:"not null;default:active" json:"-"`
+	Role            string     `gorm:"not null;default:user" json:"-"`
+	MFAEnabled      bool       `gorm:"not null;default:false" json:"-"`
+	TOTPSecret      string     `json:"-"`
+	TOTPLastCounter int64      `json:"-"`
+	LastLoginAt     *time.Time `json:"-"`
+}
+
+// IsActive reports whether the user may log in.
+func (u *User) IsActive() bool {
+	return u.Status == "" || u.Status == UserStatusActive
 }
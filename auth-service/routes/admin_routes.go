@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/RanggaNehemia/golang-microservices/auth-service/controllers"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterAdminRoutes(router *gin.Engine) {
+	admin := router.Group("/admin")
+	admin.Use(middleware.JWTAuthMiddleware(), middleware.RequireAdmin())
+
+	admin.POST("/lockouts/unlock", controllers.UnlockLogin)
+	admin.GET("/invites", controllers.ListInvites)
+	admin.POST("/invites", controllers.CreateInvite)
+	admin.GET("/audit", controllers.ListAuditEvents)
+	admin.GET("/audit/export", controllers.ExportAuditEvents)
+
+	tenants := admin.Group("/tenants", middleware.RequirePlatformAdmin())
+	tenants.GET("", controllers.ListTenants)
+	tenants.POST("", controllers.CreateTenant)
+	tenants.POST("/:id/clients", controllers.BindClientTenant)
+}
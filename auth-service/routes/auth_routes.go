@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/RanggaNehemia/golang-microservices/auth-service/controllers"
+	"github.com/RanggaNehemia/golang-microservices/auth-service/middleware"
 	"github.com/gin-gonic/gin"
 )
 
@@ -9,5 +10,37 @@ func RegisterAuthRoutes(router *gin.Engine) {
 	auth := router.Group("/auth")
 
 	// Public routes
-	auth.POST("/register", controllers.Register)
+	auth.POST("/register", middleware.RateLimit("register"), controllers.Register)
+	auth.GET("/email/verify", middleware.RateLimit("email_verify"), controllers.VerifyEmail)
+	auth.POST("/email/verify", middleware.RateLimit("email_verify"), controllers.VerifyEmail)
+	auth.POST("/password/forgot", middleware.RateLimit("password_forgot"), controllers.ForgotPassword)
+	auth.GET("/password/reset", controllers.PasswordResetPage)
+	auth.POST("/password/reset", middleware.RateLimit("password_reset"), controllers.ResetPassword)
+
+	// Authenticated routes
+	account := auth.Group("")
+	account.Use(middleware.JWTAuthMiddleware())
+	account.GET("/me", controllers.Me)
+	account.PATCH("/me", controllers.UpdateProfile)
+	account.DELETE("/me", controllers.DeleteAccount)
+	account.POST("/me/password", controllers.ChangePassword)
+	account.POST("/email/verification", controllers.RequestEmailVerification)
+	account.GET("/sessions", controllers.ListSessions)
+	account.DELETE("/sessions", controllers.RevokeOtherSessions)
+	account.DELETE("/sessions/:id", controllers.RevokeSession)
+	account.POST("/device/verify", controllers.VerifyDevice)
+	account.GET("/api-keys", controllers.ListAPIKeys)
+	account.POST("/api-keys", controllers.CreateAPIKey)
+	account.DELETE("/api-keys/:id", controllers.RevokeAPIKey)
+
+	// Device flow verification page (RFC 8628)
+	router.GET("/device", controllers.DevicePage)
+	router.POST("/device", middleware.RateLimit("device"), controllers.SubmitDevicePage)
+
+	mfa := auth.Group("/mfa")
+	mfa.Use(middleware.JWTAuthMiddleware())
+	mfa.POST("/totp/enroll", controllers.EnrollTOTP)
+	mfa.POST("/totp/confirm", controllers.ConfirmTOTP)
+	mfa.POST("/recovery-codes", controllers.RegenerateRecoveryCodes)
+	mfa.POST("/disable", controllers.DisableMFA)
 }
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, keyID, err := GenerateAPIKey()
+	assert.NoError(t, err)
+	assert.True(t, IsAPIKey(key))
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix+keyID+"_"))
+	assert.Len(t, keyID, 12)
+
+	other, otherID, _ := GenerateAPIKey()
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, keyID, otherID)
+}
+
+func TestIsAPIKey(t *testing.T) {
+	assert.False(t, IsAPIKey("eyJhbGciOiJIUzUxMiJ9.e30.sig"))
+	assert.False(t, IsAPIKey(""))
+}
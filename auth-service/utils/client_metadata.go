@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/RanggaNehemia/golang-microservices/dpop"
+)
+
+// Token endpoint authentication methods a registered client may use. The
+// token endpoint reads credentials from the form only.
+const (
+	AuthMethodClientSecretPost = "client_secret_post"
+	AuthMethodNone             = "none"
+)
+
+// RegistrableGrantTypes are the grants a dynamically registered client may
+// ask for. The password grant is reserved for first-party clients.
+var RegistrableGrantTypes = []string{
+	"authorization_code",
+	"client_credentials",
+	"refresh_token",
+	GrantTypeDeviceCode,
+}
+
+// RFC 6749 section 3.3 scope-token.
+var scopeTokenPattern = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`)
+
+// ClientMetadata is the RFC 7591 client metadata we accept.
+type ClientMetadata struct {
+	ClientName              string                 `json:"client_name,omitempty"`
+	RedirectURIs            []string               `json:"redirect_uris,omitempty"`
+	GrantTypes              []string               `json:"grant_types,omitempty"`
+	ResponseTypes           []string               `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string                 `json:"token_endpoint_auth_method,omitempty"`
+	Scope                   string                 `json:"scope,omitempty"`
+	JWKSURI                 string                 `json:"jwks_uri,omitempty"`
+	JWKS                    map[string]interface{} `json:"jwks,omitempty"`
+}
+
+// ClientMetadataError is an RFC 7591 section 3.2.2 error.
+type ClientMetadataError struct {
+	Code        string
+	Description string
+}
+
+func (e *ClientMetadataError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func invalidMetadata(format string, args ...interface{}) *ClientMetadataError {
+	return &ClientMetadataError{Code: "invalid_client_metadata", Description: fmt.Sprintf(format, args...)}
+}
+
+func invalidRedirectURI(format string, args ...interface{}) *ClientMetadataError {
+	return &ClientMetadataError{Code: "invalid_redirect_uri", Description: fmt.Sprintf(format, args...)}
+}
+
+// Normalize fills in the RFC 7591 defaults and validates the metadata.
+// allowedScopes restricts the scopes a client may register; when empty any
+// well-formed scope is accepted.
+func (m *ClientMetadata) Normalize(allowedScopes []string) *ClientMetadataError {
+	m.ClientName = strings.TrimSpace(m.ClientName)
+	if len(m.GrantTypes) == 0 {
+		m.GrantTypes = []string{"authorization_code"}
+	}
+	if m.TokenEndpointAuthMethod == "" {
+		m.TokenEndpointAuthMethod = AuthMethodClientSecretPost
+	}
+	m.Scope = strings.Join(strings.Fields(m.Scope), " ")
+
+	grants := map[string]bool{}
+	for _, gt := range m.GrantTypes {
+		if !slices.Contains(RegistrableGrantTypes, gt) {
+			return invalidMetadata("grant type %q is not supported", gt)
+		}
+		grants[gt] = true
+	}
+	if grants["refresh_token"] && len(grants) == 1 {
+		return invalidMetadata("refresh_token requires another grant type")
+	}
+
+	if grants["authorization_code"] {
+		if len(m.ResponseTypes) == 0 {
+			m.ResponseTypes = []string{"code"}
+		}
+	} else if len(m.ResponseTypes) > 0 {
+		return invalidMetadata("response_types require the authorization_code grant")
+	}
+	for _, rt := range m.ResponseTypes {
+		if rt != "code" {
+			return invalidMetadata("response type %q is not supported", rt)
+		}
+	}
+
+	switch m.TokenEndpointAuthMethod {
+	case AuthMethodClientSecretPost:
+	case AuthMethodNone:
+		if grants["client_credentials"] {
+			return invalidMetadata("client_credentials requires a confidential client")
+		}
+	default:
+		return invalidMetadata("token_endpoint_auth_method %q is not supported", m.TokenEndpointAuthMethod)
+	}
+
+	if err := validateRedirectURIs(m.RedirectURIs, grants["authorization_code"]); err != nil {
+		return err
+	}
+
+	for _, s := range strings.Fields(m.Scope) {
+		if !scopeTokenPattern.MatchString(s) {
+			return invalidMetadata("scope %q is malformed", s)
+		}
+		if len(allowedScopes) > 0 && !slices.Contains(allowedScopes, s) {
+			return invalidMetadata("scope %q is not available", s)
+		}
+	}
+
+	return m.validateJWKS()
+}
+
+// RedirectDomain is the origin go-oauth2 checks redirect URIs against.
+func (m *ClientMetadata) RedirectDomain() string {
+	if len(m.RedirectURIs) == 0 {
+		return ""
+	}
+	u, _ := url.Parse(m.RedirectURIs[0])
+	return u.Scheme + "://" + u.Host
+}
+
+// validateRedirectURIs requires absolute https URIs without a fragment,
+// allowing http for loopback. go-oauth2 stores a single domain per client,
+// so all URIs must share one origin.
+func validateRedirectURIs(uris []string, required bool) *ClientMetadataError {
+	if required && len(uris) == 0 {
+		return invalidRedirectURI("redirect_uris are required for the authorization_code grant")
+	}
+	if !required && len(uris) > 0 {
+		return invalidRedirectURI("redirect_uris require the authorization_code grant")
+	}
+
+	origin := ""
+	for _, raw := range uris {
+		u, err := url.Parse(raw)
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return invalidRedirectURI("%q is not an absolute URI", raw)
+		}
+		if u.Fragment != "" {
+			return invalidRedirectURI("%q must not contain a fragment", raw)
+		}
+		switch u.Scheme {
+		case "https":
+		case "http":
+			if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+				return invalidRedirectURI("%q must use https", raw)
+			}
+		default:
+			return invalidRedirectURI("%q must use https", raw)
+		}
+
+		if o := u.Scheme + "://" + u.Host; origin == "" {
+			origin = o
+		} else if o != origin {
+			return invalidRedirectURI("all redirect_uris must share one origin")
+		}
+	}
+	return nil
+}
+
+// validateJWKS accepts either a jwks_uri or an inline set of public keys.
+func (m *ClientMetadata) validateJWKS() *ClientMetadataError {
+	if m.JWKSURI != "" && m.JWKS != nil {
+		return invalidMetadata("jwks and jwks_uri are mutually exclusive")
+	}
+	if m.JWKSURI != "" {
+		u, err := url.Parse(m.JWKSURI)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return invalidMetadata("jwks_uri must be an absolute https URI")
+		}
+	}
+	if m.JWKS == nil {
+		return nil
+	}
+
+	keys, ok := m.JWKS["keys"].([]interface{})
+	if !ok || len(keys) == 0 {
+		return invalidMetadata("jwks must contain a non-empty keys array")
+	}
+	for i, k := range keys {
+		jwk, ok := k.(map[string]interface{})
+		if !ok {
+			return invalidMetadata("jwks key %d is not an object", i)
+		}
+		if _, err := dpop.PublicKeyFromJWK(jwk); err != nil {
+			return invalidMetadata("jwks key %d: %v", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientMetadata_Defaults(t *testing.T) {
+	m := ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb"}, Scope: " read  trade "}
+	assert.Nil(t, m.Normalize(nil))
+	assert.Equal(t, []string{"authorization_code"}, m.GrantTypes)
+	assert.Equal(t, []string{"code"}, m.ResponseTypes)
+	assert.Equal(t, AuthMethodClientSecretPost, m.TokenEndpointAuthMethod)
+	assert.Equal(t, "read trade", m.Scope)
+	assert.Equal(t, "https://app.example.com", m.RedirectDomain())
+}
+
+func TestClientMetadata_Rejects(t *testing.T) {
+	cases := map[string]struct {
+		meta ClientMetadata
+		code string
+	}{
+		"password grant": {ClientMetadata{GrantTypes: []string{"password"}}, "invalid_client_metadata"},
+		"refresh only":   {ClientMetadata{GrantTypes: []string{"refresh_token"}}, "invalid_client_metadata"},
+		"public client credentials": {ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "none"},
+			"invalid_client_metadata"},
+		"basic auth": {ClientMetadata{GrantTypes: []string{"client_credentials"}, TokenEndpointAuthMethod: "client_secret_basic"},
+			"invalid_client_metadata"},
+		"missing redirect":  {ClientMetadata{}, "invalid_redirect_uri"},
+		"http redirect":     {ClientMetadata{RedirectURIs: []string{"http://app.example.com/cb"}}, "invalid_redirect_uri"},
+		"relative redirect": {ClientMetadata{RedirectURIs: []string{"/cb"}}, "invalid_redirect_uri"},
+		"fragment redirect": {ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb#x"}}, "invalid_redirect_uri"},
+		"mixed origins":     {ClientMetadata{RedirectURIs: []string{"https://a.example.com/cb", "https://b.example.com/cb"}}, "invalid_redirect_uri"},
+		"unneeded redirect": {ClientMetadata{GrantTypes: []string{"client_credentials"}, RedirectURIs: []string{"https://a.example.com/cb"}}, "invalid_redirect_uri"},
+		"implicit response": {ClientMetadata{RedirectURIs: []string{"https://a.example.com/cb"}, ResponseTypes: []string{"token"}}, "invalid_client_metadata"},
+		"malformed scope":   {ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: `read "all"`}, "invalid_client_metadata"},
+		"unknown scope":     {ClientMetadata{GrantTypes: []string{"client_credentials"}, Scope: "admin"}, "invalid_client_metadata"},
+		"jwks and jwks_uri": {ClientMetadata{GrantTypes: []string{"client_credentials"}, JWKSURI: "https://a.example.com/jwks", JWKS: map[string]interface{}{}}, "invalid_client_metadata"},
+		"http jwks_uri":     {ClientMetadata{GrantTypes: []string{"client_credentials"}, JWKSURI: "http://a.example.com/jwks"}, "invalid_client_metadata"},
+		"empty jwks":        {ClientMetadata{GrantTypes: []string{"client_credentials"}, JWKS: map[string]interface{}{"keys": []interface{}{}}}, "invalid_client_metadata"},
+		"private jwk":       {ClientMetadata{GrantTypes: []string{"client_credentials"}, JWKS: map[string]interface{}{"keys": []interface{}{map[string]interface{}{"kty": "oct", "k": "c2VjcmV0"}}}}, "invalid_client_metadata"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := tc.meta.Normalize([]string{"read", "trade"})
+			if assert.NotNil(t, err) {
+				assert.Equal(t, tc.code, err.Code)
+			}
+		})
+	}
+}
+
+func TestClientMetadata_AcceptsLoopbackAndPublicKeys(t *testing.T) {
+	m := ClientMetadata{
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		RedirectURIs:            []string{"http://127.0.0.1:8400/cb"},
+		TokenEndpointAuthMethod: AuthMethodNone,
+		JWKS: map[string]interface{}{"keys": []interface{}{map[string]interface{}{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+		}}},
+	}
+	assert.Nil(t, m.Normalize(nil))
+}
@@ -2,17 +2,64 @@ package utils
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
+	"go.uber.org/zap"
 )
 
 type Config struct {
-	Port            string
-	SecretKey       string
-	PGXDatabaseURL  string
+	Port           string
+	SecretKey      string
+	PGXDatabaseURL string
+	// Default token lifetimes, and per-client or per-grant overrides of
+	// lifetimes and token format.
 	TokenTTL        time.Duration
 	RefreshTokenTTL time.Duration
+	TokenPolicies   TokenPolicies
+	Lockout         LockoutPolicy
+	PublicURL       string
+	Mail            MailConfig
+	// Clients whose tokens may only be issued to users with a verified
+	// email, from REQUIRE_VERIFIED_EMAIL_CLIENTS. Unlisted clients are not
+	// gated, so the check is off by default.
+	VerifiedEmailClients []string
+	InviteOnly           bool
+	CaptchaVerifyURL     string
+	CaptchaSecret        string
+	// "memory" (default) or "postgres".
+	RateLimitStore string
+	RateLimits     map[string]RateLimitPolicy
+	// Clients allowed to use the token exchange grant.
+	TokenExchangeClients []string
+	TokenExchangeTTL     time.Duration
+	DeviceCodeTTL        time.Duration
+	DevicePollInterval   time.Duration
+	// Clients whose tokens must be DPoP-bound.
+	DPoPClients []string
+	// Upper bound on API key lifetimes, and lifetime of the access tokens
+	// issued for API keys.
+	APIKeyMaxTTL   time.Duration
+	APIKeyTokenTTL time.Duration
+	// Initial access token for dynamic client registration, and the scopes
+	// registered clients may ask for.
+	ClientRegistrationToken string
+	RegistrationScopes      []string
+}
+
+// Default rate limits by route; see ParseRateLimitPolicy for the format.
+var defaultRateLimits = map[string]string{
+	"token":      "client=600/m,user=20/m,ip=120/m",
+	"register":   "ip=10/h",
+	"introspect": "client=6000/m:1000,ip=6000/m:1000",
+	"device":     "client=300/m,ip=30/m",
+	// Every forgot password request sends an email, and reset and verify
+	// must not let anyone guess tokens at will.
+	"password_forgot": "email=5/h:3,ip=30/h:10",
+	"password_reset":  "ip=60/h:10",
+	"email_verify":    "ip=60/h:10",
 }
 
 func Load() *Config {
@@ -35,11 +82,131 @@ func Load() *Config {
 		Logger.Error("PGX_DATABASE_URL is required")
 	}
 
+	lockout := DefaultLockoutPolicy()
+	lockout.UserThreshold = getEnvInt("LOGIN_MAX_FAILURES", lockout.UserThreshold)
+	lockout.IPThreshold = getEnvInt("LOGIN_IP_MAX_FAILURES", lockout.IPThreshold)
+	lockout.BaseLockout = getEnvDuration("LOGIN_LOCKOUT_BASE", lockout.BaseLockout)
+	lockout.MaxLockout = getEnvDuration("LOGIN_LOCKOUT_MAX", lockout.MaxLockout)
+	lockout.FailureWindow = getEnvDuration("LOGIN_FAILURE_WINDOW", lockout.FailureWindow)
+
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:" + port
+	}
+
+	mail := MailConfig{
+		Driver:   os.Getenv("MAIL_DRIVER"),
+		From:     os.Getenv("MAIL_FROM"),
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+	}
+	if mail.Port == "" {
+		mail.Port = "587"
+	}
+
+	rateLimits := map[string]RateLimitPolicy{}
+	for route, fallback := range defaultRateLimits {
+		key := "RATE_LIMIT_" + strings.ToUpper(route)
+		spec, ok := os.LookupEnv(key)
+		if !ok {
+			spec = fallback
+		}
+		policy, err := ParseRateLimitPolicy(spec)
+		if err != nil {
+			Logger.Warn("Invalid rate limit in environment, using default", zap.String("key", key), zap.Error(err))
+			policy, _ = ParseRateLimitPolicy(fallback)
+		}
+		rateLimits[route] = policy
+	}
+
+	rateLimitStore := os.Getenv("RATE_LIMIT_STORE")
+	if rateLimitStore == "" {
+		rateLimitStore = "memory"
+	}
+
+	exchangeClients := getEnvList("TOKEN_EXCHANGE_CLIENTS")
+	if _, ok := os.LookupEnv("TOKEN_EXCHANGE_CLIENTS"); !ok {
+		exchangeClients = []string{"trade-service"}
+	}
+
+	tokenTTL := getEnvDuration("TOKEN_TTL", time.Hour)
+	refreshTokenTTL := getEnvDuration("REFRESH_TOKEN_TTL", 24*time.Hour)
+	tokenFormat := os.Getenv("TOKEN_FORMAT")
+	if tokenFormat != TokenFormatOpaque {
+		tokenFormat = TokenFormatJWT
+	}
+	grantPolicies, clientPolicies, err := ParseTokenPolicyOverrides(os.Getenv("TOKEN_POLICIES"))
+	if err != nil {
+		Logger.Fatal("Invalid TOKEN_POLICIES", zap.Error(err))
+	}
+
 	return &Config{
 		Port:            port,
 		SecretKey:       secret,
 		PGXDatabaseURL:  pgxURL,
-		TokenTTL:        time.Hour,      // 1h
-		RefreshTokenTTL: 24 * time.Hour, // 24h
+		TokenTTL:        tokenTTL,
+		RefreshTokenTTL: refreshTokenTTL,
+		TokenPolicies: TokenPolicies{
+			Default: TokenPolicy{AccessTTL: tokenTTL, RefreshTTL: refreshTokenTTL, Format: tokenFormat},
+			Grants:  grantPolicies,
+			Clients: clientPolicies,
+		},
+		Lockout:   lockout,
+		PublicURL: strings.TrimRight(publicURL, "/"),
+		Mail:      mail,
+
+		VerifiedEmailClients:    getEnvList("REQUIRE_VERIFIED_EMAIL_CLIENTS"),
+		InviteOnly:              os.Getenv("REGISTRATION_INVITE_ONLY") == "true",
+		CaptchaVerifyURL:        os.Getenv("CAPTCHA_VERIFY_URL"),
+		CaptchaSecret:           os.Getenv("CAPTCHA_SECRET"),
+		RateLimitStore:          rateLimitStore,
+		RateLimits:              rateLimits,
+		TokenExchangeClients:    exchangeClients,
+		TokenExchangeTTL:        getEnvDuration("TOKEN_EXCHANGE_TTL", 5*time.Minute),
+		DeviceCodeTTL:           getEnvDuration("DEVICE_CODE_TTL", 10*time.Minute),
+		DevicePollInterval:      getEnvDuration("DEVICE_POLL_INTERVAL", 5*time.Second),
+		DPoPClients:             getEnvList("DPOP_CLIENTS"),
+		APIKeyMaxTTL:            getEnvDuration("API_KEY_MAX_TTL", 365*24*time.Hour),
+		APIKeyTokenTTL:          getEnvDuration("API_KEY_TOKEN_TTL", 15*time.Minute),
+		ClientRegistrationToken: os.Getenv("CLIENT_REGISTRATION_TOKEN"),
+		RegistrationScopes:      getEnvList("CLIENT_REGISTRATION_SCOPES"),
+	}
+}
+
+func getEnvInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		Logger.Warn("Invalid integer in environment, using default", zap.String("key", key), zap.Error(err))
+		return fallback
+	}
+	return n
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		Logger.Warn("Invalid duration in environment, using default", zap.String("key", key), zap.Error(err))
+		return fallback
+	}
+	return d
+}
+
+func getEnvList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
 	}
+	return list
 }
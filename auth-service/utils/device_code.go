@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Device flow polling errors (RFC 8628 section 3.5).
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrExpiredToken         = errors.New("expired_token")
+	// ErrDeviceAccessDenied is access_denied with the 400 status of the
+	// device flow rather than the library's 403.
+	ErrDeviceAccessDenied = errors.New("access_denied")
+)
+
+// userCodeAlphabet has no vowels or look-alike characters, as suggested by
+// RFC 8628 section 6.1.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// GenerateUserCode returns a code such as "WDJB-MJHT" for the user to type.
+func GenerateUserCode() (string, error) {
+	code := make([]byte, 8)
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// NormalizeUserCode accepts user input in any case and with or without
+// separators and returns it in the form produced by GenerateUserCode.
+func NormalizeUserCode(input string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			b.WriteRune(r)
+		}
+	}
+	code := b.String()
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
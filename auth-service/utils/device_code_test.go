@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateUserCode_Format(t *testing.T) {
+	code, err := GenerateUserCode()
+	assert.NoError(t, err)
+	assert.Regexp(t, `^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`, code)
+	assert.Equal(t, code, NormalizeUserCode(code))
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	assert.Equal(t, "WDJB-MJHT", NormalizeUserCode("wdjb mjht"))
+	assert.Equal(t, "WDJB-MJHT", NormalizeUserCode(" WDJBMJHT "))
+	assert.Equal(t, "WDJ", NormalizeUserCode("wdj"))
+}
@@ -38,15 +38,11 @@ func (cg *CustomJWTAccessGenerate) Token(
 		aud = cid.(string)
 	}
 
-	claims := jwt.MapClaims{
-		"iss":   "https://auth.sampledomain.com",
-		"sub":   data.UserID,
-		"aud":   aud,
-		"iat":   time.Now().Unix(),
-		"exp":   data.TokenInfo.GetAccessCreateAt().Add(data.TokenInfo.GetAccessExpiresIn()).Unix(),
-		"jti":   uuid.New().String(),
-		"scope": data.TokenInfo.GetScope(),
-	}
+	claims := TokenClaims(data.TokenInfo)
+	claims["iss"] = "https://auth.sampledomain.com"
+	claims["aud"] = aud
+	claims["iat"] = time.Now().Unix()
+	claims["jti"] = uuid.New().String()
 
 	token := jwt.NewWithClaims(cg.SigningMethod, claims)
 	access, err = token.SignedString(cg.SignedKey)
@@ -62,3 +58,31 @@ func (cg *CustomJWTAccessGenerate) Token(
 	Logger.Info("JWT Token generated")
 	return access, refresh, nil
 }
+
+// TokenClaims returns the claims describing ti: subject, audience, expiry,
+// scope and the extension claims. Opaque tokens resolve to the same claims.
+func TokenClaims(ti oauth2.TokenInfo) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"sub":   ti.GetUserID(),
+		"aud":   ti.GetClientID(),
+		"exp":   ti.GetAccessCreateAt().Add(ti.GetAccessExpiresIn()).Unix(),
+		"scope": ti.GetScope(),
+	}
+
+	if amr := TokenExtension(ti)["amr"]; len(amr) > 0 {
+		claims["amr"] = amr
+	}
+	if act := TokenExtension(ti)["act"]; len(act) > 0 {
+		claims["act"] = ActorClaim(act)
+	}
+	if jkt := TokenExtension(ti).Get("jkt"); jkt != "" {
+		claims["cnf"] = map[string]string{"jkt": jkt}
+	}
+	if tenantID := TokenExtension(ti).Get("tenant_id"); tenantID != "" {
+		claims["tenant_id"] = tenantID
+	}
+	if sid := TokenExtension(ti).Get("sid"); sid != "" {
+		claims["sid"] = sid
+	}
+	return claims
+}
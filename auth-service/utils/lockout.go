@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// LockoutPolicy controls how failed password grant attempts are throttled.
+type LockoutPolicy struct {
+	UserThreshold int           // failures per username before locking
+	IPThreshold   int           // failures per client IP before locking
+	BaseLockout   time.Duration // first lockout period, doubled on every further failure
+	MaxLockout    time.Duration // upper bound for a single lockout period
+	FailureWindow time.Duration // failures older than this are forgotten
+}
+
+func DefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		UserThreshold: 5,
+		IPThreshold:   20,
+		BaseLockout:   time.Minute,
+		MaxLockout:    time.Hour,
+		FailureWindow: 15 * time.Minute,
+	}
+}
+
+// LockoutFor returns how long a key must stay locked after its given number
+// of consecutive failures, or zero when the threshold has not been reached.
+func (p LockoutPolicy) LockoutFor(failures, threshold int) time.Duration {
+	if threshold <= 0 || failures < threshold {
+		return 0
+	}
+	d := p.BaseLockout
+	for i := threshold; i < failures; i++ {
+		d *= 2
+		if d >= p.MaxLockout {
+			return p.MaxLockout
+		}
+	}
+	if d > p.MaxLockout {
+		return p.MaxLockout
+	}
+	return d
+}
+
+// LockoutError is returned by the password grant while a username or IP is locked.
+type LockoutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("login temporarily locked, retry after %s", e.RetryAfter)
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockoutFor_BelowThreshold(t *testing.T) {
+	p := DefaultLockoutPolicy()
+	assert.Equal(t, time.Duration(0), p.LockoutFor(0, 5))
+	assert.Equal(t, time.Duration(0), p.LockoutFor(4, 5))
+}
+
+func TestLockoutFor_ExponentialBackoff(t *testing.T) {
+	p := LockoutPolicy{BaseLockout: time.Minute, MaxLockout: 10 * time.Minute}
+	assert.Equal(t, time.Minute, p.LockoutFor(5, 5))
+	assert.Equal(t, 2*time.Minute, p.LockoutFor(6, 5))
+	assert.Equal(t, 4*time.Minute, p.LockoutFor(7, 5))
+	assert.Equal(t, 8*time.Minute, p.LockoutFor(8, 5))
+	assert.Equal(t, 10*time.Minute, p.LockoutFor(9, 5))
+	assert.Equal(t, 10*time.Minute, p.LockoutFor(500, 5))
+}
+
+func TestLockoutFor_DisabledThreshold(t *testing.T) {
+	p := DefaultLockoutPolicy()
+	assert.Equal(t, time.Duration(0), p.LockoutFor(100, 0))
+}
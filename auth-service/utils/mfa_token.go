@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"errors"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	GrantTypeMFAOTP = "urn:auth-service:grant-type:mfa-otp"
+	mfaTokenTTL     = 5 * time.Minute
+)
+
+// ErrEmailNotVerified is returned by the password grant when the client only
+// accepts users with a verified email address.
+var ErrEmailNotVerified = errors.New("email_not_verified")
+
+// MFARequiredError is returned by the password grant when the password was
+// correct but the user still has to present a second factor.
+type MFARequiredError struct {
+	MFAToken string
+}
+
+func (e *MFARequiredError) Error() string {
+	return "mfa_required"
+}
+
+// NewMFAToken issues the short-lived token that lets a client finish a
+// password login with the mfa-otp grant without resending the password.
+func NewMFAToken(userID, clientID, scope string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"typ":   "mfa",
+		"sub":   userID,
+		"aud":   clientID,
+		"scope": scope,
+		"iat":   now.Unix(),
+		"exp":   now.Add(mfaTokenTTL).Unix(),
+		"jti":   uuid.New().String(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(SecretKey)
+}
+
+// ParseMFAToken validates an mfa_token and returns its subject, client and scope.
+func ParseMFAToken(tokenString string) (userID, clientID, scope string, err error) {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		return SecretKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS512.Alg()}))
+	if err != nil || !token.Valid {
+		return "", "", "", errors.New("invalid mfa token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if typ, _ := claims["typ"].(string); typ != "mfa" {
+		return "", "", "", errors.New("invalid mfa token")
+	}
+	userID, _ = claims["sub"].(string)
+	clientID, _ = claims["aud"].(string)
+	scope, _ = claims["scope"].(string)
+	return userID, clientID, scope, nil
+}
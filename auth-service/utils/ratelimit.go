@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit dimensions a RateLimitPolicy can key on.
+const (
+	RateLimitByClient = "client"
+	RateLimitByUser   = "user"
+	RateLimitByIP     = "ip"
+	RateLimitByEmail  = "email"
+)
+
+// RateLimit is a token bucket: it holds up to Burst tokens and refills at
+// Rate tokens per second.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitPolicy maps a dimension (client, user, ip) to its limit.
+type RateLimitPolicy map[string]RateLimit
+
+// TokenBucket is the state of a bucket with the given limit: it held Tokens
+// at Last.
+type TokenBucket struct {
+	Tokens float64
+	Last   time.Time
+	Limit  RateLimit
+}
+
+// RateLimitResult is the outcome of taking one token from a bucket.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // until the next token, when not allowed
+	ResetAfter time.Duration // until the bucket is full again
+}
+
+// RateLimitStore keeps token buckets by key.
+type RateLimitStore interface {
+	// Take takes one token from each of the buckets named by keys, which
+	// have the given limits, as TakeTokens does. Results are in the order
+	// of keys.
+	Take(ctx context.Context, keys []string, limits []RateLimit) ([]RateLimitResult, error)
+}
+
+var rateLimitPeriods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// ParseRateLimit parses "<count>/<s|m|h|d>", optionally followed by
+// ":<burst>". The burst defaults to count.
+func ParseRateLimit(s string) (RateLimit, error) {
+	spec, burstSpec, hasBurst := strings.Cut(strings.TrimSpace(s), ":")
+	countSpec, unit, ok := strings.Cut(spec, "/")
+	period, known := rateLimitPeriods[unit]
+	count, err := strconv.Atoi(countSpec)
+	if !ok || !known || err != nil || count <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+
+	limit := RateLimit{Rate: float64(count) / period.Seconds(), Burst: count}
+	if hasBurst {
+		burst, err := strconv.Atoi(burstSpec)
+		if err != nil || burst <= 0 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit burst %q", s)
+		}
+		limit.Burst = burst
+	}
+	return limit, nil
+}
+
+// ParseRateLimitPolicy parses a comma separated list such as
+// "client=600/m,ip=60/m:10". "off" and the empty string give no limits.
+func ParseRateLimitPolicy(s string) (RateLimitPolicy, error) {
+	policy := RateLimitPolicy{}
+	if s = strings.TrimSpace(s); s == "" || s == "off" {
+		return policy, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		dimension, spec, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit policy entry %q", part)
+		}
+		switch dimension {
+		case RateLimitByClient, RateLimitByUser, RateLimitByIP, RateLimitByEmail:
+		default:
+			return nil, fmt.Errorf("unknown rate limit dimension %q", dimension)
+		}
+		limit, err := ParseRateLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		policy[dimension] = limit
+	}
+	return policy, nil
+}
+
+// TakeTokens refills the buckets up to now and takes one token from each
+// of them if every one has a token, and none otherwise, so that a request
+// denied by one limit does not use up the others. It updates the buckets
+// and returns one result per bucket; the request is allowed if all are.
+func TakeTokens(buckets []TokenBucket, now time.Time) []RateLimitResult {
+	allowed := true
+	for i := range buckets {
+		b := &buckets[i]
+		if elapsed := now.Sub(b.Last).Seconds(); elapsed > 0 {
+			b.Tokens = math.Min(float64(b.Limit.Burst), b.Tokens+elapsed*b.Limit.Rate)
+		}
+		b.Last = now
+		allowed = allowed && b.Tokens >= 1
+	}
+
+	results := make([]RateLimitResult, len(buckets))
+	for i := range buckets {
+		b := &buckets[i]
+		res := RateLimitResult{Limit: b.Limit.Burst, Allowed: b.Tokens >= 1}
+		if allowed {
+			b.Tokens--
+		} else if !res.Allowed {
+			res.RetryAfter = rateDuration(1-b.Tokens, b.Limit.Rate)
+		}
+		res.Remaining = int(math.Floor(b.Tokens))
+		res.ResetAfter = rateDuration(float64(b.Limit.Burst)-b.Tokens, b.Limit.Rate)
+		results[i] = res
+	}
+	return results
+}
+
+func rateDuration(tokens, rate float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens / rate * float64(time.Second)))
+}
+
+// MemoryRateLimitStore keeps buckets in process memory. Limits are per
+// instance; use the Postgres store when running several replicas.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]TokenBucket
+	now     func() time.Time
+}
+
+// memoryBucketSweepSize is the bucket count above which full buckets are dropped.
+const memoryBucketSweepSize = 10000
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]TokenBucket{}, now: time.Now}
+}
+
+func (s *MemoryRateLimitStore) Take(_ context.Context, keys []string, limits []RateLimit) ([]RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if len(s.buckets) >= memoryBucketSweepSize {
+		s.sweep(now)
+	}
+
+	buckets := make([]TokenBucket, len(keys))
+	for i, key := range keys {
+		b, ok := s.buckets[key]
+		if !ok {
+			b = TokenBucket{Tokens: float64(limits[i].Burst), Last: now}
+		}
+		b.Limit = limits[i]
+		buckets[i] = b
+	}
+	results := TakeTokens(buckets, now)
+	for i, key := range keys {
+		s.buckets[key] = buckets[i]
+	}
+	return results, nil
+}
+
+// sweep drops buckets that have refilled completely; they are
+// indistinguishable from new ones.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.Tokens+now.Sub(b.Last).Seconds()*b.Limit.Rate >= float64(b.Limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
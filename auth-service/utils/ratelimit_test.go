@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("60/m")
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 1, Burst: 60}, limit)
+
+	limit, err = ParseRateLimit("10/s:5")
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 10, Burst: 5}, limit)
+
+	for _, bad := range []string{"", "10", "10/w", "-1/m", "10/m:0", "x/m"} {
+		_, err := ParseRateLimit(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParseRateLimitPolicy(t *testing.T) {
+	policy, err := ParseRateLimitPolicy("client=600/m, ip=60/m:10")
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 10, Burst: 600}, policy[RateLimitByClient])
+	assert.Equal(t, RateLimit{Rate: 1, Burst: 10}, policy[RateLimitByIP])
+
+	policy, err = ParseRateLimitPolicy("email=5/h")
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 5.0 / 3600, Burst: 5}, policy[RateLimitByEmail])
+
+	policy, err = ParseRateLimitPolicy("off")
+	assert.NoError(t, err)
+	assert.Empty(t, policy)
+
+	_, err = ParseRateLimitPolicy("route=1/s")
+	assert.Error(t, err)
+}
+
+func TestTakeTokens_RefillsAtRate(t *testing.T) {
+	start := time.Unix(0, 0)
+	buckets := []TokenBucket{{Tokens: 2, Last: start, Limit: RateLimit{Rate: 1, Burst: 2}}}
+
+	res := TakeTokens(buckets, start)
+	assert.True(t, res[0].Allowed)
+	assert.Equal(t, 1, res[0].Remaining)
+
+	res = TakeTokens(buckets, start)
+	assert.True(t, res[0].Allowed)
+	assert.Equal(t, 0, res[0].Remaining)
+	assert.Equal(t, 2*time.Second, res[0].ResetAfter)
+
+	res = TakeTokens(buckets, start.Add(500*time.Millisecond))
+	assert.False(t, res[0].Allowed)
+	assert.Equal(t, 500*time.Millisecond, res[0].RetryAfter)
+
+	res = TakeTokens(buckets, start.Add(time.Second))
+	assert.True(t, res[0].Allowed)
+}
+
+func TestTakeTokens_DeniedRequestsTakeNothing(t *testing.T) {
+	start := time.Unix(0, 0)
+	buckets := []TokenBucket{
+		{Tokens: 5, Last: start, Limit: RateLimit{Rate: 1, Burst: 5}},
+		{Tokens: 0, Last: start, Limit: RateLimit{Rate: 1, Burst: 1}},
+	}
+
+	res := TakeTokens(buckets, start)
+	assert.True(t, res[0].Allowed)
+	assert.False(t, res[1].Allowed)
+	assert.Equal(t, time.Second, res[1].RetryAfter)
+	assert.Equal(t, []float64{5, 0}, []float64{buckets[0].Tokens, buckets[1].Tokens})
+
+	res = TakeTokens(buckets, start.Add(time.Second))
+	assert.True(t, res[0].Allowed && res[1].Allowed)
+	assert.Equal(t, []float64{4, 0}, []float64{buckets[0].Tokens, buckets[1].Tokens})
+}
+
+func TestMemoryRateLimitStore_KeysAreIndependent(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := []RateLimit{{Rate: 1, Burst: 1}}
+	ctx := context.Background()
+
+	res, _ := store.Take(ctx, []string{"a"}, limit)
+	assert.True(t, res[0].Allowed)
+	res, _ = store.Take(ctx, []string{"a"}, limit)
+	assert.False(t, res[0].Allowed)
+	assert.Equal(t, time.Second, res[0].RetryAfter)
+
+	res, _ = store.Take(ctx, []string{"b"}, limit)
+	assert.True(t, res[0].Allowed)
+
+	now = now.Add(time.Second)
+	res, _ = store.Take(ctx, []string{"a"}, limit)
+	assert.True(t, res[0].Allowed)
+}
+
+func TestMemoryRateLimitStore_AllOrNothing(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+	limits := []RateLimit{{Rate: 1, Burst: 2}, {Rate: 1, Burst: 1}}
+
+	res, _ := store.Take(ctx, []string{"client", "ip"}, limits)
+	assert.True(t, res[0].Allowed && res[1].Allowed)
+
+	// Denied by ip, so the client bucket keeps its last token
+	res, _ = store.Take(ctx, []string{"client", "ip"}, limits)
+	assert.False(t, res[1].Allowed)
+	res, _ = store.Take(ctx, []string{"client"}, limits[:1])
+	assert.True(t, res[0].Allowed)
+	assert.Equal(t, 0, res[0].Remaining)
+}
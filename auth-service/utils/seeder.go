@@ -10,7 +10,9 @@ import (
 	"go.uber.org/zap"
 )
 
-func SeedOAuthClients(ctx context.Context, conn *pgx.Conn) {
+// SeedOAuthClients creates the built-in clients that do not exist yet and
+// returns the IDs of the ones it created.
+func SeedOAuthClients(ctx context.Context, conn *pgx.Conn) []string {
 	adapter := pgx4adapter.NewConn(conn)
 	clientStore, err := pg.NewClientStore(adapter)
 	if err != nil {
@@ -36,8 +38,14 @@ func SeedOAuthClients(ctx context.Context, conn *pgx.Conn) {
 			Domain: "",
 			UserID: "",
 		},
+		{
+			// Public client for the terminal app's device flow.
+			ID:     "trading-cli",
+			Public: true,
+		},
 	}
 
+	var created []string
 	for _, c := range clients {
 		_, err := clientStore.GetByID(ctx, c.ID)
 		if err == nil {
@@ -51,5 +59,7 @@ func SeedOAuthClients(ctx context.Context, conn *pgx.Conn) {
 		}
 
 		Logger.Info("Seeded client", zap.String("Client", c.ID))
+		created = append(created, c.ID)
 	}
+	return created
 }
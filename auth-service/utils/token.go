@@ -9,16 +9,11 @@ import (
 var SecretKey []byte
 
 func init() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		Logger.Panic("No .env file found")
-	}
+	// Load environment variables from .env file when present. The logger is
+	// not initialised yet at this point, and the variables may already be
+	// provided by the environment (tests, containers), so a missing file is
+	// not fatal here; Load() reports missing required settings.
+	_ = godotenv.Load()
 
-	secret := os.Getenv("SECRET_KEY")
-	if secret == "" {
-		Logger.Error("SECRET_KEY not set in environment")
-	}
-
-	SecretKey = []byte(secret)
+	SecretKey = []byte(os.Getenv("SECRET_KEY"))
 }
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// RFC 8693 token exchange identifiers.
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// ErrInvalidTarget is returned when a token exchange asks for an audience
+// the client cannot obtain tokens for.
+var ErrInvalidTarget = errors.New("invalid_target")
+
+// DownscopeScope returns the scope of an exchanged token. The requested
+// scope must be a subset of the granted one; an empty request keeps the
+// granted scope. A subject token without scope places no restriction.
+func DownscopeScope(granted, requested string) (string, bool) {
+	if requested = strings.TrimSpace(requested); requested == "" {
+		return granted, true
+	}
+	if strings.TrimSpace(granted) == "" {
+		return requested, true
+	}
+
+	allowed := map[string]bool{}
+	for _, s := range strings.Fields(granted) {
+		allowed[s] = true
+	}
+	for _, s := range strings.Fields(requested) {
+		if !allowed[s] {
+			return "", false
+		}
+	}
+	return strings.Join(strings.Fields(requested), " "), true
+}
+
+// ActorClaim builds the nested "act" claim from a delegation chain, the
+// current actor first.
+func ActorClaim(chain []string) map[string]interface{} {
+	var act map[string]interface{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		claim := map[string]interface{}{"sub": chain[i]}
+		if act != nil {
+			claim["act"] = act
+		}
+		act = claim
+	}
+	return act
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownscopeScope(t *testing.T) {
+	scope, ok := DownscopeScope("trade data:read", "")
+	assert.True(t, ok)
+	assert.Equal(t, "trade data:read", scope)
+
+	scope, ok = DownscopeScope("trade data:read", " data:read ")
+	assert.True(t, ok)
+	assert.Equal(t, "data:read", scope)
+
+	_, ok = DownscopeScope("data:read", "data:read admin")
+	assert.False(t, ok)
+
+	scope, ok = DownscopeScope("", "data:read")
+	assert.True(t, ok)
+	assert.Equal(t, "data:read", scope)
+}
+
+func TestActorClaim_NestsDelegationChain(t *testing.T) {
+	assert.Nil(t, ActorClaim(nil))
+	assert.Equal(t, map[string]interface{}{
+		"sub": "trade-service",
+		"act": map[string]interface{}{"sub": "gateway"},
+	}, ActorClaim([]string{"trade-service", "gateway"}))
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/go-oauth2/oauth2/v4"
+	"github.com/google/uuid"
+)
+
+// fixedTTLGrants have a lifetime setting of their own (TOKEN_EXCHANGE_TTL,
+// API_KEY_TOKEN_TTL) that token policies do not override.
+var fixedTTLGrants = map[string]bool{
+	GrantTypeTokenExchange: true,
+	GrantTypeAPIKey:        true,
+}
+
+// PolicyAccessGenerate applies TokenPolicies to every issued token: it sets
+// the access and refresh lifetimes and issues either a JWT or an opaque
+// reference token.
+type PolicyAccessGenerate struct {
+	JWT      *CustomJWTAccessGenerate
+	Policies TokenPolicies
+}
+
+func NewPolicyAccessGenerate(jwtGen *CustomJWTAccessGenerate, policies TokenPolicies) *PolicyAccessGenerate {
+	return &PolicyAccessGenerate{JWT: jwtGen, Policies: policies}
+}
+
+func (g *PolicyAccessGenerate) Token(
+	ctx context.Context,
+	data *oauth2.GenerateBasic,
+	isGenRefresh bool,
+) (access, refresh string, err error) {
+	clientID := data.TokenInfo.GetClientID()
+	if data.Client != nil {
+		clientID = data.Client.GetID()
+	}
+	grantType := TokenRequestInfoFrom(ctx).GrantType
+	// The second step of a password login is still a password login.
+	if grantType == GrantTypeMFAOTP {
+		grantType = string(oauth2.PasswordCredentials)
+	}
+	policy := g.Policies.For(clientID, grantType)
+
+	if policy.AccessTTL > 0 && !fixedTTLGrants[grantType] {
+		data.TokenInfo.SetAccessExpiresIn(policy.AccessTTL)
+	}
+	if isGenRefresh && policy.RefreshTTL > 0 {
+		data.TokenInfo.SetRefreshExpiresIn(policy.RefreshTTL)
+	}
+
+	if policy.Format != TokenFormatOpaque {
+		return g.JWT.Token(ctx, data, isGenRefresh)
+	}
+
+	if access, err = RandomToken(32); err != nil {
+		return "", "", err
+	}
+	if isGenRefresh {
+		refresh = uuid.New().String()
+	}
+	return access, refresh, nil
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Access token formats.
+const (
+	// TokenFormatJWT tokens are self-contained and can be checked offline.
+	TokenFormatJWT = "jwt"
+	// TokenFormatOpaque tokens are random references that resource servers
+	// resolve through introspection.
+	TokenFormatOpaque = "opaque"
+)
+
+// TokenPolicy sets the lifetimes and format of issued tokens. Zero fields
+// fall back to the next less specific policy.
+type TokenPolicy struct {
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+	Format     string
+}
+
+// merge returns p with its zero fields taken from fallback.
+func (p TokenPolicy) merge(fallback TokenPolicy) TokenPolicy {
+	if p.AccessTTL == 0 {
+		p.AccessTTL = fallback.AccessTTL
+	}
+	if p.RefreshTTL == 0 {
+		p.RefreshTTL = fallback.RefreshTTL
+	}
+	if p.Format == "" {
+		p.Format = fallback.Format
+	}
+	return p
+}
+
+// TokenPolicies holds the global policy and its overrides. A client override
+// wins over a grant type override, which wins over the default.
+type TokenPolicies struct {
+	Default TokenPolicy
+	Grants  map[string]TokenPolicy
+	Clients map[string]TokenPolicy
+}
+
+// For returns the policy for a token issued to clientID with grantType.
+func (p TokenPolicies) For(clientID, grantType string) TokenPolicy {
+	policy := p.Default
+	if grantPolicy, ok := p.Grants[grantType]; ok {
+		policy = grantPolicy.merge(policy)
+	}
+	if clientPolicy, ok := p.Clients[clientID]; ok {
+		policy = clientPolicy.merge(policy)
+	}
+	if policy.Format == "" {
+		policy.Format = TokenFormatJWT
+	}
+	return policy
+}
+
+// ParseTokenPolicy parses a comma separated list such as
+// "access=5m,refresh=720h,format=opaque".
+func ParseTokenPolicy(s string) (TokenPolicy, error) {
+	var policy TokenPolicy
+	for _, part := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return policy, fmt.Errorf("invalid token policy entry %q", part)
+		}
+		switch key {
+		case "access", "refresh":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return policy, fmt.Errorf("invalid %s lifetime %q", key, value)
+			}
+			if key == "access" {
+				policy.AccessTTL = d
+			} else {
+				policy.RefreshTTL = d
+			}
+		case "format":
+			if value != TokenFormatJWT && value != TokenFormatOpaque {
+				return policy, fmt.Errorf("unknown token format %q", value)
+			}
+			policy.Format = value
+		default:
+			return policy, fmt.Errorf("unknown token policy setting %q", key)
+		}
+	}
+	return policy, nil
+}
+
+// ParseTokenPolicyOverrides parses a semicolon separated list of overrides
+// such as "client:data-service access=5m;grant:password refresh=720h".
+func ParseTokenPolicyOverrides(s string) (grants, clients map[string]TokenPolicy, err error) {
+	grants = map[string]TokenPolicy{}
+	clients = map[string]TokenPolicy{}
+	for _, entry := range strings.Split(s, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		target, spec, ok := strings.Cut(entry, " ")
+		kind, name, ok2 := strings.Cut(target, ":")
+		if !ok || !ok2 || name == "" {
+			return nil, nil, fmt.Errorf("invalid token policy override %q", entry)
+		}
+		policy, err := ParseTokenPolicy(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, nil, err
+		}
+		switch kind {
+		case "grant":
+			grants[name] = policy
+		case "client":
+			clients[name] = policy
+		default:
+			return nil, nil, fmt.Errorf("unknown token policy target %q", kind)
+		}
+	}
+	return grants, clients, nil
+}
+
+// IsJWT reports whether token has the shape of a compact JWS.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTokenPolicyOverrides(t *testing.T) {
+	grants, clients, err := ParseTokenPolicyOverrides(
+		"client:data-service access=5m,format=opaque; grant:password refresh=720h ;grant:urn:ietf:params:oauth:grant-type:device_code access=15m")
+	assert.NoError(t, err)
+	assert.Equal(t, TokenPolicy{AccessTTL: 5 * time.Minute, Format: TokenFormatOpaque}, clients["data-service"])
+	assert.Equal(t, TokenPolicy{RefreshTTL: 720 * time.Hour}, grants["password"])
+	assert.Equal(t, TokenPolicy{AccessTTL: 15 * time.Minute}, grants[GrantTypeDeviceCode])
+
+	grants, clients, err = ParseTokenPolicyOverrides("")
+	assert.NoError(t, err)
+	assert.Empty(t, grants)
+	assert.Empty(t, clients)
+
+	for _, bad := range []string{
+		"client:web",
+		"web access=5m",
+		"user:alice access=5m",
+		"client:web access=soon",
+		"client:web access=-5m",
+		"client:web format=paseto",
+		"client:web audience=x",
+	} {
+		_, _, err := ParseTokenPolicyOverrides(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestTokenPolicies_For(t *testing.T) {
+	policies := TokenPolicies{
+		Default: TokenPolicy{AccessTTL: time.Hour, RefreshTTL: 24 * time.Hour},
+		Grants: map[string]TokenPolicy{
+			"password":           {RefreshTTL: 720 * time.Hour},
+			"client_credentials": {AccessTTL: 10 * time.Minute},
+		},
+		Clients: map[string]TokenPolicy{
+			"data-service": {AccessTTL: 5 * time.Minute, Format: TokenFormatOpaque},
+		},
+	}
+
+	assert.Equal(t, TokenPolicy{AccessTTL: time.Hour, RefreshTTL: 720 * time.Hour, Format: TokenFormatJWT},
+		policies.For("webclient", "password"))
+	assert.Equal(t, TokenPolicy{AccessTTL: 10 * time.Minute, RefreshTTL: 24 * time.Hour, Format: TokenFormatJWT},
+		policies.For("trade-service", "client_credentials"))
+	// The client override wins over the grant override
+	assert.Equal(t, TokenPolicy{AccessTTL: 5 * time.Minute, RefreshTTL: 24 * time.Hour, Format: TokenFormatOpaque},
+		policies.For("data-service", "client_credentials"))
+}
+
+func TestIsJWT(t *testing.T) {
+	assert.True(t, IsJWT("eyJhbGciOiJIUzUxMiJ9.eyJzdWIiOiIxIn0.c2ln"))
+	assert.False(t, IsJWT("Zm9vYmFyYmF6cXV4"))
+	assert.False(t, IsJWT("tsk_0123456789ab_secret"))
+}
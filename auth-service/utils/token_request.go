@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/go-oauth2/oauth2/v4"
+)
+
+const TokenRequestKey contextKey = "token_request"
+
+// TokenRequestInfo carries details of a /oauth/token request to the grant
+// handlers and the token generator. Grant handlers record how the user
+// authenticated in AMR, which ends up in the token's "amr" claim. Token
+// exchange records the delegation chain in Actors for the "act" claim.
+type TokenRequestInfo struct {
+	GrantType    string
+	ClientIP     string
+	UserAgent    string
+	Scope        string
+	OTP          string
+	RecoveryCode string
+	AMR          []string
+	Actors       []string
+	// JKT is the thumbprint of the DPoP key the token is bound to.
+	JKT string
+	// Tenant is the tenant slug the client asked to log in to, and TenantID
+	// the tenant the issued token belongs to.
+	Tenant   string
+	TenantID string
+	// APIKeyID is set when the token is issued for a personal API key.
+	APIKeyID string
+	// SessionID is the login session the token belongs to.
+	SessionID string
+}
+
+// ErrInvalidTenant is returned when the requested tenant does not exist, is
+// disabled, or is not available to the client.
+var ErrInvalidTenant = errors.New("invalid_tenant")
+
+func WithTokenRequestInfo(ctx context.Context, info *TokenRequestInfo) context.Context {
+	return context.WithValue(ctx, TokenRequestKey, info)
+}
+
+// TokenRequestInfoFrom returns the request info stored in ctx, or an empty
+// one when the request did not come through the token endpoint.
+func TokenRequestInfoFrom(ctx context.Context) *TokenRequestInfo {
+	if info, ok := ctx.Value(TokenRequestKey).(*TokenRequestInfo); ok && info != nil {
+		return info
+	}
+	return &TokenRequestInfo{}
+}
+
+// ExtractTokenExtension copies request details that must survive refreshes
+// into the token's extension fields, which are persisted with the token.
+func ExtractTokenExtension(tgr *oauth2.TokenGenerateRequest, ti oauth2.ExtendableTokenInfo) {
+	if tgr.Request == nil {
+		return
+	}
+	info := TokenRequestInfoFrom(tgr.Request.Context())
+
+	ext := ti.GetExtension()
+	if ext == nil {
+		ext = url.Values{}
+	}
+	if len(info.AMR) > 0 {
+		ext["amr"] = info.AMR
+	}
+	if len(info.Actors) > 0 {
+		ext["act"] = info.Actors
+	}
+	if info.JKT != "" {
+		ext.Set("jkt", info.JKT)
+	}
+	if info.TenantID != "" {
+		ext.Set("tenant_id", info.TenantID)
+	}
+	if info.APIKeyID != "" {
+		ext.Set("api_key_id", info.APIKeyID)
+	}
+	if info.SessionID != "" {
+		ext.Set("sid", info.SessionID)
+	}
+	ti.SetExtension(ext)
+}
+
+// TokenExtension returns the extension fields of ti, if it has any.
+func TokenExtension(ti oauth2.TokenInfo) url.Values {
+	if eti, ok := ti.(oauth2.ExtendableTokenInfo); ok && eti.GetExtension() != nil {
+		return eti.GetExtension()
+	}
+	return url.Values{}
+}
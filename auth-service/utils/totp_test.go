@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B test secret, 6-digit truncation of the SHA1 vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		got, err := TOTPCode(rfcSecret, TOTPCounter(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, "t=%d", unix)
+	}
+}
+
+func TestValidateTOTP_RejectsReplay(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step, ok := ValidateTOTP(rfcSecret, "050471", now, 0)
+	assert.True(t, ok)
+
+	_, ok = ValidateTOTP(rfcSecret, "050471", now, step)
+	assert.False(t, ok)
+}
+
+func TestValidateTOTP_WrongCode(t *testing.T) {
+	_, ok := ValidateTOTP(rfcSecret, "000000", time.Unix(59, 0), 0)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP(rfcSecret, "12345", time.Unix(59, 0), 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("auth-service", "alice", "ABC")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/auth-service:alice?"))
+	assert.Contains(t, uri, "secret=ABC")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+	seen := map[string]bool{}
+	for _, c := range codes {
+		assert.Len(t, c, 11)
+		assert.False(t, seen[c])
+		seen[c] = true
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+const (
+	UsernameMinLength = 3
+	UsernameMaxLength = 32
+	PasswordMinLength = 8
+	// bcrypt ignores everything after 72 bytes.
+	PasswordMaxLength = 72
+)
+
+var (
+	usernamePattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+	tenantSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,31}$`)
+)
+
+// NormalizeUsername returns the canonical form used for storage and lookups,
+// which makes usernames unique case-insensitively.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// NormalizeEmail returns the canonical form of an email address.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ValidateUsername checks a normalized username and returns a message
+// describing the problem, or "" when it is acceptable.
+func ValidateUsername(username string) string {
+	switch {
+	case len(username) < UsernameMinLength || len(username) > UsernameMaxLength:
+		return "username must be between 3 and 32 characters"
+	case !usernamePattern.MatchString(username):
+		return "username may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit"
+	}
+	return ""
+}
+
+// ValidateTenantSlug checks a normalized tenant slug and returns a message
+// describing the problem, or "" when it is acceptable.
+func ValidateTenantSlug(slug string) string {
+	if !tenantSlugPattern.MatchString(slug) {
+		return "slug must be 2 to 32 lowercase letters, digits or '-', starting with a letter or digit"
+	}
+	return ""
+}
+
+// ValidatePassword applies the password policy of every way a password is
+// set: registration, password changes and resets. It returns a message
+// describing the problem, or "".
+func ValidatePassword(password string) string {
+	switch {
+	case password == "":
+		return "password is required"
+	case len(password) < PasswordMinLength:
+		return "password must be at least 8 characters"
+	case len(password) > PasswordMaxLength:
+		return "password must be at most 72 bytes"
+	}
+	return ""
+}
+
+// ValidateEmail returns a message describing the problem, or "".
+func ValidateEmail(email string) string {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "email is not a valid address"
+	}
+	return ""
+}
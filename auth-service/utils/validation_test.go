@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	assert.Equal(t, "alice", NormalizeUsername("  Alice "))
+}
+
+func TestValidateUsername(t *testing.T) {
+	for _, ok := range []string{"foo", "alice", "a.b-c_d", "trader01"} {
+		assert.Empty(t, ValidateUsername(ok), ok)
+	}
+	for _, bad := range []string{"", "ab", strings.Repeat("a", 33), "-alice", "al ice", "alice!", "Alice", "ålice"} {
+		assert.NotEmpty(t, ValidateUsername(bad), bad)
+	}
+}
+
+func TestValidateTenantSlug(t *testing.T) {
+	for _, ok := range []string{"default", "desk-7", "acme"} {
+		assert.Empty(t, ValidateTenantSlug(ok), ok)
+	}
+	for _, bad := range []string{"", "a", "-acme", "Acme", "acme_fx", strings.Repeat("a", 33)} {
+		assert.NotEmpty(t, ValidateTenantSlug(bad), bad)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	assert.Empty(t, ValidatePassword("pw123456"))
+	assert.NotEmpty(t, ValidatePassword(""))
+	assert.NotEmpty(t, ValidatePassword("pw12345"))
+	assert.NotEmpty(t, ValidatePassword(strings.Repeat("x", 73)))
+}
+
+func TestValidateEmail(t *testing.T) {
+	assert.Empty(t, ValidateEmail("dave@example.com"))
+	assert.NotEmpty(t, ValidateEmail("dave"))
+	assert.NotEmpty(t, ValidateEmail("Dave <dave@example.com>"))
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCandlePage = 500
+	maxCandlePage     = 1000
+)
+
+// GetCandles returns the OHLCV candles of ?symbol at ?interval whose
+// bucket starts in [from, to), oldest first. from and to are RFC 3339 or
+// Unix seconds; to defaults to now and from to one page before to. When
+// there are more candles than ?limit, next_from is the from of the next
+// page.
+func GetCandles(c *gin.Context) {
+	if c.Query("symbol") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "symbol is required"})
+		return
+	}
+	interval := c.DefaultQuery("interval", "1m")
+	d, ok := models.CandleDuration(interval)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be one of " + strings.Join(models.CandleIntervals, ", ")})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultCandlePage)))
+	if err != nil || limit <= 0 || limit > maxCandlePage {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxCandlePage)})
+		return
+	}
+
+	to := time.Now()
+	if v := c.Query("to"); v != "" {
+		if to, err = utils.ParsePriceTime(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+	}
+	from := to.Add(-time.Duration(limit) * d)
+	if v := c.Query("from"); v != "" {
+		if from, err = utils.ParsePriceTime(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+	}
+	// Include the candle that from falls into.
+	from = from.UTC().Truncate(d)
+
+	instrument, ok := findInstrument(c, c.Query("symbol"))
+	if !ok {
+		return
+	}
+
+	// Shared and tenant candles of the same bucket are merged into one.
+	var candles []models.Candle
+	err = database.DB.Table(models.CandleTable(interval)).
+		Select(`bucket_start,
+	(array_agg(open ORDER BY first_at))[1] AS open, MAX(high) AS high, MIN(low) AS low,
+	(array_agg(close ORDER BY last_at DESC))[1] AS close,
+	SUM(volume) AS volume, SUM(ticks)::bigint AS ticks`).
+		Scopes(models.TenantScope(c.GetString("tenant_id"))).
+		Where("instrument_id = ? AND bucket_start >= ? AND bucket_start < ?", instrument.ID, from, to).
+		Group("bucket_start").Order("bucket_start").Limit(limit + 1).
+		Scan(&candles).Error
+	if err != nil {
+		utils.Logger.Error("Could not get candles", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not get candles"})
+		return
+	}
+
+	resp := gin.H{"symbol": instrument.Symbol, "interval": interval}
+	if len(candles) > limit {
+		candles = candles[:limit]
+		resp["next_from"] = candles[limit-1].BucketStart.Add(d).UTC().Format(time.RFC3339)
+	}
+	if candles == nil {
+		candles = []models.Candle{}
+	}
+	resp["candles"] = candles
+
+	utils.Logger.Info("Candles requested",
+		zap.String("symbol", instrument.Symbol),
+		zap.String("interval", interval),
+		zap.String("user_id", c.GetString("user_id")),
+		zap.String("tenant_id", c.GetString("tenant_id")),
+	)
+	c.JSON(http.StatusOK, resp)
+}
@@ -1,6 +1,9 @@
 package controllers
 
 import (
+	"errors"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/RanggaNehemia/golang-microservices/data-service/database"
@@ -8,29 +11,98 @@ import (
 	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
+// instrumentFromPath looks up the instrument named by the :symbol path
+// parameter, answering 404 if there is none.
+func instrumentFromPath(c *gin.Context) (*models.Instrument, bool) {
+	return findInstrument(c, c.Param("symbol"))
+}
+
+// findInstrument looks up an instrument the caller's tenant sees by symbol,
+// answering 404 if there is none.
+func findInstrument(c *gin.Context, symbol string) (*models.Instrument, bool) {
+	return lookupInstrument(c, database.DB.Scopes(models.TenantScope(c.GetString("tenant_id"))), symbol)
+}
+
+// lookupInstrument looks up an instrument by symbol in db, answering 404 if
+// there is none.
+func lookupInstrument(c *gin.Context, db *gorm.DB, symbol string) (*models.Instrument, bool) {
+	symbol = strings.ToUpper(symbol)
+	var instrument models.Instrument
+	err := db.Where("symbol = ?", symbol).First(&instrument).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown instrument"})
+		return nil, false
+	}
+	if err != nil {
+		utils.Logger.Error("Could not get instrument", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not get instrument"})
+		return nil, false
+	}
+	return &instrument, true
+}
+
 func GetLatestPrice(c *gin.Context) {
+	instrument, ok := instrumentFromPath(c)
+	if !ok {
+		return
+	}
+
 	var latestPrice models.Price
-	result := database.DB.Order("created_at DESC").First(&latestPrice)
+	result := database.DB.Scopes(models.TenantScope(c.GetString("tenant_id"))).
+		Where("instrument_id = ?", instrument.ID).Order("created_at DESC").First(&latestPrice)
 	if result.Error != nil {
 		utils.Logger.Error("Could not get price", zap.Error(result.Error))
 		c.JSON(500, gin.H{"error": "Could not get price"})
 		return
 	}
 
+	utils.Logger.Info("Latest price requested",
+		zap.String("symbol", instrument.Symbol),
+		zap.String("user_id", c.GetString("user_id")),
+		zap.String("actor", c.GetString("actor")),
+		zap.String("tenant_id", c.GetString("tenant_id")),
+	)
 	c.JSON(200, latestPrice)
 }
 
 func GetLowestPrice(c *gin.Context) {
+	instrument, ok := instrumentFromPath(c)
+	if !ok {
+		return
+	}
+
 	var lowestPrice models.Price
 	timeLimit := time.Now().Add(-24 * time.Hour)
-	result := database.DB.Where("created_at > ?", timeLimit).Order("value ASC").First(&lowestPrice)
+	result := database.DB.Scopes(models.TenantScope(c.GetString("tenant_id"))).
+		Where("instrument_id = ? AND created_at > ?", instrument.ID, timeLimit).Order("value ASC").First(&lowestPrice)
 	if result.Error != nil {
 		utils.Logger.Error("Could not get price", zap.Error(result.Error))
 		c.JSON(500, gin.H{"error": "Could not get price"})
 		return
 	}
 
+	utils.Logger.Info("Lowest price requested",
+		zap.String("symbol", instrument.Symbol),
+		zap.String("user_id", c.GetString("user_id")),
+		zap.String("actor", c.GetString("actor")),
+		zap.String("tenant_id", c.GetString("tenant_id")),
+	)
 	c.JSON(200, lowestPrice)
 }
+
+// ListInstruments returns the instruments of the caller's tenant and the
+// shared ones that are not delisted.
+func ListInstruments(c *gin.Context) {
+	var instruments []models.Instrument
+	err := database.DB.Scopes(models.TenantScope(c.GetString("tenant_id"))).
+		Where("status <> ?", models.InstrumentStatusDelisted).Order("symbol").Find(&instruments).Error
+	if err != nil {
+		utils.Logger.Error("Could not list instruments", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list instruments"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"instruments": instruments})
+}
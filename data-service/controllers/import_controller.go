@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/importer"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxImportBytes bounds uploaded price files.
+const maxImportBytes = 512 << 20
+
+// ImportPrices loads the price file uploaded in the "file" form field. The
+// format comes from the file name (.csv, .json, .ndjson or .parquet,
+// optionally .gz) unless ?format is given, and ?tenant_id imports the
+// prices for one tenant instead of as shared market data.
+func ImportPrices(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A price file is required"})
+		return
+	}
+
+	format, gzipped, err := utils.PriceFileFormat(header.Filename)
+	if f := c.Query("format"); f != "" {
+		format, err = f, nil
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be csv, json, ndjson or parquet"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the file"})
+		return
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if gzipped {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the file"})
+			return
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	opts := importer.Options{Format: format, TenantID: c.Query("tenant_id")}
+	report, err := importer.Import(c.Request.Context(), database.DB, r, opts)
+	if errors.Is(err, importer.ErrInvalidFile) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.Logger.Error("Price import failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Price import failed", "report": report})
+		return
+	}
+
+	utils.Logger.Info("Price file imported",
+		zap.String("file", header.Filename),
+		zap.String("admin_id", c.GetString("user_id")),
+	)
+	c.JSON(http.StatusOK, report)
+}
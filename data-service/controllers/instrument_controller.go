@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// instrumentInput is the body of the admin instrument routes. Tick sizes
+// may be given as numbers or decimal strings.
+type instrumentInput struct {
+	Symbol string `json:"symbol"`
+	// TenantID is set by platform admins only; the instruments other
+	// admins create belong to their tenant.
+	TenantID          *string          `json:"tenant_id"`
+	Name              *string          `json:"name"`
+	TickSize          *decimal.Decimal `json:"tick_size"`
+	QuantityPrecision *int32           `json:"quantity_precision"`
+	Currency          *string          `json:"currency"`
+	Status            *string          `json:"status"`
+}
+
+// apply copies the fields present in the input onto instrument and
+// validates the result.
+func (in instrumentInput) apply(instrument *models.Instrument) string {
+	if in.Name != nil {
+		instrument.Name = strings.TrimSpace(*in.Name)
+	}
+	if in.TickSize != nil {
+		instrument.TickSize = *in.TickSize
+	}
+	if in.QuantityPrecision != nil {
+		instrument.QuantityPrecision = *in.QuantityPrecision
+	}
+	if in.Currency != nil {
+		instrument.Currency = strings.ToUpper(*in.Currency)
+	}
+	if in.Status != nil {
+		instrument.Status = *in.Status
+	}
+
+	switch {
+	case !models.ValidSymbol(instrument.Symbol):
+		return "Symbol must be 1-20 upper case letters, digits, '.', '_' or '-'"
+	case !instrument.TickSize.IsPositive():
+		return "Tick size must be positive"
+	case instrument.QuantityPrecision < 0 || instrument.QuantityPrecision > models.MaxQuantityPrecision:
+		return fmt.Sprintf("Quantity precision must be between 0 and %d", models.MaxQuantityPrecision)
+	case !models.ValidCurrency(instrument.Currency):
+		return "Currency must be a three letter code"
+	case !models.ValidInstrumentStatus(instrument.Status):
+		return "Status must be active, halted or delisted"
+	}
+	return ""
+}
+
+// adminScope limits an instrument query of an admin to the instruments
+// they see: all for platform admins, their tenant's and the shared ones
+// for the others.
+func adminScope(c *gin.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if c.GetBool("platform_admin") {
+			return db
+		}
+		return db.Scopes(models.TenantScope(c.GetString("tenant_id")))
+	}
+}
+
+// AdminListInstruments returns every instrument the admin sees, delisted
+// ones included.
+func AdminListInstruments(c *gin.Context) {
+	var instruments []models.Instrument
+	if err := database.DB.Scopes(adminScope(c)).Order("symbol").Find(&instruments).Error; err != nil {
+		utils.Logger.Error("Could not list instruments", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list instruments"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"instruments": instruments})
+}
+
+// CreateInstrument adds an instrument; prices are generated for it from the
+// next tick on if it is active. Platform admins add shared instruments, or
+// those of the tenant in tenant_id; other admins add their tenant's.
+func CreateInstrument(c *gin.Context) {
+	var input instrumentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	tenantID := c.GetString("tenant_id")
+	if c.GetBool("platform_admin") {
+		tenantID = ""
+		if input.TenantID != nil {
+			tenantID = strings.TrimSpace(*input.TenantID)
+		}
+	} else if input.TenantID != nil && *input.TenantID != tenantID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Instruments can only be added to your tenant"})
+		return
+	}
+
+	instrument := models.Instrument{
+		Symbol:   strings.ToUpper(strings.TrimSpace(input.Symbol)),
+		TenantID: tenantID,
+		Status:   models.InstrumentStatusActive,
+	}
+	if msg := input.apply(&instrument); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.Instrument{}).Where("symbol = ?", instrument.Symbol).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Instrument already exists"})
+		return
+	}
+	if err := database.DB.Create(&instrument).Error; err != nil {
+		utils.Logger.Error("Failed to create instrument", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create instrument"})
+		return
+	}
+
+	utils.Logger.Info("Instrument created",
+		zap.String("symbol", instrument.Symbol),
+		zap.String("tenant_id", instrument.TenantID),
+		zap.String("admin_id", c.GetString("user_id")),
+	)
+	c.JSON(http.StatusCreated, instrument)
+}
+
+// UpdateInstrument changes the name, tick size, currency or status of an
+// instrument. The symbol and tenant are immutable. Only platform admins
+// change shared instruments and those of other tenants.
+func UpdateInstrument(c *gin.Context) {
+	db := database.DB
+	if !c.GetBool("platform_admin") {
+		db = db.Where("tenant_id = ?", c.GetString("tenant_id"))
+	}
+	instrument, ok := lookupInstrument(c, db, c.Param("symbol"))
+	if !ok {
+		return
+	}
+
+	var input instrumentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if input.Symbol != "" && strings.ToUpper(input.Symbol) != instrument.Symbol {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Symbol cannot be changed"})
+		return
+	}
+	if input.TenantID != nil && *input.TenantID != instrument.TenantID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tenant cannot be changed"})
+		return
+	}
+	if msg := input.apply(instrument); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := database.DB.Save(instrument).Error; err != nil {
+		utils.Logger.Error("Failed to update instrument", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update instrument"})
+		return
+	}
+
+	utils.Logger.Info("Instrument updated",
+		zap.String("symbol", instrument.Symbol),
+		zap.String("status", instrument.Status),
+		zap.String("admin_id", c.GetString("user_id")),
+	)
+	c.JSON(http.StatusOK, instrument)
+}
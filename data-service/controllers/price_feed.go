@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// maxIngestBody caps the size of a webhook delivery.
+const maxIngestBody = 1 << 20
+
+// ActiveSymbols lists the instruments prices are generated for.
+func ActiveSymbols() ([]string, error) {
+	var symbols []string
+	err := database.DB.Model(&models.Instrument{}).
+		Where("status = ?", models.InstrumentStatusActive).Pluck("symbol", &symbols).Error
+	return symbols, err
+}
+
+// LastPrice returns the latest price of an instrument as recorded by
+// RecordPrice, for the simulators to continue from.
+func LastPrice(symbol string) (float64, bool) {
+	var price models.Price
+	err := database.DB.Joins("JOIN instruments ON instruments.id = prices.instrument_id").
+		Where("instruments.symbol = ? AND prices.tenant_id = instruments.tenant_id", symbol).
+		Order("prices.created_at DESC").First(&price).Error
+	return price.Value.InexactFloat64(), err == nil
+}
+
+// RecordPrice stores a tick from the price source, rounded to the
+// instrument's tick size, and streams it to subscribers. Prices of shared
+// instruments are shared market data, those of a tenant's instruments are
+// the tenant's. Only active instruments are priced.
+func RecordPrice(tick utils.PriceTick) error {
+	var instrument models.Instrument
+	err := database.DB.Where("symbol = ?", strings.ToUpper(tick.Symbol)).First(&instrument).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("unknown instrument %q", tick.Symbol)
+	}
+	if err != nil {
+		return err
+	}
+	if instrument.Status != models.InstrumentStatusActive {
+		return fmt.Errorf("instrument %s is %s", instrument.Symbol, instrument.Status)
+	}
+
+	price := models.Price{
+		InstrumentID: instrument.ID,
+		TenantID:     instrument.TenantID,
+		Value:        instrument.RoundToTick(tick.Value),
+		Volume:       tick.Volume,
+		CreatedAt:    tick.Time,
+	}
+	if !price.Value.IsPositive() {
+		price.Value = instrument.TickSize
+	}
+	if err := database.DB.Create(&price).Error; err != nil {
+		return err
+	}
+	publishPrice(&instrument, &price)
+	utils.Logger.Info("Recorded price", zap.String("symbol", instrument.Symbol), zap.Stringer("price", price.Value))
+	return nil
+}
+
+// IngestPrices accepts ticks posted to the webhook price source. The body
+// is one tick or an array of them, signed in the X-Signature-256 header.
+func IngestPrices(webhook *utils.PriceWebhook) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIngestBody+1))
+		if err != nil || len(body) > maxIngestBody {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Payload too large"})
+			return
+		}
+		if !webhook.Verify(body, c.GetHeader("X-Signature-256")) {
+			utils.Logger.Warn("Webhook signature mismatch", zap.String("ip", c.ClientIP()))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+			return
+		}
+
+		ticks, err := utils.ParsePriceTicks(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now()
+		accepted := 0
+		rejected := []gin.H{}
+		for i, tick := range ticks {
+			// Ticks from the future would shadow the latest price.
+			if tick.Time.IsZero() || tick.Time.After(now) {
+				tick.Time = now
+			}
+			if err := webhook.Deliver(tick); err != nil {
+				if errors.Is(err, utils.ErrPriceSourceStopped) {
+					c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+					return
+				}
+				rejected = append(rejected, gin.H{"index": i, "error": err.Error()})
+				continue
+			}
+			accepted++
+		}
+		c.JSON(http.StatusAccepted, gin.H{"accepted": accepted, "rejected": rejected})
+	}
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPricePage = 100
+	maxPricePage     = 1000
+	// exportBatch is how many rows a CSV or NDJSON export reads at a time.
+	exportBatch = 1000
+)
+
+// pricePoint is a price as served by the history endpoint.
+type pricePoint struct {
+	ID     uint            `json:"id"`
+	Time   time.Time       `json:"time"`
+	Value  decimal.Decimal `json:"value"`
+	Volume decimal.Decimal `json:"volume"`
+}
+
+// priceCursor is the position after the last price of a page.
+type priceCursor struct {
+	Time time.Time
+	ID   uint
+}
+
+func (p priceCursor) String() string {
+	raw := strconv.FormatInt(p.Time.UnixNano(), 10) + ":" + strconv.FormatUint(uint64(p.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parsePriceCursor(s string) (priceCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return priceCursor{}, err
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return priceCursor{}, fmt.Errorf("malformed cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return priceCursor{}, err
+	}
+	i, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return priceCursor{}, err
+	}
+	return priceCursor{Time: time.Unix(0, n), ID: uint(i)}, nil
+}
+
+// priceQuery is a validated /data/prices request.
+type priceQuery struct {
+	instrument *models.Instrument
+	tenantID   string
+	from, to   time.Time
+	desc       bool
+	after      *priceCursor
+}
+
+// page returns up to n prices following q.after in the requested order.
+func (q priceQuery) page(n int) ([]pricePoint, error) {
+	order := "created_at ASC, id ASC"
+	if q.desc {
+		order = "created_at DESC, id DESC"
+	}
+	db := database.DB.Model(&models.Price{}).
+		Scopes(models.TenantScope(q.tenantID)).
+		Where("instrument_id = ?", q.instrument.ID)
+	if !q.from.IsZero() {
+		db = db.Where("created_at >= ?", q.from)
+	}
+	if !q.to.IsZero() {
+		db = db.Where("created_at < ?", q.to)
+	}
+	if q.after != nil {
+		if q.desc {
+			db = db.Where("(created_at, id) < (?, ?)", q.after.Time, q.after.ID)
+		} else {
+			db = db.Where("(created_at, id) > (?, ?)", q.after.Time, q.after.ID)
+		}
+	}
+
+	var points []pricePoint
+	err := db.Select("id, created_at AS time, value, volume").Order(order).Limit(n).Scan(&points).Error
+	return points, err
+}
+
+// GetPriceHistory serves the prices of ?symbol between ?from and ?to
+// (RFC 3339 or Unix seconds), ordered by time (?order=asc, the default, or
+// desc). JSON responses are pages of ?limit prices with a next_cursor to
+// pass as ?cursor. With ?format=csv or ?format=ndjson the whole range is
+// streamed instead.
+func GetPriceHistory(c *gin.Context) {
+	if c.Query("symbol") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "symbol is required"})
+		return
+	}
+	q := priceQuery{tenantID: c.GetString("tenant_id")}
+
+	var err error
+	if v := c.Query("from"); v != "" {
+		if q.from, err = utils.ParsePriceTime(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if q.to, err = utils.ParsePriceTime(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		q.desc = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := parsePriceCursor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		q.after = &cursor
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, csv or ndjson"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPricePage)))
+	if err != nil || limit <= 0 || limit > maxPricePage {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxPricePage)})
+		return
+	}
+
+	instrument, ok := findInstrument(c, c.Query("symbol"))
+	if !ok {
+		return
+	}
+	q.instrument = instrument
+
+	utils.Logger.Info("Price history requested",
+		zap.String("symbol", instrument.Symbol),
+		zap.String("format", format),
+		zap.String("user_id", c.GetString("user_id")),
+		zap.String("tenant_id", c.GetString("tenant_id")),
+	)
+
+	if format != "json" {
+		exportPrices(c, q, format)
+		return
+	}
+
+	points, err := q.page(limit + 1)
+	if err != nil {
+		utils.Logger.Error("Could not get prices", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not get prices"})
+		return
+	}
+	resp := gin.H{"symbol": instrument.Symbol}
+	if len(points) > limit {
+		points = points[:limit]
+		last := points[limit-1]
+		resp["next_cursor"] = priceCursor{Time: last.Time, ID: last.ID}.String()
+	}
+	if points == nil {
+		points = []pricePoint{}
+	}
+	resp["prices"] = points
+	c.JSON(http.StatusOK, resp)
+}
+
+// exportPrices streams every price of q as CSV or NDJSON, reading the
+// table in batches so that memory use does not grow with the range.
+func exportPrices(c *gin.Context, q priceQuery, format string) {
+	filename := strings.ToLower(q.instrument.Symbol) + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	enc := json.NewEncoder(c.Writer)
+	if format == "csv" {
+		w.Write([]string{"id", "time", "value", "volume"})
+	}
+
+	rows := 0
+	for {
+		points, err := q.page(exportBatch)
+		if err != nil {
+			// The status is already sent; the export just ends early.
+			utils.Logger.Error("Price export failed", zap.Error(err), zap.Int("rows", rows))
+			return
+		}
+		for _, p := range points {
+			if format == "csv" {
+				w.Write([]string{
+					strconv.FormatUint(uint64(p.ID), 10),
+					p.Time.UTC().Format(time.RFC3339Nano),
+					p.Value.String(),
+					p.Volume.String(),
+				})
+			} else {
+				enc.Encode(p)
+			}
+		}
+		rows += len(points)
+		w.Flush()
+		c.Writer.Flush()
+
+		if len(points) < exportBatch || c.Request.Context().Err() != nil {
+			return
+		}
+		last := points[len(points)-1]
+		q.after = &priceCursor{Time: last.Time, ID: last.ID}
+	}
+}
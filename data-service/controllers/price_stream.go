@@ -0,0 +1,366 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// Hub streams every price recorded by this process.
+var Hub = utils.NewPriceHub()
+
+// wsWriteWait bounds every write to a WebSocket, so that a client that
+// stopped reading cannot hold its stream open.
+const wsWriteWait = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Streams are authorized by bearer token, not by cookies, so pages of
+	// other origins gain nothing they could not do themselves.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func publishPrice(instrument *models.Instrument, price *models.Price) {
+	Hub.Publish(utils.PriceEvent{
+		ID:       price.ID,
+		Symbol:   instrument.Symbol,
+		TenantID: price.TenantID,
+		Value:    price.Value,
+		Volume:   price.Volume,
+		Time:     price.CreatedAt,
+	})
+}
+
+// streamRequest is a validated request to open a price stream.
+type streamRequest struct {
+	tenantID string
+	symbols  []string
+	lastID   uint
+}
+
+// parseSymbols upper cases a list of symbols and checks that each is a
+// valid symbol or utils.AllSymbols.
+func parseSymbols(symbols []string) ([]string, error) {
+	var parsed []string
+	for _, s := range symbols {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if s != utils.AllSymbols && !models.ValidSymbol(s) {
+			return nil, fmt.Errorf("invalid symbol %q", s)
+		}
+		parsed = append(parsed, s)
+	}
+	return parsed, nil
+}
+
+// parseStreamRequest reads ?symbols (comma separated, all instruments if
+// absent) and the ID of the last price the client received, from ?last_id
+// or the Last-Event-ID header EventSource sends when it reconnects.
+func parseStreamRequest(c *gin.Context) (streamRequest, bool) {
+	req := streamRequest{tenantID: c.GetString("tenant_id")}
+
+	symbols, err := parseSymbols(strings.Split(c.Query("symbols"), ","))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, false
+	}
+	if len(symbols) == 0 {
+		symbols = []string{utils.AllSymbols}
+	}
+	req.symbols = symbols
+
+	lastID := c.Query("last_id")
+	if lastID == "" {
+		lastID = c.GetHeader("Last-Event-ID")
+	}
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last_id"})
+			return req, false
+		}
+		req.lastID = uint(id)
+	}
+	return req, true
+}
+
+// openStream subscribes to the requested prices and, when resuming, loads
+// the ones missed since the last ID. Prices that arrive meanwhile wait in
+// the subscription, so callers skip those up to the last missed one. If
+// more than cfg.ResumeLimit prices were missed none are returned and
+// reset is true.
+func openStream(cfg utils.StreamConfig, req streamRequest) (sub *utils.PriceSubscription, missed []utils.PriceEvent, reset bool, err error) {
+	sub = Hub.Subscribe(req.tenantID, req.symbols, cfg.Buffer)
+	if req.lastID == 0 {
+		return sub, nil, false, nil
+	}
+
+	db := database.DB.Table("prices").
+		Select("prices.id, instruments.symbol, prices.tenant_id, prices.value, prices.volume, prices.created_at AS time").
+		Joins("JOIN instruments ON instruments.id = prices.instrument_id").
+		Scopes(models.TenantScope(req.tenantID)).
+		Where("prices.id > ?", req.lastID)
+	if symbols := sub.Symbols(); len(symbols) == 0 || symbols[0] != utils.AllSymbols {
+		db = db.Where("instruments.symbol IN ?", symbols)
+	}
+	if err := db.Order("prices.id").Limit(cfg.ResumeLimit + 1).Scan(&missed).Error; err != nil {
+		sub.Close()
+		return nil, nil, false, err
+	}
+	if len(missed) > cfg.ResumeLimit {
+		return sub, nil, true, nil
+	}
+	return sub, missed, false, nil
+}
+
+// StreamPricesSSE streams new prices as Server-Sent Events named "price",
+// with the price ID as event ID. Idle streams get a comment line every
+// cfg.Heartbeat. A "reset" event tells a resuming client that it missed
+// too many prices and should reload them from /data/prices, and an
+// "error" event precedes the end of a stream that fell behind.
+func StreamPricesSSE(cfg utils.StreamConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, ok := parseStreamRequest(c)
+		if !ok {
+			return
+		}
+		sub, missed, reset, err := openStream(cfg, req)
+		if err != nil {
+			utils.Logger.Error("Could not resume price stream", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not open stream"})
+			return
+		}
+		defer sub.Close()
+
+		utils.Logger.Info("Price stream opened",
+			zap.String("transport", "sse"),
+			zap.Strings("symbols", req.symbols),
+			zap.String("user_id", c.GetString("user_id")),
+			zap.String("tenant_id", req.tenantID),
+		)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		write := func(event string, id uint, data interface{}) error {
+			body, _ := json.Marshal(data)
+			var msg strings.Builder
+			if id != 0 {
+				fmt.Fprintf(&msg, "id: %d\n", id)
+			}
+			fmt.Fprintf(&msg, "event: %s\ndata: %s\n\n", event, body)
+			if _, err := c.Writer.WriteString(msg.String()); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		}
+
+		lastID := req.lastID
+		for _, e := range missed {
+			if write("price", e.ID, e) != nil {
+				return
+			}
+			lastID = e.ID
+		}
+		if reset && write("reset", 0, gin.H{"error": "Too many missed prices, reload them from /data/prices"}) != nil {
+			return
+		}
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(cfg.Heartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case e, ok := <-sub.C:
+				if !ok {
+					if sub.Dropped() {
+						utils.Logger.Warn("Dropped slow price stream", zap.String("transport", "sse"))
+						write("error", 0, gin.H{"error": "Stream fell behind, reconnect to resume"})
+					}
+					return
+				}
+				if e.ID <= lastID {
+					continue
+				}
+				if write("price", e.ID, e) != nil {
+					return
+				}
+				lastID = e.ID
+			case <-heartbeat.C:
+				if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			}
+		}
+	}
+}
+
+// streamMessage is a message from a WebSocket client.
+type streamMessage struct {
+	Action  string   `json:"action"`
+	Symbols []string `json:"symbols"`
+}
+
+// priceMessage is a price sent over a WebSocket.
+type priceMessage struct {
+	Type string `json:"type"`
+	utils.PriceEvent
+}
+
+// applyStreamMessage subscribes or unsubscribes as a client asked and
+// returns the reply.
+func applyStreamMessage(sub *utils.PriceSubscription, raw []byte) gin.H {
+	var msg streamMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return gin.H{"type": "error", "error": "Invalid message"}
+	}
+	symbols, err := parseSymbols(msg.Symbols)
+	if err != nil {
+		return gin.H{"type": "error", "error": err.Error()}
+	}
+	switch msg.Action {
+	case "subscribe":
+		sub.Add(symbols...)
+	case "unsubscribe":
+		sub.Remove(symbols...)
+	default:
+		return gin.H{"type": "error", "error": "action must be subscribe or unsubscribe"}
+	}
+	return gin.H{"type": "subscribed", "symbols": sub.Symbols()}
+}
+
+// StreamPricesWS streams new prices over a WebSocket as messages of type
+// "price". Clients change their symbols by sending
+// {"action": "subscribe"|"unsubscribe", "symbols": [...]} and are answered
+// with the resulting "subscribed" list. Connections are pinged every
+// cfg.Heartbeat and closed if no pong follows before the next ping. A
+// stream that falls behind is closed with code 1013 (try again later).
+func StreamPricesWS(cfg utils.StreamConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, ok := parseStreamRequest(c)
+		if !ok {
+			return
+		}
+		sub, missed, reset, err := openStream(cfg, req)
+		if err != nil {
+			utils.Logger.Error("Could not resume price stream", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not open stream"})
+			return
+		}
+		defer sub.Close()
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader has already replied.
+			utils.Logger.Warn("WebSocket upgrade failed", zap.Error(err))
+			return
+		}
+		defer conn.Close()
+
+		utils.Logger.Info("Price stream opened",
+			zap.String("transport", "websocket"),
+			zap.Strings("symbols", req.symbols),
+			zap.String("user_id", c.GetString("user_id")),
+			zap.String("tenant_id", req.tenantID),
+		)
+
+		// The reader owns reads and the loop below owns writes, as the
+		// connection allows one of each at a time.
+		replies := make(chan gin.H)
+		done := make(chan struct{})
+		stop := make(chan struct{})
+		defer close(stop)
+		alive := func() error { return conn.SetReadDeadline(time.Now().Add(2 * cfg.Heartbeat)) }
+		conn.SetReadLimit(4096)
+		alive()
+		conn.SetPongHandler(func(string) error { return alive() })
+		go func() {
+			defer close(done)
+			for {
+				_, raw, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				alive()
+				select {
+				case replies <- applyStreamMessage(sub, raw):
+				case <-stop:
+					return
+				}
+			}
+		}()
+
+		send := func(v interface{}) error {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			return conn.WriteJSON(v)
+		}
+
+		if send(gin.H{"type": "subscribed", "symbols": sub.Symbols()}) != nil {
+			return
+		}
+		lastID := req.lastID
+		for _, e := range missed {
+			if send(priceMessage{"price", e}) != nil {
+				return
+			}
+			lastID = e.ID
+		}
+		if reset && send(gin.H{"type": "reset", "error": "Too many missed prices, reload them from /data/prices"}) != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(cfg.Heartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case reply := <-replies:
+				if send(reply) != nil {
+					return
+				}
+			case e, ok := <-sub.C:
+				if !ok {
+					if sub.Dropped() {
+						utils.Logger.Warn("Dropped slow price stream", zap.String("transport", "websocket"))
+						conn.WriteControl(websocket.CloseMessage,
+							websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "stream fell behind, reconnect to resume"),
+							time.Now().Add(wsWriteWait))
+					}
+					return
+				}
+				if e.ID <= lastID {
+					continue
+				}
+				if send(priceMessage{"price", e}) != nil {
+					return
+				}
+				lastID = e.ID
+			case <-heartbeat.C:
+				if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)) != nil {
+					return
+				}
+			}
+		}
+	}
+}
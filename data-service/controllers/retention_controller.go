@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/retention"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// recentArchives is how many archive files the retention status lists.
+const recentArchives = 20
+
+// GetRetention returns the progress of the retention job and the latest
+// archive files.
+func GetRetention(job *retention.Job) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var archives []models.PriceArchive
+		if err := database.DB.Order("id DESC").Limit(recentArchives).Find(&archives).Error; err != nil {
+			utils.Logger.Error("Could not list archives", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list archives"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": job.Status(), "archives": archives})
+	}
+}
+
+// RunRetention runs the retention job now and returns its report. It is a
+// dry run if ?dry_run is true, or if unset and scheduled runs are dry runs.
+func RunRetention(job *retention.Job) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dryRun := job.DryRun()
+		if v := c.Query("dry_run"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "dry_run must be true or false"})
+				return
+			}
+			dryRun = b
+		}
+
+		utils.Logger.Info("Retention run requested",
+			zap.Bool("dry_run", dryRun),
+			zap.String("admin_id", c.GetString("user_id")),
+		)
+		report, err := job.RunOnce(c.Request.Context(), dryRun)
+		if errors.Is(err, retention.ErrRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Retention job is already running"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Retention run failed", "report": report})
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStatsWindow = 24 * time.Hour
+	maxStatsWindow     = 90 * 24 * time.Hour
+	// statsTTL bounds how long cached stats are served. New prices from
+	// this process invalidate them at once, but the window also slides
+	// and other replicas write prices too.
+	statsTTL = 10 * time.Second
+	// changePercentPlaces is the precision of the percent change.
+	changePercentPlaces = 4
+)
+
+// PriceStats summarizes an instrument's prices over a window. The figures
+// are null when the window holds no price, and VWAP also when no price
+// reported a volume.
+type PriceStats struct {
+	Symbol        string              `json:"symbol"`
+	Window        string              `json:"window"`
+	From          time.Time           `json:"from"`
+	Count         int64               `json:"count"`
+	Min           decimal.NullDecimal `json:"min"`
+	Max           decimal.NullDecimal `json:"max"`
+	Mean          decimal.NullDecimal `json:"mean"`
+	StdDev        decimal.NullDecimal `json:"stddev"`
+	VWAP          decimal.NullDecimal `json:"vwap"`
+	First         decimal.NullDecimal `json:"first"`
+	Last          decimal.NullDecimal `json:"last"`
+	Change        decimal.NullDecimal `json:"change"`
+	ChangePercent decimal.NullDecimal `json:"change_percent"`
+}
+
+type statsKey struct {
+	instrumentID uint
+	tenantID     string
+	window       time.Duration
+}
+
+type cachedStats struct {
+	stats    PriceStats
+	version  uint64
+	computed time.Time
+}
+
+var (
+	statsMu    sync.Mutex
+	statsCache = map[statsKey]cachedStats{}
+)
+
+// priceStats returns the stats of an instrument for a tenant, from the
+// cache while no new price has arrived.
+func priceStats(instrument *models.Instrument, tenantID string, window time.Duration) (PriceStats, error) {
+	key := statsKey{instrument.ID, tenantID, window}
+	version := models.PriceVersion(instrument.ID)
+	now := time.Now()
+
+	statsMu.Lock()
+	cached, ok := statsCache[key]
+	statsMu.Unlock()
+	if ok && cached.version == version && now.Sub(cached.computed) < statsTTL {
+		return cached.stats, nil
+	}
+
+	stats := PriceStats{Symbol: instrument.Symbol, Window: window.String(), From: now.Add(-window)}
+	var row struct {
+		Count  int64
+		Min    decimal.NullDecimal
+		Max    decimal.NullDecimal
+		Mean   decimal.NullDecimal
+		StdDev decimal.NullDecimal
+		VWAP   decimal.NullDecimal
+		First  decimal.NullDecimal
+		Last   decimal.NullDecimal
+	}
+	err := database.DB.Model(&models.Price{}).
+		Select(`COUNT(*) AS count, MIN(value) AS min, MAX(value) AS max, AVG(value) AS mean,
+	COALESCE(STDDEV_SAMP(value), 0) AS std_dev,
+	SUM(value * volume) / NULLIF(SUM(volume), 0) AS vwap,
+	(array_agg(value ORDER BY created_at, id))[1] AS first,
+	(array_agg(value ORDER BY created_at DESC, id DESC))[1] AS last`).
+		Scopes(models.TenantScope(tenantID)).
+		Where("instrument_id = ? AND created_at >= ?", instrument.ID, stats.From).
+		Scan(&row).Error
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Count = row.Count
+	if row.Count > 0 {
+		stats.Min, stats.Max, stats.Mean, stats.StdDev = row.Min, row.Max, row.Mean, row.StdDev
+		stats.VWAP, stats.First, stats.Last = row.VWAP, row.First, row.Last
+		change := row.Last.Decimal.Sub(row.First.Decimal)
+		stats.Change = decimal.NewNullDecimal(change)
+		stats.ChangePercent = decimal.NewNullDecimal(change.Mul(decimal.NewFromInt(100)).DivRound(row.First.Decimal, changePercentPlaces))
+	}
+
+	statsMu.Lock()
+	// Drop entries that can no longer be served, so that the cache stays
+	// as small as the set of windows in use.
+	for k, v := range statsCache {
+		if now.Sub(v.computed) >= statsTTL {
+			delete(statsCache, k)
+		}
+	}
+	statsCache[key] = cachedStats{stats: stats, version: version, computed: now}
+	statsMu.Unlock()
+	return stats, nil
+}
+
+// GetStats returns min, max, mean, standard deviation, VWAP, first and last
+// price, change and percent change of ?symbol over the trailing ?window
+// (a duration such as 15m or 24h, the default).
+func GetStats(c *gin.Context) {
+	if c.Query("symbol") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "symbol is required"})
+		return
+	}
+	window := defaultStatsWindow
+	if v := c.Query("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxStatsWindow {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a duration up to " + maxStatsWindow.String()})
+			return
+		}
+		window = d
+	}
+
+	instrument, ok := findInstrument(c, c.Query("symbol"))
+	if !ok {
+		return
+	}
+
+	stats, err := priceStats(instrument, c.GetString("tenant_id"), window)
+	if err != nil {
+		utils.Logger.Error("Could not compute stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute stats"})
+		return
+	}
+
+	utils.Logger.Info("Stats requested",
+		zap.String("symbol", instrument.Symbol),
+		zap.Duration("window", window),
+		zap.String("user_id", c.GetString("user_id")),
+		zap.String("tenant_id", c.GetString("tenant_id")),
+	)
+	c.JSON(http.StatusOK, stats)
+}
@@ -25,7 +25,32 @@ func ConnectDatabase() {
 		utils.Logger.Error("Failed to connect to database", zap.Error(err))
 	}
 
-	db.AutoMigrate(&models.Price{})
+	if err := Migrate(db); err != nil {
+		utils.Logger.Panic("Failed to migrate database", zap.Error(err))
+	}
 	DB = db
 	utils.Logger.Info("Data Database Migrated")
 }
+
+// Migrate creates or updates the schema.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.Instrument{}); err != nil {
+		return err
+	}
+
+	// Prices that predate instruments belong to the default instrument.
+	err := db.Exec(`INSERT INTO instruments (id, symbol, name, tick_size, currency, status, created_at, updated_at)
+VALUES (?, ?, 'Default', 0.01, 'USD', 'active', NOW(), NOW()) ON CONFLICT DO NOTHING`,
+		models.DefaultInstrumentID, models.DefaultInstrumentSymbol).Error
+	if err != nil {
+		return err
+	}
+	if err := db.Exec("SELECT setval(pg_get_serial_sequence('instruments', 'id'), (SELECT MAX(id) FROM instruments))").Error; err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&models.Price{}, &models.OutboxEvent{}, &models.PriceArchive{}); err != nil {
+		return err
+	}
+	return models.MigrateCandles(db)
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Relay drains the outbox: it publishes the events in ID order and marks
+// them published. Several replicas may run a relay; each event is claimed
+// by one of them.
+type Relay struct {
+	db        *gorm.DB
+	publisher Publisher
+	cfg       utils.OutboxConfig
+}
+
+func NewRelay(db *gorm.DB, publisher Publisher, cfg utils.OutboxConfig) *Relay {
+	return &Relay{db: db, publisher: publisher, cfg: cfg}
+}
+
+// Run drains the outbox every cfg.Interval until ctx is done, and deletes
+// published events once they are older than cfg.Retention.
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	lastPrune := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// A full batch means more are waiting.
+		for {
+			n, err := r.Drain(ctx)
+			if err != nil {
+				utils.Logger.Error("Outbox drain failed", zap.Error(err))
+			}
+			if err != nil || n < r.cfg.Batch {
+				break
+			}
+		}
+
+		if time.Since(lastPrune) >= time.Minute {
+			if _, err := r.Prune(ctx); err != nil {
+				utils.Logger.Error("Outbox prune failed", zap.Error(err))
+			}
+			lastPrune = time.Now()
+		}
+	}
+}
+
+// Drain publishes one batch of unpublished events and returns how many
+// were published. If publishing fails, the events before the failing one
+// are still marked and the rest are retried on the next drain.
+func (r *Relay) Drain(ctx context.Context) (int, error) {
+	published := 0
+	var publishErr error
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var pending []models.OutboxEvent
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("published_at IS NULL").Order("id").Limit(r.cfg.Batch).
+			Find(&pending).Error
+		if err != nil {
+			return err
+		}
+
+		var ids []uint64
+		for _, e := range pending {
+			publishErr = r.publisher.Publish(ctx, Event{ID: e.ID, Topic: e.Topic, Payload: json.RawMessage(e.Payload)})
+			if publishErr != nil {
+				break
+			}
+			ids = append(ids, e.ID)
+		}
+		if len(ids) > 0 {
+			err := tx.Model(&models.OutboxEvent{}).Where("id IN ?", ids).Update("published_at", time.Now()).Error
+			if err != nil {
+				return err
+			}
+		}
+		published = len(ids)
+		// Commit what was published even if an event failed.
+		return nil
+	})
+	if err != nil {
+		return published, err
+	}
+	return published, publishErr
+}
+
+// Prune deletes the events published longer than cfg.Retention ago.
+func (r *Relay) Prune(ctx context.Context) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Where("published_at < ?", time.Now().Add(-r.cfg.Retention)).
+		Delete(&models.OutboxEvent{})
+	return res.RowsAffected, res.Error
+}
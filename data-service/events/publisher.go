@@ -0,0 +1,70 @@
+// Package events publishes the changes data-service makes, so that other
+// services can react to them without polling.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Event is an outbox event as published. IDs grow with every event, and
+// since delivery is at least once, consumers may see an ID twice.
+type Event struct {
+	ID      uint64          `json:"id"`
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Publisher delivers events to their consumers.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// PostgresPublisher sends events as NOTIFY on a channel of the database,
+// where any service connected to it can LISTEN.
+type PostgresPublisher struct {
+	db      *gorm.DB
+	channel string
+}
+
+func NewPostgresPublisher(db *gorm.DB, channel string) *PostgresPublisher {
+	return &PostgresPublisher{db: db, channel: channel}
+}
+
+// Publish notifies the channel with the event as JSON. Notifications are
+// limited to 8000 bytes, which is plenty for the events of data-service.
+func (p *PostgresPublisher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return p.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", p.channel, string(body)).Error
+}
+
+// MemoryPublisher keeps events in memory, for tests.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+	// Err, if set, is returned by Publish instead of recording the event.
+	Err error
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, e Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Err != nil {
+		return p.Err
+	}
+	p.events = append(p.events, e)
+	return nil
+}
+
+// Events returns the events published so far.
+func (p *MemoryPublisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Event(nil), p.events...)
+}
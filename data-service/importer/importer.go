@@ -0,0 +1,300 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidFile wraps the errors of files that cannot be read at all, as
+// opposed to single invalid rows.
+var ErrInvalidFile = errors.New("invalid price file")
+
+// maxErrors is how many invalid rows a report lists.
+const maxErrors = 100
+
+// eventWindow is how old imported prices may be and still raise price
+// events. Consumers only derive data from recent prices, such as the
+// lowest price of the last 24 hours trade-service checks trades against.
+const eventWindow = 24 * time.Hour
+
+// Options of an import.
+type Options struct {
+	// Format is one of the utils.PriceFormat values.
+	Format string
+	// TenantID owns the imported prices; empty imports shared market data.
+	TenantID string
+}
+
+// RowError is an invalid row. Line is as reported by utils.ReadPriceFile.
+type RowError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// SymbolReport sums up the valid rows of one instrument.
+type SymbolReport struct {
+	Symbol   string    `json:"symbol"`
+	Rows     int       `json:"rows"`
+	Imported int64     `json:"imported"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+}
+
+// Report is the summary of an import. Every row read is imported, already
+// stored, a duplicate of an earlier row or rejected.
+type Report struct {
+	Format   string `json:"format"`
+	TenantID string `json:"tenant_id"`
+	Rows     int    `json:"rows"`
+	Imported int64  `json:"imported"`
+	// Existing rows match a stored price of the same instrument, tenant
+	// and time.
+	Existing int64 `json:"existing"`
+	// Duplicates repeat the instrument and time of an earlier row.
+	Duplicates int `json:"duplicates"`
+	Rejected   int `json:"rejected"`
+	// Errors lists the first rejected rows.
+	Errors  []RowError     `json:"errors"`
+	Symbols []SymbolReport `json:"symbols"`
+	Took    string         `json:"took"`
+}
+
+func (r *Report) reject(line int, err error) {
+	r.Rejected++
+	if len(r.Errors) < maxErrors {
+		r.Errors = append(r.Errors, RowError{Line: line, Error: err.Error()})
+	}
+}
+
+// stagedPrice is a valid row waiting to be copied.
+type stagedPrice struct {
+	instrumentID uint
+	value        decimal.Decimal
+	volume       decimal.Decimal
+	at           time.Time
+}
+
+type dedupKey struct {
+	instrumentID uint
+	at           int64
+}
+
+// Import reads prices from r, validates them, drops the rows already
+// seen in the file or stored, loads the rest with COPY and rebuilds the
+// candles of the imported range. Values are rounded to the tick size like
+// live prices. Imported prices within the last eventWindow raise
+// price.created events like live ones.
+func Import(ctx context.Context, db *gorm.DB, r io.Reader, opts Options) (*Report, error) {
+	started := time.Now()
+	report := &Report{Format: opts.Format, TenantID: opts.TenantID, Errors: []RowError{}, Symbols: []SymbolReport{}}
+
+	var instruments []models.Instrument
+	// Prices of a tenant's instruments can only be imported for the tenant.
+	if err := db.WithContext(ctx).Scopes(models.TenantScope(opts.TenantID)).Find(&instruments).Error; err != nil {
+		return report, err
+	}
+	bySymbol := make(map[string]*models.Instrument, len(instruments))
+	for i := range instruments {
+		bySymbol[instruments[i].Symbol] = &instruments[i]
+	}
+
+	// Candles of archived prices are no longer rebuilt, so prices are only
+	// accepted after the last day that holds archived ones.
+	until, err := models.ArchivedUntil(db.WithContext(ctx))
+	if err != nil {
+		return report, err
+	}
+	var earliest time.Time
+	if !until.IsZero() {
+		earliest = until.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	}
+
+	var staged []stagedPrice
+	seen := map[dedupKey]bool{}
+	symbols := map[uint]*SymbolReport{}
+	err = utils.ReadPriceFile(r, opts.Format, func(line int, tick utils.PriceTick, err error) error {
+		report.Rows++
+		if err != nil {
+			report.reject(line, err)
+			return nil
+		}
+		instrument, ok := bySymbol[strings.ToUpper(strings.TrimSpace(tick.Symbol))]
+		switch {
+		case !ok:
+			report.reject(line, fmt.Errorf("unknown instrument %q", tick.Symbol))
+			return nil
+		case tick.Time.IsZero():
+			report.reject(line, errors.New("missing time"))
+			return nil
+		case tick.Time.Before(earliest):
+			report.reject(line, fmt.Errorf("time before %s, where prices are archived", earliest.Format(time.RFC3339)))
+			return nil
+		}
+
+		// Postgres keeps microseconds.
+		at := tick.Time.UTC().Truncate(time.Microsecond)
+		key := dedupKey{instrument.ID, at.UnixMicro()}
+		if seen[key] {
+			report.Duplicates++
+			return nil
+		}
+		seen[key] = true
+
+		value := instrument.RoundToTick(tick.Value)
+		if !value.IsPositive() {
+			value = instrument.TickSize
+		}
+		staged = append(staged, stagedPrice{instrumentID: instrument.ID, value: value, volume: tick.Volume, at: at})
+
+		s := symbols[instrument.ID]
+		if s == nil {
+			s = &SymbolReport{Symbol: instrument.Symbol, From: at, To: at}
+			symbols[instrument.ID] = s
+		}
+		s.Rows++
+		if at.Before(s.From) {
+			s.From = at
+		}
+		if at.After(s.To) {
+			s.To = at
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	if len(staged) > 0 {
+		imported, err := copyPrices(ctx, db, opts.TenantID, staged)
+		if err != nil {
+			return report, err
+		}
+		for id, s := range symbols {
+			s.Imported = imported[id]
+			report.Imported += s.Imported
+			// The range is widened to whole candles; the bound is exclusive.
+			if err := models.RebuildCandles(db.WithContext(ctx), id, s.From, s.To.Add(time.Microsecond)); err != nil {
+				return report, fmt.Errorf("prices imported but candles not rebuilt: %w", err)
+			}
+			if s.Imported > 0 {
+				models.PricesChanged(id)
+			}
+		}
+		report.Existing = int64(len(staged)) - report.Imported
+	}
+
+	for _, s := range symbols {
+		report.Symbols = append(report.Symbols, *s)
+	}
+	sort.Slice(report.Symbols, func(i, j int) bool { return report.Symbols[i].Symbol < report.Symbols[j].Symbol })
+	report.Took = time.Since(started).String()
+
+	utils.Logger.Info("Prices imported",
+		zap.String("format", report.Format),
+		zap.String("tenant_id", report.TenantID),
+		zap.Int("rows", report.Rows),
+		zap.Int64("imported", report.Imported),
+		zap.Int64("existing", report.Existing),
+		zap.Int("duplicates", report.Duplicates),
+		zap.Int("rejected", report.Rejected),
+		zap.String("took", report.Took),
+	)
+	return report, nil
+}
+
+// copyPrices COPYs the prices into a staging table and moves the ones
+// that are not stored yet into prices, in one transaction, adding the
+// price.created events of those within eventWindow to the outbox. It
+// returns how many were inserted per instrument.
+func copyPrices(ctx context.Context, db *gorm.DB, tenantID string, staged []stagedPrice) (map[uint]int64, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	imported := map[uint]int64{}
+	err = conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("COPY needs a pgx connection")
+		}
+		return pgx.BeginFunc(ctx, c.Conn(), func(tx pgx.Tx) error {
+			_, err := tx.Exec(ctx, `CREATE TEMP TABLE price_import (
+	instrument_id bigint NOT NULL,
+	tenant_id text NOT NULL,
+	value numeric NOT NULL,
+	volume numeric NOT NULL,
+	created_at timestamptz NOT NULL
+) ON COMMIT DROP`)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.CopyFrom(ctx, pgx.Identifier{"price_import"},
+				[]string{"instrument_id", "tenant_id", "value", "volume", "created_at"},
+				pgx.CopyFromSlice(len(staged), func(i int) ([]any, error) {
+					p := staged[i]
+					return []any{int64(p.instrumentID), tenantID, numeric(p.value), numeric(p.volume), p.at}, nil
+				}))
+			if err != nil {
+				return err
+			}
+
+			rows, err := tx.Query(ctx, `WITH inserted AS (
+	INSERT INTO prices (instrument_id, tenant_id, value, volume, created_at)
+	SELECT i.instrument_id, i.tenant_id, i.value, i.volume, i.created_at FROM price_import i
+	WHERE NOT EXISTS (
+		SELECT 1 FROM prices p
+		WHERE p.instrument_id = i.instrument_id AND p.tenant_id = i.tenant_id AND p.created_at = i.created_at
+	)
+	RETURNING id, instrument_id, tenant_id, value, volume, created_at
+), events AS (
+	INSERT INTO outbox_events (topic, payload, created_at)
+	SELECT $1, jsonb_build_object(
+		'id', i.id, 'instrument_id', i.instrument_id, 'symbol', s.symbol, 'tenant_id', i.tenant_id,
+		'value', i.value::text, 'volume', i.volume::text, 'time', i.created_at
+	), NOW()
+	FROM inserted i JOIN instruments s ON s.id = i.instrument_id
+	WHERE i.created_at > $2
+	ORDER BY i.created_at
+)
+SELECT instrument_id, COUNT(*) FROM inserted GROUP BY instrument_id`, models.TopicPriceCreated, time.Now().Add(-eventWindow))
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var id, n int64
+				if err := rows.Scan(&id, &n); err != nil {
+					return err
+				}
+				imported[uint(id)] = n
+			}
+			return rows.Err()
+		})
+	})
+	return imported, err
+}
+
+func numeric(d decimal.Decimal) pgtype.Numeric {
+	return pgtype.Numeric{Int: d.Coefficient(), Exp: d.Exponent(), Valid: true}
+}
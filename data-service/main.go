@@ -1,14 +1,14 @@
 package main
 
 import (
-	"math/rand"
+	"context"
 	"os"
-	"time"
 
 	"github.com/RanggaNehemia/golang-microservices/data-service/controllers"
 	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/events"
 	"github.com/RanggaNehemia/golang-microservices/data-service/middleware"
-	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/retention"
 	"github.com/RanggaNehemia/golang-microservices/data-service/tracing"
 	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
 	"github.com/gin-gonic/gin"
@@ -26,30 +26,58 @@ func main() {
 	defer utils.SyncLogger()
 
 	database.ConnectDatabase()
+	middleware.Init()
 
-	router := gin.Default()
+	router := gin.New()
+	// gin.Default, but without logging stream tokens passed as ?access_token
+	router.Use(gin.LoggerWithFormatter(utils.AccessLogFormatter), gin.Recovery())
 	router.Use(otelgin.Middleware("data-service"))
 
+	cfg := utils.Load()
+	source, err := utils.NewPriceSource(cfg.PriceSource, controllers.ActiveSymbols, controllers.LastPrice)
+	if err != nil {
+		utils.Logger.Fatal("Invalid price source", zap.Error(err))
+	}
 	go func() {
-		ticker := time.NewTicker(time.Minute)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				price := models.Price{
-					Value: float64(rand.Intn(10000)) + rand.Float64(), // Random 0–10000.x
-				}
-				database.DB.Create(&price)
-				utils.Logger.Info("Generated price:", zap.Float64("Price", price.Value))
-			}
+		if err := source.Run(context.Background(), controllers.RecordPrice); err != nil {
+			utils.Logger.Error("Price source stopped", zap.Error(err))
 		}
 	}()
+	relay := events.NewRelay(database.DB, events.NewPostgresPublisher(database.DB, cfg.Outbox.Channel), cfg.Outbox)
+	go relay.Run(context.Background())
+	retentionJob := retention.NewJob(database.DB, cfg.Retention)
+	go retentionJob.Run(context.Background())
+
+	if webhook, ok := source.(*utils.PriceWebhook); ok {
+		router.POST("/ingest/prices", controllers.IngestPrices(webhook))
+	}
 
 	protected := router.Group("/data")
 	protected.Use(middleware.JWTAuthMiddleware())
-	protected.GET("/latest", controllers.GetLatestPrice)
-	protected.GET("/lowest", controllers.GetLowestPrice)
+	protected.GET("/instruments", controllers.ListInstruments)
+	protected.GET("/candles", controllers.GetCandles)
+	protected.GET("/prices", controllers.GetPriceHistory)
+	protected.GET("/stats", controllers.GetStats)
+	protected.GET("/:symbol/latest", controllers.GetLatestPrice)
+	protected.GET("/:symbol/lowest", controllers.GetLowestPrice)
+
+	stream := router.Group("/data")
+	stream.Use(middleware.StreamAuthMiddleware())
+	stream.GET("/stream", controllers.StreamPricesSSE(cfg.Stream))
+	stream.GET("/ws", controllers.StreamPricesWS(cfg.Stream))
+
+	// Admins of every tenant manage the instruments of their tenant
+	instruments := router.Group("/admin/instruments")
+	instruments.Use(middleware.TenantAdminAuthMiddleware())
+	instruments.GET("", controllers.AdminListInstruments)
+	instruments.POST("", controllers.CreateInstrument)
+	instruments.PATCH("/:symbol", controllers.UpdateInstrument)
+
+	admin := router.Group("/admin")
+	admin.Use(middleware.AdminAuthMiddleware())
+	admin.POST("/prices/import", controllers.ImportPrices)
+	admin.GET("/retention", controllers.GetRetention(retentionJob))
+	admin.POST("/retention/run", controllers.RunRetention(retentionJob))
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,966 @@
+// data-service/main_test.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/controllers"
+	"github.com/RanggaNehemia/golang-microservices/data-service/database"
+	"github.com/RanggaNehemia/golang-microservices/data-service/events"
+	"github.com/RanggaNehemia/golang-microservices/data-service/importer"
+	"github.com/RanggaNehemia/golang-microservices/data-service/middleware"
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/retention"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/joho/godotenv"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+const (
+	testSecret  = "data-service-test-secret"
+	opaqueToken = "Zm9vYmFyYmF6cXV4cXV1eA"
+	// rateLimitedToken is refused by the stand-in for auth-service with 429.
+	rateLimitedToken = "cmF0ZWxpbWl0ZWR0b2tlbg"
+)
+
+var router *gin.Engine
+
+var streamConfig = utils.StreamConfig{Heartbeat: time.Second, Buffer: 16, ResumeLimit: 3}
+
+var retentionJob *retention.Job
+
+func TestMain(m *testing.M) {
+	_ = godotenv.Load(".env.test")
+	utils.InitLogger()
+
+	// Stand-in for auth-service: every token is active, opaqueToken
+	// resolves to a user of tenant 2 and test JWTs name the user's role in
+	// a "role" claim.
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "data-service" || secret != "dataservicesecret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		resp := map[string]interface{}{"active": true}
+		token := r.PostFormValue("token")
+		if token == rateLimitedToken {
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{"error": "rate_limited"})
+			return
+		}
+		if token == opaqueToken {
+			resp["sub"] = "42"
+			resp["client_id"] = "trade-service"
+			resp["tenant_id"] = "2"
+		} else if t, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{}); err == nil {
+			resp["role"] = t.Claims.(jwt.MapClaims)["role"]
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer auth.Close()
+
+	os.Setenv("SECRET_KEY", testSecret)
+	os.Setenv("TRADE_SERVICE_CLIENT_ID", "trade-service")
+	os.Setenv("DATA_SERVICE_CLIENT_ID", "data-service")
+	os.Setenv("DATA_SERVICE_CLIENT_SECRET", "dataservicesecret")
+	os.Setenv("WEB_CLIENT_ID", "webclient")
+	os.Setenv("AUTH_URL", auth.URL)
+	middleware.Init()
+
+	database.InitTestDB()
+	defer database.CloseTestDB()
+
+	gin.SetMode(gin.TestMode)
+	router = gin.New()
+	protected := router.Group("/data")
+	protected.Use(middleware.JWTAuthMiddleware())
+	protected.GET("/instruments", controllers.ListInstruments)
+	protected.GET("/candles", controllers.GetCandles)
+	protected.GET("/prices", controllers.GetPriceHistory)
+	protected.GET("/stats", controllers.GetStats)
+	protected.GET("/:symbol/latest", controllers.GetLatestPrice)
+	protected.GET("/:symbol/lowest", controllers.GetLowestPrice)
+	stream := router.Group("/data")
+	stream.Use(middleware.StreamAuthMiddleware())
+	stream.GET("/stream", controllers.StreamPricesSSE(streamConfig))
+	stream.GET("/ws", controllers.StreamPricesWS(streamConfig))
+	// Admins of every tenant manage the instruments of their tenant
+	instruments := router.Group("/admin/instruments")
+	instruments.Use(middleware.TenantAdminAuthMiddleware())
+	instruments.GET("", controllers.AdminListInstruments)
+	instruments.POST("", controllers.CreateInstrument)
+	instruments.PATCH("/:symbol", controllers.UpdateInstrument)
+
+	admin := router.Group("/admin")
+	admin.Use(middleware.AdminAuthMiddleware())
+	admin.POST("/prices/import", controllers.ImportPrices)
+
+	// Raw prices are kept for a day and 1m candles for a day and a half.
+	archiveDir, _ := os.MkdirTemp("", "archive")
+	retentionJob = retention.NewJob(database.DB, utils.RetentionConfig{
+		Raw:        24 * time.Hour,
+		Candles:    map[string]time.Duration{"1m": 36 * time.Hour},
+		ArchiveDir: archiveDir,
+		Batch:      2,
+	})
+	admin.GET("/retention", controllers.GetRetention(retentionJob))
+	admin.POST("/retention/run", controllers.RunRetention(retentionJob))
+
+	code := m.Run()
+	os.RemoveAll(archiveDir)
+	os.Exit(code)
+}
+
+// num and floats convert test values to and from decimals.
+func num(v float64) decimal.Decimal {
+	return decimal.NewFromFloat(v)
+}
+
+func floats(ds ...decimal.Decimal) []float64 {
+	fs := make([]float64, len(ds))
+	for i, d := range ds {
+		fs[i] = d.InexactFloat64()
+	}
+	return fs
+}
+
+func tokenFor(t *testing.T, tenantID string) string {
+	claims := jwt.MapClaims{
+		"sub": "42",
+		"aud": "trade-service",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"act": map[string]interface{}{"sub": "trade-service"},
+	}
+	if tenantID != "" {
+		claims["tenant_id"] = tenantID
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(testSecret))
+	assert.NoError(t, err)
+	return token
+}
+
+func getPrice(t *testing.T, path, token string) models.Price {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var price models.Price
+	json.Unmarshal(w.Body.Bytes(), &price)
+	return price
+}
+
+func TestPrices_TenantIsolation(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	now := time.Now()
+	database.DB.Create(&models.Price{Value: num(100), CreatedAt: now.Add(-3 * time.Minute)})
+	database.DB.Create(&models.Price{TenantID: "1", Value: num(10), CreatedAt: now.Add(-2 * time.Minute)})
+	database.DB.Create(&models.Price{TenantID: "2", Value: num(20), CreatedAt: now.Add(-time.Minute)})
+
+	// Each tenant sees shared prices and its own, never the other tenant's
+	assert.Equal(t, 10.0, getPrice(t, "/data/DEFAULT/lowest", tokenFor(t, "1")).Value.InexactFloat64())
+	assert.Equal(t, 10.0, getPrice(t, "/data/DEFAULT/latest", tokenFor(t, "1")).Value.InexactFloat64())
+	assert.Equal(t, 20.0, getPrice(t, "/data/DEFAULT/lowest", tokenFor(t, "2")).Value.InexactFloat64())
+	assert.Equal(t, 20.0, getPrice(t, "/data/DEFAULT/latest", tokenFor(t, "2")).Value.InexactFloat64())
+
+	// Tokens without a tenant only see shared prices
+	assert.Equal(t, 100.0, getPrice(t, "/data/DEFAULT/lowest", tokenFor(t, "")).Value.InexactFloat64())
+	assert.Equal(t, 100.0, getPrice(t, "/data/DEFAULT/latest", tokenFor(t, "")).Value.InexactFloat64())
+
+	// Opaque tokens are resolved by introspection
+	assert.Equal(t, 20.0, getPrice(t, "/data/DEFAULT/lowest", opaqueToken).Value.InexactFloat64())
+}
+
+func TestAuth_IntrospectionUnavailable(t *testing.T) {
+	// A refused introspection is not a revoked token
+	req := httptest.NewRequest(http.MethodGet, "/data/DEFAULT/latest", nil)
+	req.Header.Set("Authorization", "Bearer "+rateLimitedToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "Introspection unavailable")
+}
+
+func TestPrices_DecimalValues(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	record := func(value string) {
+		tick := utils.PriceTick{Symbol: "DEFAULT", Value: decimal.RequireFromString(value), Time: time.Now()}
+		assert.NoError(t, controllers.RecordPrice(tick))
+	}
+
+	// Values are rounded to the tick size of 0.01 and kept exactly
+	record("0.1")
+	record("0.2")
+	record("100.005")
+
+	req := httptest.NewRequest(http.MethodGet, "/data/DEFAULT/latest", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenFor(t, "1"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), `"Value":"100.01"`)
+
+	var sum decimal.Decimal
+	database.DB.Model(&models.Price{}).Select("SUM(value)").Where("value < 1").Scan(&sum)
+	assert.Equal(t, "0.3", sum.String())
+}
+
+func adminToken(t *testing.T, role, tenantID string) string {
+	claims := jwt.MapClaims{
+		"sub":       "1",
+		"aud":       "webclient",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+		"tenant_id": tenantID,
+		"role":      role,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(testSecret))
+	assert.NoError(t, err)
+	return token
+}
+
+func TestInstruments_AdminAndPerSymbolPrices(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	database.DB.Exec("DELETE FROM instruments WHERE id <> ?", models.DefaultInstrumentID)
+
+	call := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(method, path, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	admin := adminToken(t, "admin", "1")
+	btc := map[string]interface{}{"symbol": "btc-usd", "name": "Bitcoin", "tick_size": 0.5, "currency": "usd"}
+
+	// Only admins with a web client token manage instruments
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/admin/instruments", adminToken(t, "user", "1"), btc).Code)
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/admin/instruments", tokenFor(t, "1"), btc).Code)
+
+	w := call(http.MethodPost, "/admin/instruments", admin, btc)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created models.Instrument
+	json.Unmarshal(w.Body.Bytes(), &created)
+	assert.Equal(t, "BTC-USD", created.Symbol)
+	assert.Equal(t, "USD", created.Currency)
+	assert.Equal(t, models.InstrumentStatusActive, created.Status)
+
+	assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/admin/instruments", admin, btc).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/instruments", admin,
+		map[string]interface{}{"symbol": "BAD/SYM", "tick_size": 1, "currency": "USD"}).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/instruments", admin,
+		map[string]interface{}{"symbol": "ETH-USD", "tick_size": 0, "currency": "USD"}).Code)
+
+	// Prices are kept apart per instrument
+	now := time.Now()
+	database.DB.Create(&models.Price{Value: num(100), CreatedAt: now.Add(-2 * time.Minute)})
+	database.DB.Create(&models.Price{InstrumentID: created.ID, Value: num(30000), CreatedAt: now.Add(-time.Minute)})
+	assert.Equal(t, 30000.0, getPrice(t, "/data/btc-usd/latest", tokenFor(t, "1")).Value.InexactFloat64())
+	assert.Equal(t, 100.0, getPrice(t, "/data/DEFAULT/latest", tokenFor(t, "1")).Value.InexactFloat64())
+
+	req := httptest.NewRequest(http.MethodGet, "/data/ETH-USD/latest", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenFor(t, "1"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Delisted instruments keep their history but leave the public list
+	w = call(http.MethodPatch, "/admin/instruments/BTC-USD", admin, map[string]interface{}{"status": "delisted"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPatch, "/admin/instruments/BTC-USD", admin,
+		map[string]interface{}{"status": "gone"}).Code)
+	assert.Equal(t, 30000.0, getPrice(t, "/data/BTC-USD/latest", tokenFor(t, "1")).Value.InexactFloat64())
+
+	w = call(http.MethodGet, "/data/instruments", tokenFor(t, "1"), nil)
+	var listed struct{ Instruments []models.Instrument }
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	assert.Len(t, listed.Instruments, 1)
+	assert.Equal(t, models.DefaultInstrumentSymbol, listed.Instruments[0].Symbol)
+
+	w = call(http.MethodGet, "/admin/instruments", admin, nil)
+	json.Unmarshal(w.Body.Bytes(), &listed)
+	assert.Len(t, listed.Instruments, 2)
+}
+
+func TestInstruments_TenantIsolation(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	database.DB.Exec("DELETE FROM instruments WHERE id <> ?", models.DefaultInstrumentID)
+
+	call := func(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+		var r io.Reader
+		if body != nil {
+			b, _ := json.Marshal(body)
+			r = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, path, r)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	symbols := func(w *httptest.ResponseRecorder) []string {
+		var listed struct{ Instruments []models.Instrument }
+		json.Unmarshal(w.Body.Bytes(), &listed)
+		var symbols []string
+		for _, i := range listed.Instruments {
+			symbols = append(symbols, i.Symbol)
+		}
+		return symbols
+	}
+	platform, admin2, admin3 := adminToken(t, "admin", "1"), adminToken(t, "admin", "2"), adminToken(t, "admin", "3")
+	instrument := func(symbol string) map[string]interface{} {
+		return map[string]interface{}{"symbol": symbol, "tick_size": "0.01", "currency": "USD"}
+	}
+
+	// Tenant admins add instruments to their own tenant only
+	w := call(http.MethodPost, "/admin/instruments", admin2, instrument("ACME"))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var acme models.Instrument
+	json.Unmarshal(w.Body.Bytes(), &acme)
+	assert.Equal(t, "2", acme.TenantID)
+	other := instrument("OTHER")
+	other["tenant_id"] = "3"
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/admin/instruments", admin2, other).Code)
+
+	// Platform admins add shared instruments and those of any tenant
+	w = call(http.MethodPost, "/admin/instruments", platform, other)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusCreated, call(http.MethodPost, "/admin/instruments", platform, instrument("ETH-USD")).Code)
+
+	// Symbols stay unique, but other tenants cannot change or see ACME
+	assert.Equal(t, http.StatusConflict, call(http.MethodPost, "/admin/instruments", admin3, instrument("ACME")).Code)
+	halt := map[string]interface{}{"status": "halted"}
+	assert.Equal(t, http.StatusNotFound, call(http.MethodPatch, "/admin/instruments/ACME", admin3, halt).Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodPatch, "/admin/instruments/ETH-USD", admin2, halt).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPatch, "/admin/instruments/ACME", admin2,
+		map[string]interface{}{"tenant_id": "3"}).Code)
+	assert.Equal(t, http.StatusOK, call(http.MethodPatch, "/admin/instruments/ACME", admin2,
+		map[string]interface{}{"name": "Acme Corp"}).Code)
+
+	assert.Equal(t, []string{"ACME", "DEFAULT", "ETH-USD"}, symbols(call(http.MethodGet, "/admin/instruments", admin2, nil)))
+	assert.Equal(t, []string{"DEFAULT", "ETH-USD", "OTHER"}, symbols(call(http.MethodGet, "/admin/instruments", admin3, nil)))
+	assert.Equal(t, []string{"ACME", "DEFAULT", "ETH-USD", "OTHER"}, symbols(call(http.MethodGet, "/admin/instruments", platform, nil)))
+	assert.Equal(t, []string{"ACME", "DEFAULT", "ETH-USD"}, symbols(call(http.MethodGet, "/data/instruments", tokenFor(t, "2"), nil)))
+	assert.Equal(t, []string{"DEFAULT", "ETH-USD"}, symbols(call(http.MethodGet, "/data/instruments", tokenFor(t, "1"), nil)))
+	assert.Equal(t, []string{"DEFAULT", "ETH-USD"}, symbols(call(http.MethodGet, "/data/instruments", tokenFor(t, ""), nil)))
+
+	// Prices of ACME belong to tenant 2 and are neither served nor streamed
+	// to the others
+	sub3 := controllers.Hub.Subscribe("3", []string{utils.AllSymbols}, 4)
+	defer sub3.Close()
+	sub2 := controllers.Hub.Subscribe("2", []string{utils.AllSymbols}, 4)
+	defer sub2.Close()
+	assert.NoError(t, controllers.RecordPrice(utils.PriceTick{Symbol: "ACME", Value: num(12.5), Time: time.Now()}))
+	var price models.Price
+	database.DB.Where("instrument_id = ?", acme.ID).First(&price)
+	assert.Equal(t, "2", price.TenantID)
+	select {
+	case e := <-sub2.C:
+		assert.Equal(t, "ACME", e.Symbol)
+	case <-time.After(time.Second):
+		t.Error("tenant 2 did not get the ACME price")
+	}
+	select {
+	case e := <-sub3.C:
+		t.Errorf("tenant 3 got a price of %s", e.Symbol)
+	default:
+	}
+
+	assert.Equal(t, 12.5, getPrice(t, "/data/ACME/latest", tokenFor(t, "2")).Value.InexactFloat64())
+	for _, path := range []string{"/data/ACME/latest", "/data/ACME/lowest", "/data/prices?symbol=ACME", "/data/candles?symbol=ACME", "/data/stats?symbol=ACME"} {
+		assert.Equal(t, http.StatusNotFound, call(http.MethodGet, path, tokenFor(t, "3"), nil).Code, path)
+	}
+
+	// ACME prices can only be imported for tenant 2
+	csv := "symbol,value,volume,time\nACME,13,1,2024-05-01T10:00:00Z\n"
+	var report importer.Report
+	w = importFile(t, "acme.csv", csv, "", platform)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, 1, report.Rejected)
+	w = importFile(t, "acme.csv", csv, "?tenant_id=3", platform)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, 1, report.Rejected)
+	w = importFile(t, "acme.csv", csv, "?tenant_id=2", platform)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, int64(1), report.Imported)
+}
+
+func TestCandles_AggregationAndPagination(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	for _, interval := range models.CandleIntervals {
+		database.DB.Exec("DELETE FROM " + models.CandleTable(interval))
+	}
+
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	// Inserted out of order to show that open and close follow the price
+	// times, not the insertion order.
+	for _, p := range []models.Price{
+		{Value: num(12), Volume: num(1), CreatedAt: base.Add(50 * time.Second)},
+		{Value: num(10), Volume: num(2), CreatedAt: base.Add(10 * time.Second)},
+		{Value: num(15), Volume: num(1), CreatedAt: base.Add(30 * time.Second)},
+		{Value: num(9), Volume: num(3), CreatedAt: base.Add(70 * time.Second)},
+		{Value: num(20), Volume: num(1), CreatedAt: base.Add(6 * time.Minute)},
+		{TenantID: "2", Value: num(30), CreatedAt: base.Add(55 * time.Second)},
+	} {
+		database.DB.Create(&p)
+	}
+
+	type page struct {
+		Candles  []models.Candle
+		NextFrom string `json:"next_from"`
+	}
+	get := func(query, token string) page {
+		req := httptest.NewRequest(http.MethodGet, "/data/candles?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var p page
+		json.Unmarshal(w.Body.Bytes(), &p)
+		return p
+	}
+	window := "&from=2024-03-01T10:00:00Z&to=2024-03-01T11:00:00Z"
+
+	p := get("symbol=default&interval=1m"+window, tokenFor(t, "1"))
+	if assert.Len(t, p.Candles, 3) {
+		first := p.Candles[0]
+		assert.True(t, base.Equal(first.BucketStart))
+		assert.Equal(t, []float64{10, 15, 10, 12, 4}, floats(first.Open, first.High, first.Low, first.Close, first.Volume))
+		assert.Equal(t, int64(3), first.Ticks)
+	}
+	assert.Empty(t, p.NextFrom)
+
+	// Tenant prices are merged into the shared candle of the same bucket
+	p = get("symbol=DEFAULT&interval=1m"+window, tokenFor(t, "2"))
+	assert.Equal(t, []float64{10, 30, 10, 30}, floats(p.Candles[0].Open, p.Candles[0].High, p.Candles[0].Low, p.Candles[0].Close))
+
+	p = get("symbol=DEFAULT&interval=5m"+window, tokenFor(t, "1"))
+	if assert.Len(t, p.Candles, 2) {
+		assert.Equal(t, []float64{10, 15, 9, 9, 7}, floats(p.Candles[0].Open, p.Candles[0].High, p.Candles[0].Low, p.Candles[0].Close, p.Candles[0].Volume))
+	}
+	p = get("symbol=DEFAULT&interval=1d"+window, tokenFor(t, "1"))
+	assert.Len(t, p.Candles, 1)
+
+	// Pages continue at next_from
+	p = get("symbol=DEFAULT&interval=1m&limit=2"+window, tokenFor(t, "1"))
+	assert.Len(t, p.Candles, 2)
+	assert.Equal(t, "2024-03-01T10:02:00Z", p.NextFrom)
+	p = get("symbol=DEFAULT&interval=1m&limit=2&to=2024-03-01T11:00:00Z&from="+p.NextFrom, tokenFor(t, "1"))
+	assert.Len(t, p.Candles, 1)
+	assert.Equal(t, "20", p.Candles[0].Close.String())
+
+	// Rebuilding from the prices gives the same candles
+	before := get("symbol=DEFAULT&interval=5m"+window, tokenFor(t, "2"))
+	assert.NoError(t, models.RebuildCandles(database.DB, models.DefaultInstrumentID, base, base.Add(time.Hour)))
+	assert.Equal(t, before, get("symbol=DEFAULT&interval=5m"+window, tokenFor(t, "2")))
+
+	req := httptest.NewRequest(http.MethodGet, "/data/candles?symbol=DEFAULT&interval=2m", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenFor(t, "1"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPriceHistory_PaginationAndExports(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	base := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		database.DB.Create(&models.Price{Value: decimal.NewFromInt(int64(100 + i)), CreatedAt: base.Add(time.Duration(i) * time.Minute)})
+	}
+	database.DB.Create(&models.Price{TenantID: "2", Value: num(999), CreatedAt: base.Add(90 * time.Second)})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/data/prices?symbol=DEFAULT&"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+tokenFor(t, "1"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type page struct {
+		Prices []struct {
+			Time  time.Time
+			Value decimal.Decimal
+		}
+		NextCursor string `json:"next_cursor"`
+	}
+	values := func(query string) ([]float64, string) {
+		w := get(query)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var p page
+		json.Unmarshal(w.Body.Bytes(), &p)
+		var vs []float64
+		for _, price := range p.Prices {
+			vs = append(vs, price.Value.InexactFloat64())
+		}
+		return vs, p.NextCursor
+	}
+
+	// Cursor pages walk the range without gaps or repeats, in either order
+	vs, cursor := values("limit=2")
+	assert.Equal(t, []float64{100, 101}, vs)
+	vs, cursor = values("limit=2&cursor=" + cursor)
+	assert.Equal(t, []float64{102, 103}, vs)
+	vs, cursor = values("limit=2&cursor=" + cursor)
+	assert.Equal(t, []float64{104}, vs)
+	assert.Empty(t, cursor)
+
+	vs, cursor = values("limit=3&order=desc")
+	assert.Equal(t, []float64{104, 103, 102}, vs)
+	vs, _ = values("limit=3&order=desc&cursor=" + cursor)
+	assert.Equal(t, []float64{101, 100}, vs)
+
+	vs, _ = values("from=2024-04-01T00:01:00Z&to=2024-04-01T00:03:00Z")
+	assert.Equal(t, []float64{101, 102}, vs)
+
+	// Exports stream the whole range
+	w := get("format=csv&from=2024-04-01T00:03:00Z")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, "id,time,value,volume", lines[0])
+	assert.Len(t, lines, 3)
+	assert.Contains(t, lines[1], ",2024-04-01T00:03:00Z,103,0")
+
+	w = get("format=ndjson&order=desc")
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	lines = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 5)
+	assert.Contains(t, lines[0], `"value":"104"`)
+
+	assert.Equal(t, http.StatusBadRequest, get("cursor=bogus!").Code)
+	assert.Equal(t, http.StatusBadRequest, get("limit=5000").Code)
+	assert.Equal(t, http.StatusBadRequest, get("order=sideways").Code)
+	assert.Equal(t, http.StatusBadRequest, get("format=xml").Code)
+}
+
+func TestStats_WindowAndCache(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	now := time.Now()
+	for _, p := range []models.Price{
+		{Value: num(50), Volume: num(1), CreatedAt: now.Add(-3 * time.Hour)},
+		{Value: num(100), Volume: num(1), CreatedAt: now.Add(-50 * time.Minute)},
+		{Value: num(110), Volume: num(3), CreatedAt: now.Add(-20 * time.Minute)},
+		{Value: num(90), Volume: num(0), CreatedAt: now.Add(-10 * time.Minute)},
+		{TenantID: "2", Value: num(130), Volume: num(1), CreatedAt: now.Add(-5 * time.Minute)},
+	} {
+		database.DB.Create(&p)
+	}
+
+	get := func(query, token string) (int, controllers.PriceStats) {
+		req := httptest.NewRequest(http.MethodGet, "/data/stats?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var stats controllers.PriceStats
+		json.Unmarshal(w.Body.Bytes(), &stats)
+		return w.Code, stats
+	}
+
+	code, stats := get("symbol=default&window=1h", tokenFor(t, "1"))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(3), stats.Count)
+	assert.Equal(t, []float64{90, 110, 100, 10, 107.5, 100, 90, -10, -10},
+		floats(stats.Min.Decimal, stats.Max.Decimal, stats.Mean.Decimal, stats.StdDev.Decimal, stats.VWAP.Decimal, stats.First.Decimal, stats.Last.Decimal, stats.Change.Decimal, stats.ChangePercent.Decimal))
+
+	// Tenant prices count for their tenant only
+	_, stats = get("symbol=DEFAULT&window=1h", tokenFor(t, "2"))
+	assert.Equal(t, int64(4), stats.Count)
+	assert.Equal(t, "130", stats.Last.Decimal.String())
+
+	// A new price invalidates the cached stats at once
+	database.DB.Create(&models.Price{Value: num(120), CreatedAt: now.Add(-time.Minute)})
+	_, stats = get("symbol=DEFAULT&window=1h", tokenFor(t, "1"))
+	assert.Equal(t, int64(4), stats.Count)
+	assert.Equal(t, "120", stats.Last.Decimal.String())
+
+	_, stats = get("symbol=DEFAULT", tokenFor(t, "1"))
+	assert.Equal(t, int64(5), stats.Count)
+	assert.Equal(t, "24h0m0s", stats.Window)
+
+	// Empty windows have a count but no figures
+	database.DB.Exec("DELETE FROM prices")
+	database.DB.Create(&models.Price{Value: num(1), CreatedAt: now.Add(-2 * time.Hour)})
+	code, stats = get("symbol=DEFAULT&window=1m", tokenFor(t, "1"))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Zero(t, stats.Count)
+	assert.False(t, stats.Min.Valid)
+
+	code, _ = get("symbol=DEFAULT&window=forever", tokenFor(t, "1"))
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("window=1h", tokenFor(t, "1"))
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("symbol=NOPE", tokenFor(t, "1"))
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestStream_SSEAndWebSocket(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	record := func(value int64) uint {
+		assert.NoError(t, controllers.RecordPrice(utils.PriceTick{Symbol: "default", Value: decimal.NewFromInt(value), Time: time.Now()}))
+		var price models.Price
+		database.DB.Order("id DESC").First(&price)
+		return price.ID
+	}
+	first := record(1)
+	second := record(2)
+
+	// SSE resumes after Last-Event-ID, then streams new prices
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data/stream?symbols=default", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenFor(t, "1"))
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(uint64(first), 10))
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := bufio.NewReader(resp.Body)
+	nextID := func() string {
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				return ""
+			}
+			if id, ok := strings.CutPrefix(line, "id: "); ok {
+				return strings.TrimSpace(id)
+			}
+		}
+	}
+	assert.Equal(t, strconv.FormatUint(uint64(second), 10), nextID())
+	third := record(3)
+	assert.Equal(t, strconv.FormatUint(uint64(third), 10), nextID())
+
+	// WebSockets take the token as a query parameter and are told to
+	// reload when they missed more than the resume limit
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/data/ws?access_token=" + tokenFor(t, "1")
+	record(4)
+	record(5)
+	conn, _, err := websocket.DefaultDialer.Dial(url+"&last_id="+strconv.FormatUint(uint64(first), 10), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	type message struct {
+		Type    string
+		ID      uint
+		Value   string
+		Symbols []string
+	}
+	read := func() message {
+		var m message
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		assert.NoError(t, conn.ReadJSON(&m))
+		return m
+	}
+	assert.Equal(t, message{Type: "subscribed", Symbols: []string{"*"}}, read())
+	assert.Equal(t, "reset", read().Type)
+
+	conn.WriteJSON(map[string]interface{}{"action": "unsubscribe", "symbols": []string{"*"}})
+	assert.Equal(t, message{Type: "subscribed", Symbols: []string{}}, read())
+	conn.WriteJSON(map[string]interface{}{"action": "subscribe", "symbols": []string{"default"}})
+	assert.Equal(t, message{Type: "subscribed", Symbols: []string{"DEFAULT"}}, read())
+	sixth := record(6)
+	assert.Equal(t, message{Type: "price", ID: sixth, Value: "6"}, read())
+
+	// Streams check the audience like every /data route
+	_, resp, err = websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/data/ws?access_token="+adminToken(t, "admin", "1"), nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestOutbox_RelayPublishesPriceEvents(t *testing.T) {
+	database.DB.Exec("DELETE FROM outbox_events")
+	database.DB.Exec("DELETE FROM prices")
+
+	publisher := &events.MemoryPublisher{}
+	relay := events.NewRelay(database.DB, publisher, utils.OutboxConfig{Batch: 2, Retention: time.Hour})
+	ctx := context.Background()
+
+	price := models.Price{TenantID: "2", Value: num(12.5), Volume: num(3)}
+	database.DB.Create(&price)
+	database.DB.Create(&models.Price{Value: num(13)})
+	database.DB.Create(&models.Price{Value: num(14)})
+
+	// Prices rolled back with their transaction leave no event
+	database.DB.Transaction(func(tx *gorm.DB) error {
+		tx.Create(&models.Price{Value: num(99)})
+		return errors.New("rollback")
+	})
+
+	n, err := relay.Drain(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, _ = relay.Drain(ctx)
+	assert.Equal(t, 1, n)
+	n, _ = relay.Drain(ctx)
+	assert.Zero(t, n)
+
+	published := publisher.Events()
+	if assert.Len(t, published, 3) {
+		assert.Less(t, published[0].ID, published[1].ID)
+		assert.Equal(t, models.TopicPriceCreated, published[0].Topic)
+		var payload models.PriceCreated
+		assert.NoError(t, json.Unmarshal(published[0].Payload, &payload))
+		assert.Equal(t, price.ID, payload.ID)
+		assert.Equal(t, models.DefaultInstrumentSymbol, payload.Symbol)
+		assert.Equal(t, "2", payload.TenantID)
+		assert.Equal(t, []string{"12.5", "3"}, []string{payload.Value.String(), payload.Volume.String()})
+	}
+
+	// Failed events stay in the outbox until they are published
+	database.DB.Create(&models.Price{Value: num(15)})
+	publisher.Err = errors.New("bus down")
+	_, err = relay.Drain(ctx)
+	assert.Error(t, err)
+	publisher.Err = nil
+	n, _ = relay.Drain(ctx)
+	assert.Equal(t, 1, n)
+
+	// Published events are pruned after the retention
+	database.DB.Exec("UPDATE outbox_events SET published_at = NOW() - INTERVAL '2 hours' WHERE id <= ?", published[2].ID)
+	pruned, err := relay.Prune(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), pruned)
+}
+
+func TestRetention_ArchivesAndDownsamples(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	database.DB.Exec("DELETE FROM price_archives")
+	for _, interval := range models.CandleIntervals {
+		database.DB.Exec("DELETE FROM " + models.CandleTable(interval))
+	}
+	t.Cleanup(func() { database.DB.Exec("DELETE FROM price_archives") })
+
+	old := time.Now().Add(-48 * time.Hour).UTC().Truncate(time.Hour)
+	for i := 0; i < 5; i++ {
+		database.DB.Create(&models.Price{Value: num(100 + float64(i)), Volume: num(1), CreatedAt: old.Add(time.Duration(i) * time.Minute)})
+	}
+	database.DB.Create(&models.Price{TenantID: "2", Value: num(50), CreatedAt: old.Add(10 * time.Minute)})
+	database.DB.Create(&models.Price{Value: num(110), CreatedAt: time.Now().Add(-time.Minute)})
+
+	countRows := func(table string) int64 {
+		var n int64
+		database.DB.Table(table).Count(&n)
+		return n
+	}
+	archivedTicks := func() int64 {
+		var n int64
+		database.DB.Table(models.CandleTable("5m")).Where("bucket_start < ?", old.Add(time.Hour)).Select("COALESCE(SUM(ticks), 0)").Scan(&n)
+		return n
+	}
+	ctx := context.Background()
+
+	// A dry run reports what would go and changes nothing
+	report, err := retentionJob.RunOnce(ctx, true)
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, int64(6), report.Prices)
+	assert.Equal(t, int64(3), report.Archives)
+	assert.Equal(t, map[string]int64{"1m": 6}, report.Candles)
+	assert.Equal(t, int64(7), countRows("prices"))
+	assert.Equal(t, int64(7), countRows(models.CandleTable("1m")))
+	assert.Zero(t, countRows("price_archives"))
+
+	// Expired prices move to archives, oldest first, and their 5m candles stay
+	report, err = retentionJob.RunOnce(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), report.Prices)
+	assert.Equal(t, int64(3), report.Archives)
+	assert.Positive(t, report.Bytes)
+	assert.Equal(t, int64(1), countRows("prices"))
+	assert.Equal(t, int64(1), countRows(models.CandleTable("1m")))
+	assert.Equal(t, int64(6), archivedTicks())
+
+	var archives []models.PriceArchive
+	database.DB.Order("id").Find(&archives)
+	var ticks []utils.PriceTick
+	for _, a := range archives {
+		loaded, err := utils.LoadPriceFile(a.Path)
+		assert.NoError(t, err)
+		ticks = append(ticks, loaded...)
+	}
+	if assert.Len(t, ticks, 6) {
+		assert.Equal(t, models.DefaultInstrumentSymbol, ticks[0].Symbol)
+		assert.Equal(t, "100", ticks[0].Value.String())
+		assert.Equal(t, "50", ticks[5].Value.String())
+		assert.True(t, ticks[5].Time.Equal(old.Add(10*time.Minute)))
+	}
+
+	// Rebuilding candles leaves the archived buckets alone
+	assert.NoError(t, models.RebuildCandles(database.DB, 0, time.Time{}, time.Time{}))
+	assert.Equal(t, int64(6), archivedTicks())
+	assert.Equal(t, int64(1), countRows(models.CandleTable("1m")))
+
+	// Progress is reported to admins
+	req := httptest.NewRequest(http.MethodGet, "/admin/retention", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken(t, "admin", "1"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var status struct {
+		Status   retention.Status
+		Archives []models.PriceArchive
+	}
+	json.Unmarshal(w.Body.Bytes(), &status)
+	assert.Equal(t, int64(1), status.Status.Totals.Runs)
+	assert.Equal(t, int64(6), status.Status.Totals.Prices)
+	assert.Equal(t, int64(6), status.Status.Totals.Candles)
+	assert.Len(t, status.Archives, 3)
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/retention/run?dry_run=true", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken(t, "admin", "1"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.True(t, report.DryRun)
+	assert.Zero(t, report.Prices)
+
+	req = httptest.NewRequest(http.MethodPost, "/admin/retention/run", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken(t, "user", "1"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func importFile(t *testing.T, name, data, query, token string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", name)
+	assert.NoError(t, err)
+	part.Write([]byte(data))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/prices/import"+query, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestImport_DeduplicatesAndRebuildsCandles(t *testing.T) {
+	database.DB.Exec("DELETE FROM prices")
+	database.DB.Exec("DELETE FROM price_archives")
+	for _, interval := range models.CandleIntervals {
+		database.DB.Exec("DELETE FROM " + models.CandleTable(interval))
+	}
+	t.Cleanup(func() { database.DB.Exec("DELETE FROM price_archives") })
+	admin := adminToken(t, "admin", "1")
+
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	database.DB.Create(&models.Price{Value: num(100), Volume: num(1), CreatedAt: base})
+
+	csv := `symbol,value,volume,time
+DEFAULT,100,1,2024-05-01T10:00:00Z
+default,101.004,2,2024-05-01T10:01:00Z
+DEFAULT,102,1,2024-05-01T10:01:00Z
+DEFAULT,abc,1,2024-05-01T10:02:00Z
+NOPE,1,1,2024-05-01T10:02:00Z
+DEFAULT,103,,1714557780
+DEFAULT,104,1,
+`
+	w := importFile(t, "history.csv", csv, "", admin)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var report importer.Report
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, "csv", report.Format)
+	assert.Equal(t, 7, report.Rows)
+	assert.Equal(t, int64(2), report.Imported)
+	assert.Equal(t, int64(1), report.Existing)
+	assert.Equal(t, 1, report.Duplicates)
+	assert.Equal(t, 3, report.Rejected)
+	var lines []int
+	for _, e := range report.Errors {
+		lines = append(lines, e.Line)
+	}
+	assert.Equal(t, []int{5, 6, 8}, lines)
+	if assert.Len(t, report.Symbols, 1) {
+		s := report.Symbols[0]
+		assert.Equal(t, models.DefaultInstrumentSymbol, s.Symbol)
+		assert.Equal(t, []int64{3, 2}, []int64{int64(s.Rows), s.Imported})
+		assert.True(t, s.From.Equal(base))
+		assert.True(t, s.To.Equal(base.Add(3*time.Minute)))
+	}
+
+	// Values are rounded to the tick size and the candles cover the import
+	var stored []models.Price
+	database.DB.Order("created_at").Find(&stored)
+	if assert.Len(t, stored, 3) {
+		assert.Equal(t, "101", stored[1].Value.String())
+	}
+	var candle models.Candle
+	database.DB.Table(models.CandleTable("5m")).Where("bucket_start = ? AND tenant_id = ''", base).First(&candle)
+	assert.Equal(t, []float64{100, 103, 100, 103, 3}, floats(candle.Open, candle.High, candle.Low, candle.Close, candle.Volume))
+	assert.Equal(t, int64(3), candle.Ticks)
+
+	// Importing again adds nothing
+	w = importFile(t, "history.csv", csv, "", admin)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, int64(0), report.Imported)
+	assert.Equal(t, int64(3), report.Existing)
+
+	// Tenant imports, with the format given explicitly
+	w = importFile(t, "upload", `{"symbol":"DEFAULT","value":"50","time":"2024-05-01T10:04:00Z"}`, "?format=ndjson&tenant_id=2", admin)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, int64(1), report.Imported)
+	var tenantPrices int64
+	database.DB.Model(&models.Price{}).Where("tenant_id = ?", "2").Count(&tenantPrices)
+	assert.Equal(t, int64(1), tenantPrices)
+
+	// Recent prices raise price events like live ones, older ones do not
+	database.DB.Exec("DELETE FROM outbox_events")
+	recent := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	ndjson := `{"symbol":"DEFAULT","value":"60","time":"` + recent.Format(time.RFC3339) + `"}
+{"symbol":"DEFAULT","value":"61","time":"2024-05-01T10:05:00Z"}`
+	w = importFile(t, "recent.ndjson", ndjson, "", admin)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, int64(2), report.Imported)
+	var outbox []models.OutboxEvent
+	database.DB.Find(&outbox)
+	if assert.Len(t, outbox, 1) {
+		assert.Equal(t, models.TopicPriceCreated, outbox[0].Topic)
+		var payload models.PriceCreated
+		assert.NoError(t, json.Unmarshal([]byte(outbox[0].Payload), &payload))
+		assert.NotZero(t, payload.ID)
+		assert.Equal(t, models.DefaultInstrumentSymbol, payload.Symbol)
+		assert.Equal(t, "60", payload.Value.String())
+		assert.True(t, payload.Time.Equal(recent))
+	}
+
+	// Prices within archived days are refused
+	database.DB.Create(&models.PriceArchive{Path: "a.ndjson.gz", Prices: 1, FirstAt: base, LastAt: base.Add(2 * time.Hour)})
+	w = importFile(t, "late.ndjson", `{"symbol":"DEFAULT","value":"50","time":"2024-05-01T23:00:00Z"}`, "", admin)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Equal(t, 1, report.Rejected)
+	assert.Zero(t, report.Imported)
+
+	assert.Equal(t, http.StatusBadRequest, importFile(t, "bad.csv", "symbol,amount\nA,1\n", "", admin).Code)
+	assert.Equal(t, http.StatusBadRequest, importFile(t, "prices.xlsx", csv, "", admin).Code)
+	assert.Equal(t, http.StatusForbidden, importFile(t, "history.csv", csv, "", adminToken(t, "user", "1")).Code)
+}
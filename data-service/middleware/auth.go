@@ -6,87 +6,197 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
 
 var SecretKey []byte
 var expectedAud string
 
-func init() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		utils.Logger.Panic("No .env file found", zap.Error(err))
-	}
+// introspectionClient bounds how long a request waits for auth-service.
+var introspectionClient = &http.Client{Timeout: 10 * time.Second}
+
+// adminAud is the audience of the tokens admins manage data-service with:
+// those of the web client they sign in to.
+var adminAud string
+
+// platformTenantID is the tenant whose admins administer the platform,
+// including the shared instruments and those of every tenant.
+const platformTenantID = "1"
 
+// Init reads the middleware settings from the environment. It must run
+// after the logger is initialized and .env is loaded.
+func Init() {
 	secret := os.Getenv("SECRET_KEY")
 	if secret == "" {
-		utils.Logger.Error("SECRET_KEY not set in environment", zap.Error(err))
+		utils.Logger.Error("SECRET_KEY not set in environment")
 	}
 
 	SecretKey = []byte(secret)
 
 	expectedAud = os.Getenv("TRADE_SERVICE_CLIENT_ID")
+	adminAud = os.Getenv("WEB_CLIENT_ID")
 }
 
+// JWTAuthMiddleware accepts tokens with trade-service as audience, which
+// trade-service obtains on behalf of its users.
 func JWTAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		h := c.GetHeader("Authorization")
-		parts := strings.SplitN(h, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			utils.Logger.Warn("Missing or bad auth header")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing or bad auth header"})
-			return
+		if authenticate(c, expectedAud) {
+			c.Next()
 		}
-		tokenString := parts[1]
+	}
+}
 
-		// Local signature + exp check
-		token, err := jwt.Parse(parts[1], func(t *jwt.Token) (interface{}, error) {
-			return SecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			utils.Logger.Warn("Invalid token", zap.Error(err))
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			return
+// StreamAuthMiddleware is JWTAuthMiddleware for the streaming endpoints.
+// Browsers cannot set headers on EventSource and WebSocket requests, so
+// the token may also be passed as ?access_token.
+func StreamAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("access_token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
 		}
-
-		// Validity Check
-		resp, err := http.PostForm(
-			os.Getenv("AUTH_URL")+"/oauth/introspect",
-			url.Values{"token": {tokenString}},
-		)
-		if err != nil {
-			utils.Logger.Error("Token Introspection failed", zap.Error(err))
-			c.AbortWithStatusJSON(500, gin.H{"error": "Introspection failed"})
-			return
+		if authenticate(c, expectedAud) {
+			c.Next()
 		}
-		defer resp.Body.Close()
+	}
+}
 
-		var body struct {
-			Active bool `json:"active"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
-			utils.Logger.Error("Bad Introspection result", zap.Error(err))
-			c.AbortWithStatusJSON(500, gin.H{"error": "Bad introspection response"})
+// AdminAuthMiddleware accepts web client tokens of platform admins only.
+// auth-service reports the user's role in the introspection response.
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticate(c, adminAud) {
 			return
 		}
-		if !body.Active {
-			c.AbortWithStatusJSON(401, gin.H{"error": "Token revoked"})
+		if c.GetString("role") != "admin" || c.GetString("tenant_id") != platformTenantID {
+			utils.Logger.Warn("Admin access denied", zap.String("user_id", c.GetString("user_id")))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Audience Check
-		claims := token.Claims.(jwt.MapClaims)
-		if aud, _ := claims["aud"].(string); aud != expectedAud {
-			utils.Logger.Warn("Wrong audience", zap.String("audience", aud))
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Wrong audience"})
+// TenantAdminAuthMiddleware accepts web client tokens of the admins of any
+// tenant, and marks those of platform admins with "platform_admin".
+func TenantAdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authenticate(c, adminAud) {
 			return
 		}
+		if c.GetString("role") != "admin" || c.GetString("tenant_id") == "" {
+			utils.Logger.Warn("Admin access denied", zap.String("user_id", c.GetString("user_id")))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+		c.Set("platform_admin", c.GetString("tenant_id") == platformTenantID)
 		c.Next()
 	}
 }
+
+// authenticate checks the bearer token and its audience and stores the
+// caller's identity in c. It aborts c and returns false if the token is
+// not accepted.
+func authenticate(c *gin.Context, audience string) bool {
+	h := c.GetHeader("Authorization")
+	parts := strings.SplitN(h, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		utils.Logger.Warn("Missing or bad auth header")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing or bad auth header"})
+		return false
+	}
+	tokenString := parts[1]
+
+	// Local signature + exp check
+	var claims jwt.MapClaims
+	isJWT := utils.IsJWT(tokenString)
+	if isJWT {
+		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+			return SecretKey, nil
+		})
+		if err != nil || !token.Valid {
+			utils.Logger.Warn("Invalid token", zap.Error(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return false
+		}
+		claims = token.Claims.(jwt.MapClaims)
+	}
+
+	// Validity Check
+	resp, err := postIntrospection(tokenString)
+	if err != nil {
+		utils.Logger.Error("Token Introspection failed", zap.Error(err))
+		c.AbortWithStatusJSON(500, gin.H{"error": "Introspection failed"})
+		return false
+	}
+	defer resp.Body.Close()
+	// A rate limited or failing auth-service says nothing about the token.
+	if resp.StatusCode != http.StatusOK {
+		utils.Logger.Error("Token Introspection unavailable", zap.Int("status", resp.StatusCode))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Introspection unavailable"})
+		return false
+	}
+
+	var body jwt.MapClaims
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		utils.Logger.Error("Bad Introspection result", zap.Error(err))
+		c.AbortWithStatusJSON(500, gin.H{"error": "Bad introspection response"})
+		return false
+	}
+	if active, _ := body["active"].(bool); !active {
+		c.AbortWithStatusJSON(401, gin.H{"error": "Token revoked"})
+		return false
+	}
+	// Opaque tokens carry nothing themselves; the introspection response
+	// stands in for their claims.
+	if !isJWT {
+		claims = body
+		claims["aud"] = body["client_id"]
+	}
+
+	// Audience Check
+	if got, _ := claims["aud"].(string); got != audience {
+		utils.Logger.Warn("Wrong audience", zap.String("audience", got))
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Wrong audience"})
+		return false
+	}
+
+	// Exchanged tokens name the end user as subject and the calling
+	// service in "act"; machine tokens have no subject.
+	if act, ok := claims["act"].(map[string]interface{}); ok {
+		c.Set("user_id", claims["sub"])
+		c.Set("actor", act["sub"])
+	} else if sub, _ := claims["sub"].(string); sub != "" {
+		c.Set("user_id", sub)
+	}
+	role, _ := body["role"].(string)
+	c.Set("role", role)
+	// Tokens of users carry their tenant; machine tokens of unbound
+	// clients only see shared data.
+	tenantID, _ := claims["tenant_id"].(string)
+	c.Set("tenant_id", tenantID)
+	c.Set("client_id", claims["aud"])
+	c.Set("scope", claims["scope"])
+	return true
+}
+
+// postIntrospection sends the introspection request. data-service
+// authenticates as its client, so that auth-service rate limits its
+// introspections by client rather than by address.
+func postIntrospection(token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, os.Getenv("AUTH_URL")+"/oauth/introspect",
+		strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id := os.Getenv("DATA_SERVICE_CLIENT_ID"); id != "" {
+		req.SetBasicAuth(id, os.Getenv("DATA_SERVICE_CLIENT_SECRET"))
+	}
+	return introspectionClient.Do(req)
+}
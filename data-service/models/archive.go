@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PriceArchive records a file of prices that the retention job moved out
+// of the database. The candles of archived prices are kept, and are no
+// longer rebuilt since their prices are gone.
+type PriceArchive struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	Path   string `gorm:"not null" json:"path"`
+	Prices int    `gorm:"not null" json:"prices"`
+	Bytes  int64  `gorm:"not null" json:"bytes"`
+	// FirstAt and LastAt are the times of the oldest and newest price in
+	// the file.
+	FirstAt   time.Time `gorm:"not null" json:"first_at"`
+	LastAt    time.Time `gorm:"not null;index" json:"last_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ArchivedUntil returns the time of the newest archived price, or the zero
+// time if nothing was archived. Prices are archived oldest first, so no
+// price before it is left in the database.
+func ArchivedUntil(db *gorm.DB) (time.Time, error) {
+	var until *time.Time
+	if err := db.Model(&PriceArchive{}).Select("MAX(last_at)").Scan(&until).Error; err != nil {
+		return time.Time{}, err
+	}
+	if until == nil {
+		return time.Time{}, nil
+	}
+	return *until, nil
+}
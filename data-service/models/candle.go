@@ -0,0 +1,174 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// CandleIntervals are the candle intervals kept in rollup tables, shortest
+// first.
+var CandleIntervals = []string{"1m", "5m", "1h", "1d"}
+
+var candleDurations = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// CandleDuration returns the length of a candle interval.
+func CandleDuration(interval string) (time.Duration, bool) {
+	d, ok := candleDurations[interval]
+	return d, ok
+}
+
+// CandleTable is the rollup table of an interval, e.g. candles_5m.
+func CandleTable(interval string) string {
+	return "candles_" + interval
+}
+
+// Candle is the OHLCV summary of an instrument's prices in one bucket.
+// Each interval has its own table, and like prices, candles of shared
+// market data have an empty TenantID. FirstAt and LastAt are the times of
+// the opening and closing prices, so that shared and tenant candles can be
+// merged.
+type Candle struct {
+	InstrumentID uint            `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	TenantID     string          `gorm:"primaryKey" json:"-"`
+	BucketStart  time.Time       `gorm:"primaryKey" json:"time"`
+	Open         decimal.Decimal `gorm:"type:numeric;not null" json:"open"`
+	High         decimal.Decimal `gorm:"type:numeric;not null" json:"high"`
+	Low          decimal.Decimal `gorm:"type:numeric;not null" json:"low"`
+	Close        decimal.Decimal `gorm:"type:numeric;not null" json:"close"`
+	Volume       decimal.Decimal `gorm:"type:numeric;not null" json:"volume"`
+	Ticks        int64           `gorm:"not null" json:"ticks"`
+	FirstAt      time.Time       `gorm:"not null" json:"-"`
+	LastAt       time.Time       `gorm:"not null" json:"-"`
+}
+
+// MigrateCandles creates the rollup tables and fills the ones that are
+// still empty from the stored prices.
+func MigrateCandles(db *gorm.DB) error {
+	for _, interval := range CandleIntervals {
+		table := CandleTable(interval)
+		if err := db.Table(table).AutoMigrate(&Candle{}); err != nil {
+			return err
+		}
+		var exists bool
+		if err := db.Raw("SELECT EXISTS (SELECT 1 FROM " + table + ")").Scan(&exists).Error; err != nil {
+			return err
+		}
+		if !exists {
+			if err := rebuildCandles(db, interval, 0, time.Time{}, time.Time{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addToCandles folds one price into the candles of every interval.
+func addToCandles(tx *gorm.DB, p *Price) error {
+	for _, interval := range CandleIntervals {
+		table := CandleTable(interval)
+		bucket := p.CreatedAt.UTC().Truncate(candleDurations[interval])
+		err := tx.Exec(fmt.Sprintf(`
+INSERT INTO %[1]s (instrument_id, tenant_id, bucket_start, open, high, low, close, volume, ticks, first_at, last_at)
+VALUES (@instrument, @tenant, @bucket, @value, @value, @value, @value, @volume, 1, @at, @at)
+ON CONFLICT (instrument_id, tenant_id, bucket_start) DO UPDATE SET
+	open = CASE WHEN EXCLUDED.first_at < %[1]s.first_at THEN EXCLUDED.open ELSE %[1]s.open END,
+	close = CASE WHEN EXCLUDED.last_at >= %[1]s.last_at THEN EXCLUDED.close ELSE %[1]s.close END,
+	high = GREATEST(%[1]s.high, EXCLUDED.high),
+	low = LEAST(%[1]s.low, EXCLUDED.low),
+	volume = %[1]s.volume + EXCLUDED.volume,
+	ticks = %[1]s.ticks + 1,
+	first_at = LEAST(%[1]s.first_at, EXCLUDED.first_at),
+	last_at = GREATEST(%[1]s.last_at, EXCLUDED.last_at)`, table),
+			map[string]interface{}{
+				"instrument": p.InstrumentID,
+				"tenant":     p.TenantID,
+				"bucket":     bucket,
+				"value":      p.Value,
+				"volume":     p.Volume,
+				"at":         p.CreatedAt,
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildCandles recomputes the candles of every interval from the stored
+// prices of an instrument between from and to. An instrumentID of 0 means
+// all instruments and a zero from or to leaves that end open. Candles of
+// archived prices are left as they are.
+func RebuildCandles(db *gorm.DB, instrumentID uint, from, to time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, interval := range CandleIntervals {
+			if err := rebuildCandles(tx, interval, instrumentID, from, to); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func rebuildCandles(db *gorm.DB, interval string, instrumentID uint, from, to time.Time) error {
+	d := candleDurations[interval]
+	table := CandleTable(interval)
+
+	// Widen the range to whole buckets so that no candle is left partial.
+	if !from.IsZero() {
+		from = from.UTC().Truncate(d)
+	}
+	if !to.IsZero() {
+		if bucket := to.UTC().Truncate(d); !bucket.Equal(to) {
+			to = bucket.Add(d)
+		}
+	}
+	// Buckets that hold archived prices would lose them if recomputed.
+	until, err := ArchivedUntil(db)
+	if err != nil {
+		return err
+	}
+	if !until.IsZero() {
+		if kept := until.UTC().Truncate(d).Add(d); from.Before(kept) {
+			from = kept
+		}
+		if !to.IsZero() && !from.Before(to) {
+			return nil
+		}
+	}
+	scope := func(column string) func(*gorm.DB) *gorm.DB {
+		return func(q *gorm.DB) *gorm.DB {
+			if instrumentID != 0 {
+				q = q.Where("instrument_id = ?", instrumentID)
+			}
+			if !from.IsZero() {
+				q = q.Where(column+" >= ?", from)
+			}
+			if !to.IsZero() {
+				q = q.Where(column+" < ?", to)
+			}
+			return q
+		}
+	}
+
+	if err := db.Table(table).Where("TRUE").Scopes(scope("bucket_start")).Delete(&Candle{}).Error; err != nil {
+		return err
+	}
+
+	secs := int64(d / time.Second)
+	bucket := fmt.Sprintf("to_timestamp(floor(extract(epoch FROM created_at) / %d) * %d)", secs, secs)
+	rows := db.Table("prices").Scopes(scope("created_at")).
+		Select(`instrument_id, tenant_id, ` + bucket + ` AS bucket_start,
+	(array_agg(value ORDER BY created_at, id))[1] AS open, MAX(value) AS high, MIN(value) AS low,
+	(array_agg(value ORDER BY created_at DESC, id DESC))[1] AS close,
+	SUM(volume) AS volume, COUNT(*) AS ticks, MIN(created_at) AS first_at, MAX(created_at) AS last_at`).
+		Group("instrument_id, tenant_id, " + bucket)
+	return db.Exec("INSERT INTO "+table+" (instrument_id, tenant_id, bucket_start, open, high, low, close, volume, ticks, first_at, last_at) ?", rows).Error
+}
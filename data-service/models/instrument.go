@@ -0,0 +1,72 @@
+package models
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// DefaultInstrumentID is the instrument of all prices that predate
+	// multi-instrument support.
+	DefaultInstrumentID     uint = 1
+	DefaultInstrumentSymbol      = "DEFAULT"
+
+	InstrumentStatusActive   = "active"
+	InstrumentStatusHalted   = "halted"
+	InstrumentStatusDelisted = "delisted"
+
+	// MaxQuantityPrecision bounds the decimal places of trade quantities.
+	MaxQuantityPrecision = 8
+)
+
+var (
+	symbolPattern   = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._-]{0,19}$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// Instrument is a tradable asset. Prices are only generated for active
+// instruments; halted and delisted ones keep their history. Prices are
+// multiples of TickSize and trade quantities have at most
+// QuantityPrecision decimal places. Instruments with an empty TenantID are
+// shared by all tenants; the others, and their prices, are private to one
+// tenant. Symbols are unique across tenants, as they name routes, streams
+// and events.
+type Instrument struct {
+	ID                uint            `gorm:"primaryKey" json:"id"`
+	Symbol            string          `gorm:"uniqueIndex;not null" json:"symbol"`
+	TenantID          string          `gorm:"index;not null;default:''" json:"tenant_id"`
+	Name              string          `gorm:"not null;default:''" json:"name"`
+	TickSize          decimal.Decimal `gorm:"type:numeric;not null" json:"tick_size"`
+	QuantityPrecision int32           `gorm:"not null;default:0" json:"quantity_precision"`
+	Currency          string          `gorm:"not null" json:"currency"`
+	Status            string          `gorm:"not null;default:active" json:"status"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+}
+
+// RoundToTick rounds v to the nearest multiple of the tick size.
+func (i *Instrument) RoundToTick(v decimal.Decimal) decimal.Decimal {
+	return v.DivRound(i.TickSize, 0).Mul(i.TickSize)
+}
+
+// ValidSymbol reports whether s is an upper case ticker symbol that can be
+// used as a URL path segment.
+func ValidSymbol(s string) bool {
+	return symbolPattern.MatchString(s)
+}
+
+// ValidCurrency reports whether s is an ISO 4217 style currency code.
+func ValidCurrency(s string) bool {
+	return currencyPattern.MatchString(s)
+}
+
+// ValidInstrumentStatus reports whether s is a known instrument status.
+func ValidInstrumentStatus(s string) bool {
+	switch s {
+	case InstrumentStatusActive, InstrumentStatusHalted, InstrumentStatusDelisted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// TopicPriceCreated is the topic of PriceCreated events.
+const TopicPriceCreated = "price.created"
+
+// OutboxEvent is an event written in the transaction of the change it
+// describes, so that it exists if and only if the change was committed.
+// The outbox relay publishes it afterwards and sets PublishedAt.
+type OutboxEvent struct {
+	ID      uint64 `gorm:"primaryKey"`
+	Topic   string `gorm:"not null"`
+	Payload string `gorm:"type:jsonb;not null"`
+	// The relay scans the unpublished events only.
+	PublishedAt *time.Time `gorm:"index:idx_outbox_events_unpublished,where:published_at IS NULL"`
+	CreatedAt   time.Time
+}
+
+// PriceCreated is the payload of a price.created event.
+type PriceCreated struct {
+	ID           uint            `json:"id"`
+	InstrumentID uint            `json:"instrument_id"`
+	Symbol       string          `json:"symbol"`
+	TenantID     string          `json:"tenant_id"`
+	Value        decimal.Decimal `json:"value"`
+	Volume       decimal.Decimal `json:"volume"`
+	Time         time.Time       `json:"time"`
+}
+
+// instrumentSymbols caches symbols by instrument ID. Symbols never change
+// once an instrument is created.
+var instrumentSymbols sync.Map // uint -> string
+
+func instrumentSymbol(tx *gorm.DB, id uint) (string, error) {
+	if symbol, ok := instrumentSymbols.Load(id); ok {
+		return symbol.(string), nil
+	}
+	var symbol string
+	if err := tx.Model(&Instrument{}).Where("id = ?", id).Pluck("symbol", &symbol).Error; err != nil {
+		return "", err
+	}
+	instrumentSymbols.Store(id, symbol)
+	return symbol, nil
+}
+
+// enqueuePriceCreated adds the price.created event of p to the outbox.
+func enqueuePriceCreated(tx *gorm.DB, p *Price) error {
+	symbol, err := instrumentSymbol(tx, p.InstrumentID)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(PriceCreated{
+		ID:           p.ID,
+		InstrumentID: p.InstrumentID,
+		Symbol:       symbol,
+		TenantID:     p.TenantID,
+		Value:        p.Value,
+		Volume:       p.Volume,
+		Time:         p.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Create(&OutboxEvent{Topic: TopicPriceCreated, Payload: string(payload)}).Error
+}
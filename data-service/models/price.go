@@ -1,9 +1,67 @@
 package models
 
-import "time"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Price is a quote of an instrument. Prices with an empty TenantID are
+// shared market data; the others are private to one tenant.
 type Price struct {
-	ID        uint      `gorm:"primaryKey"`
-	Value     float64   `gorm:"not null"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
+	ID           uint   `gorm:"primaryKey"`
+	InstrumentID uint   `gorm:"index;index:idx_prices_instrument_created,priority:1;not null;default:1"`
+	TenantID     string `gorm:"index;not null;default:''"`
+	// Value is exact and a multiple of the instrument's tick size.
+	Value decimal.Decimal `gorm:"type:numeric;not null"`
+	// Volume traded at this price, if the source reports it.
+	Volume decimal.Decimal `gorm:"type:numeric;not null;default:0"`
+	// Range queries by instrument and time use the composite index.
+	CreatedAt time.Time `gorm:"autoCreateTime;index;index:idx_prices_instrument_created,priority:2"`
+}
+
+// priceVersions counts the prices this process created per instrument, so
+// that caches of derived data can tell when they are stale.
+var priceVersions sync.Map // uint -> *atomic.Uint64
+
+// PriceVersion changes whenever a price of the instrument is created.
+func PriceVersion(instrumentID uint) uint64 {
+	if v, ok := priceVersions.Load(instrumentID); ok {
+		return v.(*atomic.Uint64).Load()
+	}
+	return 0
+}
+
+// PricesChanged marks cached data of the instrument stale. Prices created
+// through gorm call it themselves.
+func PricesChanged(instrumentID uint) {
+	v, _ := priceVersions.LoadOrStore(instrumentID, new(atomic.Uint64))
+	v.(*atomic.Uint64).Add(1)
+}
+
+// AfterCreate keeps the candles up to date with every new price and adds
+// its event to the outbox, in the same transaction, and marks cached data
+// of the instrument stale.
+func (p *Price) AfterCreate(tx *gorm.DB) error {
+	PricesChanged(p.InstrumentID)
+	if err := addToCandles(tx, p); err != nil {
+		return err
+	}
+	return enqueuePriceCreated(tx, p)
+}
+
+// TenantScope limits a query of prices, candles or instruments to shared
+// rows and the given tenant's. The column is qualified with the queried
+// table, so that joined tables may have a tenant_id too.
+func TenantScope(tenantID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.IN{
+			Column: clause.Column{Table: clause.CurrentTable, Name: "tenant_id"},
+			Values: []interface{}{"", tenantID},
+		})
+	}
 }
@@ -0,0 +1,297 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/data-service/models"
+	"github.com/RanggaNehemia/golang-microservices/data-service/utils"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRunning is returned when a run is asked for while one is in progress.
+var ErrRunning = errors.New("retention job is already running")
+
+// Report is the outcome, or the progress so far, of one run. In a dry run
+// the counts are what a real run would archive and delete.
+type Report struct {
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// PricesBefore is the cutoff of the prices, if they expire.
+	PricesBefore *time.Time `json:"prices_before,omitempty"`
+	Prices       int64      `json:"prices"`
+	Archives     int64      `json:"archives"`
+	Bytes        int64      `json:"bytes"`
+	// Candles counts the expired candles per interval.
+	Candles map[string]int64 `json:"candles"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// Totals add up the real runs since the process started.
+type Totals struct {
+	Runs     int64 `json:"runs"`
+	Failures int64 `json:"failures"`
+	Prices   int64 `json:"prices"`
+	Archives int64 `json:"archives"`
+	Bytes    int64 `json:"bytes"`
+	Candles  int64 `json:"candles"`
+}
+
+// Status is the progress of the job.
+type Status struct {
+	Running bool    `json:"running"`
+	Current *Report `json:"current,omitempty"`
+	Last    *Report `json:"last,omitempty"`
+	Totals  Totals  `json:"totals"`
+}
+
+// archivedPrice is a line of an archive. It reads back as a price tick.
+type archivedPrice struct {
+	ID       uint   `json:"id"`
+	TenantID string `json:"tenant_id"`
+	utils.PriceTick
+}
+
+// Job enforces the retention policy: prices older than cfg.Raw are
+// written to archive files and deleted, their candles staying behind as
+// the downsampled history, and candles expire per interval.
+type Job struct {
+	db  *gorm.DB
+	cfg utils.RetentionConfig
+
+	run sync.Mutex // held while running
+
+	mu      sync.Mutex
+	current *Report
+	last    *Report
+	totals  Totals
+}
+
+func NewJob(db *gorm.DB, cfg utils.RetentionConfig) *Job {
+	return &Job{db: db, cfg: cfg}
+}
+
+// DryRun reports whether scheduled runs are dry runs.
+func (j *Job) DryRun() bool {
+	return j.cfg.DryRun
+}
+
+// Run runs the job every cfg.Interval until ctx is done.
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := j.RunOnce(ctx, j.cfg.DryRun); err != nil && !errors.Is(err, ErrRunning) {
+			utils.Logger.Error("Retention run failed", zap.Error(err))
+		}
+	}
+}
+
+// Status returns a snapshot of the job's progress.
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := Status{Running: j.current != nil, Totals: j.totals}
+	if j.current != nil {
+		s.Current = j.current.copy()
+	}
+	if j.last != nil {
+		s.Last = j.last.copy()
+	}
+	return s
+}
+
+func (r *Report) copy() *Report {
+	c := *r
+	c.Candles = make(map[string]int64, len(r.Candles))
+	for k, v := range r.Candles {
+		c.Candles[k] = v
+	}
+	return &c
+}
+
+// update applies f to the current report while holding the lock.
+func (j *Job) update(f func(r *Report)) {
+	j.mu.Lock()
+	f(j.current)
+	j.mu.Unlock()
+}
+
+// RunOnce applies the policy once and returns its report. A dry run only
+// counts what would be archived and deleted.
+func (j *Job) RunOnce(ctx context.Context, dryRun bool) (Report, error) {
+	if !j.run.TryLock() {
+		return Report{}, ErrRunning
+	}
+	defer j.run.Unlock()
+
+	now := time.Now()
+	j.mu.Lock()
+	j.current = &Report{DryRun: dryRun, StartedAt: now, Candles: map[string]int64{}}
+	j.mu.Unlock()
+
+	err := j.expirePrices(ctx, now, dryRun)
+	if err == nil {
+		err = j.expireCandles(ctx, now, dryRun)
+	}
+
+	j.mu.Lock()
+	report := j.current
+	j.current = nil
+	report.FinishedAt = time.Now()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if !dryRun {
+		j.totals.Runs++
+		if err != nil {
+			j.totals.Failures++
+		}
+		j.totals.Prices += report.Prices
+		j.totals.Archives += report.Archives
+		j.totals.Bytes += report.Bytes
+		for _, n := range report.Candles {
+			j.totals.Candles += n
+		}
+	}
+	j.last = report
+	result := *report.copy()
+	j.mu.Unlock()
+
+	utils.Logger.Info("Retention run finished",
+		zap.Bool("dry_run", dryRun),
+		zap.Int64("prices", result.Prices),
+		zap.Int64("archives", result.Archives),
+		zap.Int64("bytes", result.Bytes),
+		zap.Any("candles", result.Candles),
+		zap.Duration("took", result.FinishedAt.Sub(now)),
+		zap.Error(err),
+	)
+	return result, err
+}
+
+func (j *Job) expirePrices(ctx context.Context, now time.Time, dryRun bool) error {
+	if j.cfg.Raw <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-j.cfg.Raw)
+	j.update(func(r *Report) { r.PricesBefore = &cutoff })
+
+	if dryRun {
+		var n int64
+		if err := j.db.WithContext(ctx).Model(&models.Price{}).Where("created_at < ?", cutoff).Count(&n).Error; err != nil {
+			return err
+		}
+		batch := int64(max(j.cfg.Batch, 1))
+		j.update(func(r *Report) {
+			r.Prices = n
+			r.Archives = (n + batch - 1) / batch
+		})
+		return nil
+	}
+
+	for {
+		n, err := j.archiveBatch(ctx, cutoff)
+		if err != nil || n < j.cfg.Batch {
+			return err
+		}
+	}
+}
+
+// archiveBatch moves the oldest expired prices to an archive file and
+// returns how many it moved. The file is removed again if the prices
+// cannot be deleted, so that each price ends up in exactly one place.
+func (j *Job) archiveBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	var archive *models.PriceArchive
+	err := j.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var prices []archivedPrice
+		err := tx.Table("prices").
+			Select("prices.id, prices.tenant_id, instruments.symbol, prices.value, prices.volume, prices.created_at AS time").
+			Joins("JOIN instruments ON instruments.id = prices.instrument_id").
+			Where("prices.created_at < ?", cutoff).
+			Order("prices.created_at, prices.id").
+			Limit(max(j.cfg.Batch, 1)).
+			Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "prices"}, Options: "SKIP LOCKED"}).
+			Scan(&prices).Error
+		if err != nil || len(prices) == 0 {
+			return err
+		}
+
+		first, last := prices[0], prices[len(prices)-1]
+		path := filepath.Join(j.cfg.ArchiveDir, first.Time.UTC().Format("2006/01/02"),
+			fmt.Sprintf("prices-%s-%d.ndjson.gz", first.Time.UTC().Format("20060102T150405Z"), first.ID))
+		size, err := utils.WriteArchive(path, prices)
+		if err != nil {
+			return err
+		}
+		archive = &models.PriceArchive{Path: path, Prices: len(prices), Bytes: size, FirstAt: first.Time, LastAt: last.Time}
+
+		ids := make([]uint, len(prices))
+		for i, p := range prices {
+			ids[i] = p.ID
+		}
+		if err := tx.Where("id IN ?", ids).Delete(&models.Price{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(archive).Error
+	})
+	if err != nil {
+		if archive != nil {
+			os.Remove(archive.Path)
+		}
+		return 0, err
+	}
+	if archive == nil {
+		return 0, nil
+	}
+
+	j.update(func(r *Report) {
+		r.Prices += int64(archive.Prices)
+		r.Archives++
+		r.Bytes += archive.Bytes
+	})
+	utils.Logger.Info("Archived prices",
+		zap.String("path", archive.Path),
+		zap.Int("prices", archive.Prices),
+		zap.Int64("bytes", archive.Bytes),
+		zap.Time("last_at", archive.LastAt),
+	)
+	return archive.Prices, nil
+}
+
+func (j *Job) expireCandles(ctx context.Context, now time.Time, dryRun bool) error {
+	for _, interval := range models.CandleIntervals {
+		keep := j.cfg.Candles[interval]
+		if keep <= 0 {
+			continue
+		}
+		q := j.db.WithContext(ctx).Table(models.CandleTable(interval)).Where("bucket_start < ?", now.Add(-keep))
+		var n int64
+		if dryRun {
+			if err := q.Count(&n).Error; err != nil {
+				return err
+			}
+		} else {
+			res := q.Delete(&models.Candle{})
+			if res.Error != nil {
+				return res.Error
+			}
+			n = res.RowsAffected
+		}
+		j.update(func(r *Report) { r.Candles[interval] = n })
+	}
+	return nil
+}
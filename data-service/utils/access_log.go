@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// secretQuery matches the query parameters whose values must not end up in
+// access logs: the streaming endpoints accept the bearer token as
+// ?access_token.
+var secretQuery = regexp.MustCompile(`([?&]access_token=)[^&#]*`)
+
+// RedactQuery replaces the value of sensitive query parameters in a request
+// path with "REDACTED".
+func RedactQuery(path string) string {
+	return secretQuery.ReplaceAllString(path, "${1}REDACTED")
+}
+
+// AccessLogFormatter is gin's default access log format with sensitive
+// query parameters redacted.
+func AccessLogFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		RedactQuery(param.Path),
+		param.ErrorMessage,
+	)
+}
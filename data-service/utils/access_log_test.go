@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactQuery(t *testing.T) {
+	cases := map[string]string{
+		"/data/ws":                                    "/data/ws",
+		"/data/ws?access_token=eyJ.abc.def":           "/data/ws?access_token=REDACTED",
+		"/data/stream?symbols=BTC-USD&access_token=x": "/data/stream?symbols=BTC-USD&access_token=REDACTED",
+		"/data/ws?access_token=x&symbols=BTC-USD":     "/data/ws?access_token=REDACTED&symbols=BTC-USD",
+		"/data/ws?my_access_token=x":                  "/data/ws?my_access_token=x",
+	}
+	for in, want := range cases {
+		assert.Equal(t, want, RedactQuery(in), in)
+	}
+}
+
+func TestAccessLogFormatter(t *testing.T) {
+	line := AccessLogFormatter(gin.LogFormatterParams{
+		TimeStamp:  time.Now(),
+		StatusCode: http.StatusOK,
+		Method:     http.MethodGet,
+		Path:       "/data/ws?access_token=secret-token",
+	})
+	assert.Contains(t, line, "/data/ws?access_token=REDACTED")
+	assert.False(t, strings.Contains(line, "secret-token"))
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteArchive(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prices", "2024-01-01.ndjson.gz")
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ticks := []PriceTick{
+		{Symbol: "BTC-USD", Value: decimal.RequireFromString("100.05"), Volume: decimal.NewFromInt(2), Time: at},
+		{Symbol: "ETH-USD", Value: decimal.RequireFromString("0.1"), Time: at.Add(time.Minute)},
+	}
+
+	size, err := WriteArchive(path, ticks)
+	assert.NoError(t, err)
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, info.Size(), size)
+	}
+
+	// Archives can be replayed as price files
+	loaded, err := LoadPriceFile(path)
+	assert.NoError(t, err)
+	if assert.Len(t, loaded, 2) {
+		assert.Equal(t, "100.05", loaded[0].Value.String())
+		assert.True(t, loaded[1].Time.Equal(at.Add(time.Minute)))
+	}
+
+	// Existing archives are kept and no temporary file is left behind
+	_, err = WriteArchive(path, ticks[:1])
+	assert.Error(t, err)
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	assert.Len(t, entries, 1)
+	loaded, _ = LoadPriceFile(path)
+	assert.Len(t, loaded, 2)
+}
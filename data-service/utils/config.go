@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Config struct {
+	PriceSource PriceSourceConfig
+	Stream      StreamConfig
+	Outbox      OutboxConfig
+	Retention   RetentionConfig
+}
+
+// StreamConfig tunes the real-time price streams.
+type StreamConfig struct {
+	// Heartbeat is how often idle streams are pinged.
+	Heartbeat time.Duration
+	// Buffer is how many prices may wait for a subscriber before it is
+	// dropped as too slow.
+	Buffer int
+	// ResumeLimit is the most missed prices a reconnecting subscriber is
+	// sent; beyond that it is told to reload instead.
+	ResumeLimit int
+}
+
+// Load reads the configuration from the environment. It must run after
+// .env is loaded.
+func Load() *Config {
+	kind := os.Getenv("PRICE_SOURCE")
+	if kind == "" {
+		kind = PriceSourceGBM
+	}
+	channel := os.Getenv("EVENTS_CHANNEL")
+	if channel == "" {
+		channel = "data_events"
+	}
+	archiveDir := os.Getenv("RETENTION_ARCHIVE_DIR")
+	if archiveDir == "" {
+		archiveDir = "archive"
+	}
+
+	return &Config{
+		PriceSource: PriceSourceConfig{
+			Kind:          kind,
+			Interval:      getEnvDuration("PRICE_INTERVAL", time.Minute),
+			Seed:          getEnvInt64("PRICE_SEED", 0),
+			Drift:         getEnvFloat("PRICE_DRIFT", 0),
+			Volatility:    getEnvFloat("PRICE_VOLATILITY", 0.5),
+			InitialPrice:  getEnvFloat("PRICE_INITIAL", 100),
+			ReplayFile:    os.Getenv("PRICE_REPLAY_FILE"),
+			ReplayLoop:    os.Getenv("PRICE_REPLAY_LOOP") == "true",
+			ReplaySpeed:   getEnvFloat("PRICE_REPLAY_SPEED", 0),
+			WebhookSecret: os.Getenv("PRICE_WEBHOOK_SECRET"),
+		},
+		Stream: StreamConfig{
+			Heartbeat:   getEnvDuration("STREAM_HEARTBEAT", 15*time.Second),
+			Buffer:      int(getEnvInt64("STREAM_BUFFER", 256)),
+			ResumeLimit: int(getEnvInt64("STREAM_RESUME_LIMIT", 1000)),
+		},
+		Outbox: OutboxConfig{
+			Channel:   channel,
+			Interval:  getEnvDuration("OUTBOX_INTERVAL", time.Second),
+			Batch:     int(getEnvInt64("OUTBOX_BATCH", 100)),
+			Retention: getEnvDuration("OUTBOX_RETENTION", 24*time.Hour),
+		},
+		Retention: RetentionConfig{
+			Interval:   getEnvDuration("RETENTION_INTERVAL", time.Hour),
+			Raw:        getEnvDuration("RETENTION_RAW", 30*24*time.Hour),
+			ArchiveDir: archiveDir,
+			Batch:      int(getEnvInt64("RETENTION_BATCH", 10000)),
+			DryRun:     os.Getenv("RETENTION_DRY_RUN") == "true",
+			Candles: map[string]time.Duration{
+				"1m": getEnvDuration("RETENTION_CANDLES_1M", 90*24*time.Hour),
+				"5m": getEnvDuration("RETENTION_CANDLES_5M", 365*24*time.Hour),
+				"1h": getEnvDuration("RETENTION_CANDLES_1H", 0),
+				"1d": getEnvDuration("RETENTION_CANDLES_1D", 0),
+			},
+		},
+	}
+}
+
+// OutboxConfig tunes the relay that publishes outbox events.
+type OutboxConfig struct {
+	// Channel is the Postgres channel events are notified on.
+	Channel string
+	// Interval is how often the outbox is drained.
+	Interval time.Duration
+	// Batch is how many events are published per transaction.
+	Batch int
+	// Retention is how long published events are kept.
+	Retention time.Duration
+}
+
+// RetentionConfig is the policy of the retention job. A zero duration
+// keeps data forever.
+type RetentionConfig struct {
+	// Interval is how often the job runs.
+	Interval time.Duration
+	// Raw is how long prices are kept. Older prices are archived and
+	// deleted; their candles remain.
+	Raw time.Duration
+	// Candles is how long the candles of each interval are kept.
+	Candles map[string]time.Duration
+	// ArchiveDir is where archives of deleted prices are written.
+	ArchiveDir string
+	// Batch is how many prices go in one archive file.
+	Batch int
+	// DryRun reports what the job would do without changing anything.
+	DryRun bool
+}
+
+func getEnvInt64(key string, fallback int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		Logger.Warn("Invalid integer in environment, using default", zap.String("key", key), zap.Error(err))
+		return fallback
+	}
+	return n
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		Logger.Warn("Invalid number in environment, using default", zap.String("key", key), zap.Error(err))
+		return fallback
+	}
+	return f
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		Logger.Warn("Invalid duration in environment, using default", zap.String("key", key), zap.Error(err))
+		return fallback
+	}
+	return d
+}
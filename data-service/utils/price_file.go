@@ -0,0 +1,398 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/shopspring/decimal"
+)
+
+// Price file formats.
+const (
+	PriceFormatCSV     = "csv"
+	PriceFormatJSON    = "json"
+	PriceFormatNDJSON  = "ndjson"
+	PriceFormatParquet = "parquet"
+)
+
+// PriceFileFormat returns the format of a price file from its name, and
+// whether it is gzipped (e.g. prices.ndjson.gz).
+func PriceFileFormat(name string) (format string, gzipped bool, err error) {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, ".gz") {
+		gzipped = true
+		name = strings.TrimSuffix(name, ".gz")
+	}
+	switch filepath.Ext(name) {
+	case ".csv":
+		return PriceFormatCSV, gzipped, nil
+	case ".json":
+		return PriceFormatJSON, gzipped, nil
+	case ".ndjson", ".jsonl":
+		return PriceFormatNDJSON, gzipped, nil
+	case ".parquet":
+		return PriceFormatParquet, gzipped, nil
+	}
+	return "", false, fmt.Errorf("unsupported price file %q", name)
+}
+
+// LoadPriceFile reads ticks from a .csv, .json, .ndjson or .parquet file,
+// which may be gzipped.
+func LoadPriceFile(path string) ([]PriceTick, error) {
+	format, gzipped, err := PriceFileFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return collectPrices(r, format)
+}
+
+// ReadPriceFile reads the ticks of a price file in the given format and
+// calls each with every row's position, the tick and, if the row is
+// invalid, why. Positions are lines in CSV and NDJSON, and entries or rows
+// counted from 1 in JSON and Parquet. It stops at the first error each
+// returns, or when the file itself cannot be read.
+//
+// Parquet files need random access; other readers than files are read
+// into memory first.
+func ReadPriceFile(r io.Reader, format string, each func(line int, tick PriceTick, err error) error) error {
+	switch format {
+	case PriceFormatCSV:
+		return readPriceCSV(r, each)
+	case PriceFormatJSON:
+		return readPriceJSON(r, each)
+	case PriceFormatNDJSON:
+		return readPriceNDJSON(r, each)
+	case PriceFormatParquet:
+		return readPriceParquet(r, each)
+	}
+	return fmt.Errorf("unsupported price format %q", format)
+}
+
+// collectPrices reads a whole price file and fails on its first invalid
+// row.
+func collectPrices(r io.Reader, format string) ([]PriceTick, error) {
+	var ticks []PriceTick
+	err := ReadPriceFile(r, format, func(line int, tick PriceTick, err error) error {
+		if err != nil {
+			if format == PriceFormatJSON {
+				return fmt.Errorf("entry %d: %w", line-1, err)
+			}
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		ticks = append(ticks, tick)
+		return nil
+	})
+	return ticks, err
+}
+
+// ParsePriceCSV reads ticks from CSV with a header naming the symbol and
+// value (or price) columns and optionally time and volume columns.
+func ParsePriceCSV(r io.Reader) ([]PriceTick, error) {
+	return collectPrices(r, PriceFormatCSV)
+}
+
+// ParsePriceJSON reads ticks from a JSON array.
+func ParsePriceJSON(r io.Reader) ([]PriceTick, error) {
+	return collectPrices(r, PriceFormatJSON)
+}
+
+// ParsePriceNDJSON reads ticks from newline delimited JSON.
+func ParsePriceNDJSON(r io.Reader) ([]PriceTick, error) {
+	return collectPrices(r, PriceFormatNDJSON)
+}
+
+// ParsePriceParquet reads ticks from a Parquet file with symbol and value
+// (or price) columns and optionally time and volume columns.
+func ParsePriceParquet(r io.Reader) ([]PriceTick, error) {
+	return collectPrices(r, PriceFormatParquet)
+}
+
+func readPriceCSV(r io.Reader, each func(int, PriceTick, error) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return errors.New("empty price file")
+	}
+	if err != nil {
+		return err
+	}
+
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	symbolCol, okSymbol := col["symbol"]
+	valueCol, okValue := col["value"]
+	if !okValue {
+		valueCol, okValue = col["price"]
+	}
+	if !okSymbol || !okValue {
+		return errors.New("price CSV needs symbol and value columns")
+	}
+	timeCol, hasTime := col["time"]
+	volumeCol, hasVolume := col["volume"]
+
+	field := func(rec []string, i int) string {
+		if i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		tick, err := func() (PriceTick, error) {
+			value, err := decimal.NewFromString(field(rec, valueCol))
+			if err != nil {
+				return PriceTick{}, fmt.Errorf("invalid value %q", field(rec, valueCol))
+			}
+			tick := PriceTick{Symbol: field(rec, symbolCol), Value: value}
+			if hasTime {
+				if tick.Time, err = ParsePriceTime(field(rec, timeCol)); err != nil {
+					return tick, err
+				}
+			}
+			if hasVolume && field(rec, volumeCol) != "" {
+				if tick.Volume, err = decimal.NewFromString(field(rec, volumeCol)); err != nil {
+					return tick, fmt.Errorf("invalid volume %q", field(rec, volumeCol))
+				}
+			}
+			return tick, tick.Validate()
+		}()
+		if err := each(line, tick, err); err != nil {
+			return err
+		}
+	}
+}
+
+func readPriceJSON(r io.Reader, each func(int, PriceTick, error) error) error {
+	var ticks []PriceTick
+	if err := json.NewDecoder(r).Decode(&ticks); err != nil {
+		return err
+	}
+	for i, tick := range ticks {
+		if err := each(i+1, tick, tick.Validate()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPriceNDJSON(r io.Reader, each func(int, PriceTick, error) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var tick PriceTick
+		err := json.Unmarshal(scanner.Bytes(), &tick)
+		if err == nil {
+			err = tick.Validate()
+		}
+		if err := each(line, tick, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parquetRows is how many rows are decoded at a time.
+const parquetRows = 1024
+
+func readPriceParquet(r io.Reader, each func(int, PriceTick, error) error) error {
+	ra, ok := r.(io.ReaderAt)
+	seeker, seekable := r.(io.Seeker)
+	var size int64
+	if ok && seekable {
+		var err error
+		if size, err = seeker.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	} else {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	f, err := parquet.OpenFile(ra, size)
+	if err != nil {
+		return err
+	}
+	schema := f.Schema()
+	lookup := func(names ...string) (parquet.LeafColumn, bool) {
+		for _, name := range names {
+			if leaf, ok := schema.Lookup(name); ok {
+				return leaf, true
+			}
+		}
+		return parquet.LeafColumn{}, false
+	}
+	symbolCol, okSymbol := lookup("symbol")
+	valueCol, okValue := lookup("value", "price")
+	if !okSymbol || !okValue {
+		return errors.New("price Parquet file needs symbol and value columns")
+	}
+	timeCol, hasTime := lookup("time")
+	volumeCol, hasVolume := lookup("volume")
+
+	reader := parquet.NewReader(f)
+	defer reader.Close()
+	rows := make([]parquet.Row, parquetRows)
+	line := 0
+	for {
+		n, readErr := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			line++
+			values := map[int]parquet.Value{}
+			row.Range(func(i int, v []parquet.Value) bool {
+				if len(v) > 0 {
+					values[i] = v[0]
+				}
+				return true
+			})
+
+			tick, err := func() (PriceTick, error) {
+				var tick PriceTick
+				symbol := values[symbolCol.ColumnIndex]
+				if !symbol.IsNull() {
+					tick.Symbol = string(symbol.ByteArray())
+				}
+				var err error
+				if tick.Value, err = parquetDecimal(valueCol, values[valueCol.ColumnIndex]); err != nil {
+					return tick, fmt.Errorf("invalid value: %w", err)
+				}
+				if hasVolume && !values[volumeCol.ColumnIndex].IsNull() {
+					if tick.Volume, err = parquetDecimal(volumeCol, values[volumeCol.ColumnIndex]); err != nil {
+						return tick, fmt.Errorf("invalid volume: %w", err)
+					}
+				}
+				if hasTime && !values[timeCol.ColumnIndex].IsNull() {
+					if tick.Time, err = parquetTime(timeCol, values[timeCol.ColumnIndex]); err != nil {
+						return tick, err
+					}
+				}
+				return tick, tick.Validate()
+			}()
+			if err := each(line, tick, err); err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// parquetDecimal reads a number stored as a float, an integer, a DECIMAL
+// or a string.
+func parquetDecimal(col parquet.LeafColumn, v parquet.Value) (decimal.Decimal, error) {
+	if v.IsNull() {
+		return decimal.Zero, errors.New("missing")
+	}
+	lt := col.Node.Type().LogicalType()
+	isDecimal := lt != nil && lt.Decimal != nil
+	var scale int32
+	if isDecimal {
+		scale = lt.Decimal.Scale
+	}
+	switch v.Kind() {
+	case parquet.Float:
+		return decimal.NewFromFloat32(v.Float()), nil
+	case parquet.Double:
+		return decimal.NewFromFloat(v.Double()), nil
+	case parquet.Int32:
+		return decimal.New(int64(v.Int32()), -scale), nil
+	case parquet.Int64:
+		return decimal.New(v.Int64(), -scale), nil
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		if !isDecimal {
+			return decimal.NewFromString(strings.TrimSpace(string(v.ByteArray())))
+		}
+		// DECIMAL bytes are a big-endian two's complement unscaled value.
+		b := v.ByteArray()
+		unscaled := new(big.Int).SetBytes(b)
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+		}
+		return decimal.NewFromBigInt(unscaled, -scale), nil
+	}
+	return decimal.Zero, fmt.Errorf("unsupported type %s", v.Kind())
+}
+
+// parquetTime reads a TIMESTAMP, Unix seconds or an RFC 3339 string.
+func parquetTime(col parquet.LeafColumn, v parquet.Value) (time.Time, error) {
+	switch v.Kind() {
+	case parquet.Int64, parquet.Int32:
+		n := v.Int64()
+		if v.Kind() == parquet.Int32 {
+			n = int64(v.Int32())
+		}
+		if lt := col.Node.Type().LogicalType(); lt != nil && lt.Timestamp != nil {
+			switch {
+			case lt.Timestamp.Unit.Millis != nil:
+				return time.UnixMilli(n).UTC(), nil
+			case lt.Timestamp.Unit.Micros != nil:
+				return time.UnixMicro(n).UTC(), nil
+			default:
+				return time.Unix(0, n).UTC(), nil
+			}
+		}
+		return time.Unix(n, 0).UTC(), nil
+	case parquet.ByteArray:
+		return ParsePriceTime(string(v.ByteArray()))
+	}
+	return time.Time{}, fmt.Errorf("invalid time of type %s", v.Kind())
+}
+
+// ParsePriceTime accepts RFC 3339 times and Unix seconds.
+func ParsePriceTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PriceEvent is a stored price as streamed to subscribers.
+type PriceEvent struct {
+	ID       uint            `json:"id"`
+	Symbol   string          `json:"symbol"`
+	TenantID string          `json:"-"`
+	Value    decimal.Decimal `json:"value"`
+	Volume   decimal.Decimal `json:"volume"`
+	Time     time.Time       `json:"time"`
+}
+
+// AllSymbols subscribes to every instrument.
+const AllSymbols = "*"
+
+// PriceHub fans out new prices to subscribers. Publishing never blocks:
+// a subscriber whose buffer is full is dropped, and is expected to
+// reconnect and resume from the last price it received.
+type PriceHub struct {
+	mu   sync.RWMutex
+	subs map[*PriceSubscription]struct{}
+}
+
+func NewPriceHub() *PriceHub {
+	return &PriceHub{subs: map[*PriceSubscription]struct{}{}}
+}
+
+// PriceSubscription receives the prices of its symbols that are shared or
+// belong to its tenant.
+type PriceSubscription struct {
+	// C is closed when the subscription is closed or dropped.
+	C <-chan PriceEvent
+
+	ch       chan PriceEvent
+	hub      *PriceHub
+	tenantID string
+	dropped  atomic.Bool
+
+	mu      sync.RWMutex
+	all     bool
+	symbols map[string]bool
+}
+
+// Subscribe registers a subscriber with room for buffer pending events.
+// Symbols may include AllSymbols.
+func (h *PriceHub) Subscribe(tenantID string, symbols []string, buffer int) *PriceSubscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan PriceEvent, buffer)
+	s := &PriceSubscription{C: ch, ch: ch, hub: h, tenantID: tenantID, symbols: map[string]bool{}}
+	s.Add(symbols...)
+
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Publish hands e to every subscriber that wants it.
+func (h *PriceHub) Publish(e PriceEvent) {
+	var slow []*PriceSubscription
+
+	h.mu.RLock()
+	for s := range h.subs {
+		if !s.Wants(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, s := range slow {
+		s.dropped.Store(true)
+		s.Close()
+	}
+}
+
+// Subscribers returns the number of open subscriptions.
+func (h *PriceHub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+// Close unsubscribes s and closes C. It is safe to call more than once.
+func (s *PriceSubscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	// Events are only sent while the hub is read locked and s is
+	// registered, so closing here cannot race a send.
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.ch)
+	}
+}
+
+// Dropped reports whether s was closed because it fell behind.
+func (s *PriceSubscription) Dropped() bool {
+	return s.dropped.Load()
+}
+
+// Add subscribes to more symbols.
+func (s *PriceSubscription) Add(symbols ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, symbol := range symbols {
+		if symbol == AllSymbols {
+			s.all = true
+		} else {
+			s.symbols[symbol] = true
+		}
+	}
+}
+
+// Remove unsubscribes from symbols. Removing AllSymbols keeps the symbols
+// that were added by name.
+func (s *PriceSubscription) Remove(symbols ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, symbol := range symbols {
+		if symbol == AllSymbols {
+			s.all = false
+		} else {
+			delete(s.symbols, symbol)
+		}
+	}
+}
+
+// Symbols returns the subscribed symbols, sorted, with AllSymbols first if
+// present.
+func (s *PriceSubscription) Symbols() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	symbols := make([]string, 0, len(s.symbols)+1)
+	for symbol := range s.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	if s.all {
+		symbols = append([]string{AllSymbols}, symbols...)
+	}
+	return symbols
+}
+
+// Wants reports whether e is for s.
+func (s *PriceSubscription) Wants(e PriceEvent) bool {
+	if e.TenantID != "" && e.TenantID != s.tenantID {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.all || s.symbols[e.Symbol]
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceHub_Filters(t *testing.T) {
+	hub := NewPriceHub()
+	btc := hub.Subscribe("1", []string{"BTC-USD"}, 10)
+	all := hub.Subscribe("2", []string{AllSymbols}, 10)
+	assert.Equal(t, 2, hub.Subscribers())
+
+	hub.Publish(PriceEvent{ID: 1, Symbol: "BTC-USD"})
+	hub.Publish(PriceEvent{ID: 2, Symbol: "ETH-USD"})
+	hub.Publish(PriceEvent{ID: 3, Symbol: "BTC-USD", TenantID: "2"})
+
+	assert.Equal(t, uint(1), (<-btc.C).ID)
+	assert.Len(t, btc.C, 0)
+	assert.Equal(t, []uint{1, 2, 3}, []uint{(<-all.C).ID, (<-all.C).ID, (<-all.C).ID})
+
+	btc.Add("ETH-USD")
+	btc.Remove("BTC-USD")
+	assert.Equal(t, []string{"ETH-USD"}, btc.Symbols())
+	hub.Publish(PriceEvent{ID: 4, Symbol: "BTC-USD"})
+	hub.Publish(PriceEvent{ID: 5, Symbol: "ETH-USD"})
+	assert.Equal(t, uint(5), (<-btc.C).ID)
+
+	all.Add("SOL-USD")
+	assert.Equal(t, []string{AllSymbols, "SOL-USD"}, all.Symbols())
+
+	btc.Close()
+	btc.Close()
+	_, open := <-btc.C
+	assert.False(t, open)
+	assert.False(t, btc.Dropped())
+	assert.Equal(t, 1, hub.Subscribers())
+}
+
+func TestPriceHub_DropsSlowSubscribers(t *testing.T) {
+	hub := NewPriceHub()
+	slow := hub.Subscribe("", []string{AllSymbols}, 2)
+	fast := hub.Subscribe("", []string{AllSymbols}, 100)
+
+	for i := 1; i <= 3; i++ {
+		hub.Publish(PriceEvent{ID: uint(i), Symbol: "A"})
+	}
+
+	assert.True(t, slow.Dropped())
+	// Buffered events are still delivered before the channel ends
+	var got []uint
+	for e := range slow.C {
+		got = append(got, e.ID)
+	}
+	assert.Equal(t, []uint{1, 2}, got)
+	assert.Len(t, fast.C, 3)
+	assert.Equal(t, 1, hub.Subscribers())
+}
+
+func TestPriceHub_ConcurrentPublishAndClose(t *testing.T) {
+	hub := NewPriceHub()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := hub.Subscribe("", []string{"A"}, 1)
+				hub.Publish(PriceEvent{Symbol: "A"})
+				s.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, hub.Subscribers())
+}
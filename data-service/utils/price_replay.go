@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PriceReplay replays recorded ticks as live prices.
+type PriceReplay struct {
+	Ticks []PriceTick
+	// Interval between timestamps when Speed is 0 or the ticks have no
+	// time. With a positive Speed the gaps between the recorded times are
+	// divided by it.
+	Interval time.Duration
+	Speed    float64
+	// Loop starts over at the end of the file instead of stopping.
+	Loop bool
+}
+
+// replayStep is a group of ticks emitted together after a delay.
+type replayStep struct {
+	delay time.Duration
+	ticks []PriceTick
+}
+
+// schedule groups consecutive ticks that share a time and works out the
+// delay before each group.
+func (r *PriceReplay) schedule() []replayStep {
+	var steps []replayStep
+	for i, tick := range r.Ticks {
+		if i > 0 && tick.Time.Equal(r.Ticks[i-1].Time) {
+			last := &steps[len(steps)-1]
+			last.ticks = append(last.ticks, tick)
+			continue
+		}
+
+		delay := r.Interval
+		if r.Speed > 0 && i > 0 && !tick.Time.IsZero() && !r.Ticks[i-1].Time.IsZero() {
+			delay = time.Duration(float64(tick.Time.Sub(r.Ticks[i-1].Time)) / r.Speed)
+			if delay < 0 {
+				delay = 0
+			}
+		}
+		steps = append(steps, replayStep{delay: delay, ticks: []PriceTick{tick}})
+	}
+	return steps
+}
+
+// Run emits the ticks, stamped with the current time, until the file ends
+// or ctx is done.
+func (r *PriceReplay) Run(ctx context.Context, sink PriceSink) error {
+	steps := r.schedule()
+	if len(steps) == 0 {
+		return errors.New("nothing to replay")
+	}
+
+	for {
+		for _, step := range steps {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(step.delay):
+			}
+
+			now := time.Now()
+			for _, tick := range step.ticks {
+				tick.Time = now
+				if err := sink(tick); err != nil {
+					Logger.Warn("Replayed price refused", zap.String("symbol", tick.Symbol), zap.Error(err))
+				}
+			}
+		}
+		if !r.Loop {
+			Logger.Info("Price replay finished")
+			return nil
+		}
+	}
+}
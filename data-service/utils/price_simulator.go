@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+const year = 365 * 24 * time.Hour
+
+// PriceSimulator generates synthetic prices for every instrument once per
+// Interval. With the same seed it produces the same prices.
+//
+// The random walk model moves the price by a normally distributed fraction
+// with mean Drift and standard deviation Volatility per step. The gbm model
+// is geometric Brownian motion with Drift and Volatility given per year.
+type PriceSimulator struct {
+	Model        string
+	Drift        float64
+	Volatility   float64
+	InitialPrice float64
+	Interval     time.Duration
+	Symbols      func() ([]string, error)
+	LastPrice    func(symbol string) (float64, bool)
+
+	rng    *rand.Rand
+	prices map[string]float64
+}
+
+// NewPriceSimulator returns a simulator of model seeded with seed, or from
+// the clock if seed is 0.
+func NewPriceSimulator(model string, seed int64) *PriceSimulator {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &PriceSimulator{
+		Model:  model,
+		rng:    rand.New(rand.NewSource(seed)),
+		prices: map[string]float64{},
+	}
+}
+
+// Step advances the price of symbol by dt and returns it. An instrument
+// starts at its last stored price, or at InitialPrice.
+func (s *PriceSimulator) Step(symbol string, dt time.Duration) float64 {
+	last, ok := s.prices[symbol]
+	if !ok && s.LastPrice != nil {
+		last, ok = s.LastPrice(symbol)
+	}
+	if !ok || last <= 0 {
+		last = s.InitialPrice
+	}
+
+	z := s.rng.NormFloat64()
+	var next float64
+	if s.Model == PriceSourceGBM {
+		t := dt.Seconds() / year.Seconds()
+		next = last * math.Exp((s.Drift-s.Volatility*s.Volatility/2)*t+s.Volatility*math.Sqrt(t)*z)
+	} else {
+		// Reflect at zero so that the walk stays positive.
+		next = math.Abs(last * (1 + s.Drift + s.Volatility*z))
+	}
+
+	s.prices[symbol] = next
+	return next
+}
+
+// Run emits a price for every instrument each Interval.
+func (s *PriceSimulator) Run(ctx context.Context, sink PriceSink) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			symbols, err := s.Symbols()
+			if err != nil {
+				Logger.Error("Failed to load instruments", zap.Error(err))
+				continue
+			}
+			// Draw in a fixed order so that runs are reproducible.
+			sort.Strings(symbols)
+			for _, symbol := range symbols {
+				tick := PriceTick{Symbol: symbol, Value: decimal.NewFromFloat(s.Step(symbol, s.Interval)), Time: now}
+				if err := sink(tick); err != nil {
+					Logger.Warn("Simulated price refused", zap.String("symbol", symbol), zap.Error(err))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Price source kinds selectable with PRICE_SOURCE.
+const (
+	PriceSourceRandomWalk = "random_walk"
+	PriceSourceGBM        = "gbm"
+	PriceSourceReplay     = "replay"
+	PriceSourceWebhook    = "webhook"
+)
+
+// maxPriceValue bounds tick values to what the price columns hold.
+var maxPriceValue = decimal.New(1, 15)
+
+// PriceTick is one price of an instrument produced by a PriceSource. A zero
+// Time means now; Volume is optional. In JSON, Value and Volume may be
+// numbers or decimal strings.
+type PriceTick struct {
+	Symbol string          `json:"symbol"`
+	Value  decimal.Decimal `json:"value"`
+	Volume decimal.Decimal `json:"volume"`
+	Time   time.Time       `json:"time"`
+}
+
+// Validate checks that the tick names an instrument and has a positive
+// value and no negative volume.
+func (t PriceTick) Validate() error {
+	if strings.TrimSpace(t.Symbol) == "" {
+		return errors.New("missing symbol")
+	}
+	if !t.Value.IsPositive() || t.Value.GreaterThan(maxPriceValue) {
+		return fmt.Errorf("invalid value %v", t.Value)
+	}
+	if t.Volume.IsNegative() {
+		return fmt.Errorf("invalid volume %v", t.Volume)
+	}
+	return nil
+}
+
+// PriceSink stores a tick. It returns an error when the tick is refused,
+// e.g. because the instrument is unknown or not active.
+type PriceSink func(PriceTick) error
+
+// PriceSource produces ticks until ctx is done.
+type PriceSource interface {
+	Run(ctx context.Context, sink PriceSink) error
+}
+
+// PriceSourceConfig selects and configures the price source.
+type PriceSourceConfig struct {
+	Kind string
+	// Interval between generated or replayed ticks.
+	Interval time.Duration
+	// Simulator settings. A zero Seed seeds from the clock.
+	Seed         int64
+	Drift        float64
+	Volatility   float64
+	InitialPrice float64
+	// Replay settings. A positive ReplaySpeed replays at the file's own
+	// pace, sped up by that factor, instead of one timestamp per Interval.
+	ReplayFile  string
+	ReplayLoop  bool
+	ReplaySpeed float64
+	// Shared secret webhook deliveries are signed with.
+	WebhookSecret string
+}
+
+// NewPriceSource builds the configured source. Simulators generate prices
+// for the instruments returned by symbols and continue from lastPrice when
+// it knows the instrument.
+func NewPriceSource(cfg PriceSourceConfig, symbols func() ([]string, error), lastPrice func(string) (float64, bool)) (PriceSource, error) {
+	switch cfg.Kind {
+	case PriceSourceRandomWalk, PriceSourceGBM:
+		if cfg.Volatility < 0 || cfg.InitialPrice <= 0 || cfg.Interval <= 0 {
+			return nil, fmt.Errorf("invalid simulator settings")
+		}
+		sim := NewPriceSimulator(cfg.Kind, cfg.Seed)
+		sim.Drift = cfg.Drift
+		sim.Volatility = cfg.Volatility
+		sim.InitialPrice = cfg.InitialPrice
+		sim.Interval = cfg.Interval
+		sim.Symbols = symbols
+		sim.LastPrice = lastPrice
+		return sim, nil
+	case PriceSourceReplay:
+		ticks, err := LoadPriceFile(cfg.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		return &PriceReplay{Ticks: ticks, Interval: cfg.Interval, Speed: cfg.ReplaySpeed, Loop: cfg.ReplayLoop}, nil
+	case PriceSourceWebhook:
+		if cfg.WebhookSecret == "" {
+			return nil, fmt.Errorf("the webhook price source requires PRICE_WEBHOOK_SECRET")
+		}
+		return NewPriceWebhook(cfg.WebhookSecret), nil
+	}
+	return nil, fmt.Errorf("unknown price source %q", cfg.Kind)
+}
@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func init() {
+	Logger = zap.NewNop()
+}
+
+func simulate(model string, seed int64, steps int) []float64 {
+	sim := NewPriceSimulator(model, seed)
+	sim.Drift = 0.05
+	sim.Volatility = 0.4
+	sim.InitialPrice = 100
+	var prices []float64
+	for i := 0; i < steps; i++ {
+		prices = append(prices, sim.Step("BTC-USD", time.Minute))
+	}
+	return prices
+}
+
+func TestPriceSimulator_Deterministic(t *testing.T) {
+	for _, model := range []string{PriceSourceRandomWalk, PriceSourceGBM} {
+		a := simulate(model, 42, 500)
+		assert.Equal(t, a, simulate(model, 42, 500), model)
+		assert.NotEqual(t, a, simulate(model, 43, 500), model)
+		for _, p := range a {
+			assert.Greater(t, p, 0.0, model)
+		}
+	}
+}
+
+func TestPriceSimulator_GBMScale(t *testing.T) {
+	// A one-minute step of 40% annual volatility moves the price by well
+	// under a percent.
+	prices := simulate(PriceSourceGBM, 7, 100)
+	prev := 100.0
+	for _, p := range prices {
+		assert.InDelta(t, 1, p/prev, 0.01)
+		prev = p
+	}
+}
+
+func TestPriceSimulator_ContinuesFromLastPrice(t *testing.T) {
+	sim := NewPriceSimulator(PriceSourceRandomWalk, 1)
+	sim.InitialPrice = 100
+	sim.LastPrice = func(symbol string) (float64, bool) { return 5000, symbol == "ETH-USD" }
+
+	assert.InDelta(t, 5000, sim.Step("ETH-USD", time.Minute), 1e-9)
+	assert.InDelta(t, 100, sim.Step("SOL-USD", time.Minute), 1e-9)
+
+	sim.Volatility = 0.01
+	assert.InDelta(t, 5000, sim.Step("ETH-USD", time.Minute), 500)
+}
+
+func TestPriceSimulator_Run(t *testing.T) {
+	sim := NewPriceSimulator(PriceSourceGBM, 3)
+	sim.Volatility = 0.2
+	sim.InitialPrice = 10
+	sim.Interval = time.Millisecond
+	sim.Symbols = func() ([]string, error) { return []string{"B", "A"}, nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var got []PriceTick
+	sim.Run(ctx, func(tick PriceTick) error {
+		got = append(got, tick)
+		if len(got) == 4 {
+			cancel()
+		}
+		return nil
+	})
+
+	assert.Equal(t, []string{"A", "B", "A", "B"}, []string{got[0].Symbol, got[1].Symbol, got[2].Symbol, got[3].Symbol})
+	assert.Equal(t, got[0].Time, got[1].Time)
+}
+
+const priceCSV = `time,symbol,price,volume
+2024-01-01T00:00:00Z,BTC-USD,42000.5,1.5
+2024-01-01T00:00:00Z,ETH-USD,2300,
+1704067260,BTC-USD,42010,2
+`
+
+func TestParsePriceFiles(t *testing.T) {
+	ticks, err := ParsePriceCSV(strings.NewReader(priceCSV))
+	assert.NoError(t, err)
+	assert.Len(t, ticks, 3)
+	assert.Equal(t, PriceTick{Symbol: "BTC-USD", Value: decimal.NewFromInt(42010), Volume: decimal.NewFromInt(2), Time: time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC)}, ticks[2])
+	// Values are kept exactly as written
+	assert.Equal(t, "42000.5", ticks[0].Value.String())
+
+	ticks, err = ParsePriceJSON(strings.NewReader(`[{"symbol":"BTC-USD","value":1.5,"time":"2024-01-01T00:00:00Z"},{"symbol":"BTC-USD","value":"0.1"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, "1.5", ticks[0].Value.String())
+	assert.Equal(t, "0.1", ticks[1].Value.String())
+
+	ticks, err = ParsePriceNDJSON(strings.NewReader("{\"symbol\":\"A\",\"value\":1}\n\n{\"symbol\":\"B\",\"value\":2}\n"))
+	assert.NoError(t, err)
+	assert.Len(t, ticks, 2)
+
+	_, err = ParsePriceCSV(strings.NewReader("symbol,amount\nA,1\n"))
+	assert.Error(t, err)
+	_, err = ParsePriceCSV(strings.NewReader("symbol,value\nA,-1\n"))
+	assert.Error(t, err)
+	_, err = ParsePriceCSV(strings.NewReader("symbol,value,time\nA,1,yesterday\n"))
+	assert.Error(t, err)
+	_, err = ParsePriceCSV(strings.NewReader("symbol,value,volume\nA,1,-2\n"))
+	assert.Error(t, err)
+	_, err = ParsePriceNDJSON(strings.NewReader(`{"value":1}`))
+	assert.Error(t, err)
+}
+
+func TestPriceReplay_Schedule(t *testing.T) {
+	ticks, _ := ParsePriceCSV(strings.NewReader(priceCSV))
+
+	steps := (&PriceReplay{Ticks: ticks, Interval: time.Second}).schedule()
+	assert.Len(t, steps, 2)
+	assert.Len(t, steps[0].ticks, 2)
+	assert.Equal(t, time.Second, steps[1].delay)
+
+	// At 60x the recorded minute between the two timestamps takes a second
+	steps = (&PriceReplay{Ticks: ticks, Interval: time.Hour, Speed: 60}).schedule()
+	assert.Equal(t, time.Hour, steps[0].delay)
+	assert.Equal(t, time.Second, steps[1].delay)
+}
+
+func TestPriceReplay_Run(t *testing.T) {
+	ticks, _ := ParsePriceCSV(strings.NewReader(priceCSV))
+	replay := &PriceReplay{Ticks: ticks, Interval: time.Millisecond}
+
+	var got []string
+	err := replay.Run(context.Background(), func(tick PriceTick) error {
+		got = append(got, tick.Symbol)
+		assert.WithinDuration(t, time.Now(), tick.Time, time.Second)
+		return errors.New("refused ticks do not stop the replay")
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"BTC-USD", "ETH-USD", "BTC-USD"}, got)
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestPriceWebhook(t *testing.T) {
+	webhook := NewPriceWebhook("s3cret")
+	body := `{"symbol":"BTC-USD","value":42000}`
+	assert.True(t, webhook.Verify([]byte(body), sign("s3cret", body)))
+	assert.False(t, webhook.Verify([]byte(body), sign("other", body)))
+	assert.False(t, webhook.Verify([]byte(body), "garbage"))
+
+	ticks, err := ParsePriceTicks([]byte(body))
+	assert.NoError(t, err)
+	assert.Equal(t, ErrPriceSourceStopped, webhook.Deliver(ticks[0]))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan PriceTick, 1)
+	done := make(chan struct{})
+	go func() {
+		webhook.Run(ctx, func(tick PriceTick) error { received <- tick; return nil })
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return webhook.Deliver(ticks[0]) == nil }, time.Second, time.Millisecond)
+	assert.Equal(t, "42000", (<-received).Value.String())
+	cancel()
+	<-done
+
+	ticks, err = ParsePriceTicks([]byte(` [{"symbol":"A","value":1},{"symbol":"B","value":2}]`))
+	assert.NoError(t, err)
+	assert.Len(t, ticks, 2)
+	_, err = ParsePriceTicks([]byte(`{"symbol":"A","value":0}`))
+	assert.Error(t, err)
+}
+
+func TestNewPriceSource(t *testing.T) {
+	cfg := PriceSourceConfig{Kind: PriceSourceGBM, Interval: time.Minute, Volatility: 0.5, InitialPrice: 100}
+	source, err := NewPriceSource(cfg, nil, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &PriceSimulator{}, source)
+
+	cfg.InitialPrice = 0
+	_, err = NewPriceSource(cfg, nil, nil)
+	assert.Error(t, err)
+
+	_, err = NewPriceSource(PriceSourceConfig{Kind: PriceSourceWebhook}, nil, nil)
+	assert.Error(t, err)
+	_, err = NewPriceSource(PriceSourceConfig{Kind: PriceSourceReplay, ReplayFile: "missing.csv"}, nil, nil)
+	assert.Error(t, err)
+	_, err = NewPriceSource(PriceSourceConfig{Kind: "static"}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestReadPriceFile_ReportsInvalidRows(t *testing.T) {
+	type row struct {
+		line int
+		tick PriceTick
+		err  error
+	}
+	read := func(format, data string) ([]row, error) {
+		var rows []row
+		err := ReadPriceFile(strings.NewReader(data), format, func(line int, tick PriceTick, err error) error {
+			rows = append(rows, row{line, tick, err})
+			return nil
+		})
+		return rows, err
+	}
+
+	rows, err := read(PriceFormatCSV, "symbol,price,time\nA,1,1704067200\nB,x,1704067200\n\nC,2\n")
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 3) {
+		assert.NoError(t, rows[0].err)
+		assert.Equal(t, []int{2, 3, 5}, []int{rows[0].line, rows[1].line, rows[2].line})
+		assert.Error(t, rows[1].err)
+		assert.Error(t, rows[2].err)
+	}
+
+	rows, err = read(PriceFormatNDJSON, "{\"symbol\":\"A\",\"value\":1}\nnot json\n{\"symbol\":\"B\",\"value\":0}\n")
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 3) {
+		assert.NoError(t, rows[0].err)
+		assert.Error(t, rows[1].err)
+		assert.Error(t, rows[2].err)
+	}
+
+	_, err = read(PriceFormatCSV, "")
+	assert.Error(t, err)
+	_, err = read("xml", "<prices/>")
+	assert.Error(t, err)
+
+	format, gzipped, err := PriceFileFormat("history/BTC.NDJSON.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, PriceFormatNDJSON, format)
+	assert.True(t, gzipped)
+	_, _, err = PriceFileFormat("prices.xlsx")
+	assert.Error(t, err)
+}
+
+func TestParsePriceParquet(t *testing.T) {
+	type floatRow struct {
+		Symbol string    `parquet:"symbol"`
+		Price  float64   `parquet:"price"`
+		Volume *float64  `parquet:"volume,optional"`
+		Time   time.Time `parquet:"time,timestamp(millisecond)"`
+	}
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	two := 2.0
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[floatRow](&buf)
+	_, err := w.Write([]floatRow{
+		{Symbol: "BTC-USD", Price: 42000.5, Volume: &two, Time: at},
+		{Symbol: "BTC-USD", Price: 42001, Time: at.Add(time.Minute)},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	ticks, err := ParsePriceParquet(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, ticks, 2) {
+		assert.Equal(t, PriceTick{Symbol: "BTC-USD", Value: decimal.RequireFromString("42000.5"), Volume: decimal.NewFromInt(2), Time: at}, ticks[0])
+		assert.True(t, ticks[1].Volume.IsZero())
+		assert.True(t, ticks[1].Time.Equal(at.Add(time.Minute)))
+	}
+
+	// Values may be decimal strings and times Unix seconds
+	type stringRow struct {
+		Symbol string `parquet:"symbol"`
+		Value  string `parquet:"value"`
+		Time   int64  `parquet:"time"`
+	}
+	buf.Reset()
+	sw := parquet.NewGenericWriter[stringRow](&buf)
+	sw.Write([]stringRow{{Symbol: "ETH-USD", Value: "0.1", Time: at.Unix()}, {Symbol: "ETH-USD", Value: "-1", Time: at.Unix()}})
+	assert.NoError(t, sw.Close())
+
+	var valid []PriceTick
+	var invalid []int
+	err = ReadPriceFile(bytes.NewReader(buf.Bytes()), PriceFormatParquet, func(line int, tick PriceTick, err error) error {
+		if err != nil {
+			invalid = append(invalid, line)
+		} else {
+			valid = append(valid, tick)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, valid, 1) {
+		assert.Equal(t, "0.1", valid[0].Value.String())
+		assert.True(t, valid[0].Time.Equal(at))
+	}
+	assert.Equal(t, []int{2}, invalid)
+}
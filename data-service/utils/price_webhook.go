@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrPriceSourceStopped is returned for deliveries while the webhook
+// source is not running.
+var ErrPriceSourceStopped = errors.New("price source is not running")
+
+// PriceWebhook is a price source fed by an upstream provider that posts
+// ticks over HTTP. Deliveries are signed with HMAC-SHA256 of the body
+// using a shared secret.
+type PriceWebhook struct {
+	secret []byte
+
+	mu   sync.RWMutex
+	sink PriceSink
+}
+
+func NewPriceWebhook(secret string) *PriceWebhook {
+	return &PriceWebhook{secret: []byte(secret)}
+}
+
+// Run accepts deliveries until ctx is done.
+func (w *PriceWebhook) Run(ctx context.Context, sink PriceSink) error {
+	w.mu.Lock()
+	w.sink = sink
+	w.mu.Unlock()
+
+	<-ctx.Done()
+
+	w.mu.Lock()
+	w.sink = nil
+	w.mu.Unlock()
+	return nil
+}
+
+// Verify checks a "sha256=<hex>" signature of body.
+func (w *PriceWebhook) Verify(body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// Deliver hands a tick to the running source.
+func (w *PriceWebhook) Deliver(tick PriceTick) error {
+	w.mu.RLock()
+	sink := w.sink
+	w.mu.RUnlock()
+	if sink == nil {
+		return ErrPriceSourceStopped
+	}
+	return sink(tick)
+}
+
+// ParsePriceTicks decodes a webhook body holding one tick or an array of
+// them.
+func ParsePriceTicks(body []byte) ([]PriceTick, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		return ParsePriceJSON(bytes.NewReader(body))
+	}
+
+	var tick PriceTick
+	if err := json.Unmarshal(body, &tick); err != nil {
+		return nil, err
+	}
+	if err := tick.Validate(); err != nil {
+		return nil, fmt.Errorf("entry 0: %w", err)
+	}
+	return []PriceTick{tick}, nil
+}
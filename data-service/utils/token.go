@@ -0,0 +1,9 @@
+package utils
+
+import "strings"
+
+// IsJWT reports whether token has the shape of a compact JWS. Other access
+// tokens are opaque references that only introspection can resolve.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
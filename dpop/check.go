@@ -0,0 +1,74 @@
+package dpop
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Error codes for the WWW-Authenticate challenge of a rejected request.
+const (
+	CodeInvalidToken = "invalid_token"
+	CodeInvalidProof = "invalid_dpop_proof"
+	CodeUseNonce     = "use_dpop_nonce"
+)
+
+// Request is what a resource server checks for a request carrying an
+// access token.
+type Request struct {
+	Scheme      string // Authorization scheme, e.g. "DPoP" or "Bearer"
+	Proof       string // DPoP header
+	Method      string
+	URL         string
+	AccessToken string
+	JKT         string // cnf.jkt of the token, empty if it is not bound
+}
+
+// CheckError is a failed Check; Code goes into the challenge.
+type CheckError struct {
+	Code string
+	Err  error
+}
+
+func (e *CheckError) Error() string { return e.Err.Error() }
+
+func (e *CheckError) Unwrap() error { return e.Err }
+
+// Check enforces RFC 9449 for r: a bound token must use the DPoP scheme
+// with a proof for the method and URL, signed by the bound key, carrying a
+// nonce from NewNonce with key and a jti not in replay. Unbound tokens must
+// not use the DPoP scheme. The error is always a *CheckError.
+func Check(key []byte, replay *ReplayCache, r Request, now time.Time) error {
+	dpopScheme := strings.EqualFold(r.Scheme, "DPoP")
+	if r.JKT == "" {
+		if dpopScheme {
+			return &CheckError{CodeInvalidToken, errors.New("token is not DPoP-bound")}
+		}
+		return nil
+	}
+	if !dpopScheme {
+		return &CheckError{CodeInvalidToken, errors.New("DPoP-bound token sent as a bearer token")}
+	}
+
+	proof, err := VerifyProof(r.Proof, r.Method, r.URL, r.AccessToken, now)
+	if err != nil {
+		return &CheckError{CodeInvalidProof, err}
+	}
+	if proof.JKT != r.JKT {
+		return &CheckError{CodeInvalidProof, errors.New("proof key does not match the token")}
+	}
+	// Before the replay check, so a proof turned away for its nonce does
+	// not use up its jti.
+	if !ValidNonce(key, proof.Nonce, now) {
+		return &CheckError{CodeUseNonce, errors.New("fresh nonce required")}
+	}
+	if replay.Seen(proof.JTI, now) {
+		return &CheckError{CodeInvalidProof, errors.New("proof has been used before")}
+	}
+	return nil
+}
+
+// Challenge is the WWW-Authenticate header value for a CheckError code.
+func Challenge(code string) string {
+	return `DPoP error="` + code + `", algs="` + strings.Join(algorithms, " ") + `"`
+}
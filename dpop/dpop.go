@@ -0,0 +1,198 @@
+// Package dpop verifies DPoP proofs (RFC 9449) for the services that accept
+// sender-constrained tokens.
+package dpop
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// ProofMaxAge is how far a proof's iat may lie from the current time.
+const ProofMaxAge = 5 * time.Minute
+
+// ErrInvalidProof is returned for any proof that fails verification.
+var ErrInvalidProof = errors.New("invalid_dpop_proof")
+
+var algorithms = []string{"ES256", "ES384", "RS256", "PS256", "EdDSA"}
+
+// Proof holds the verified contents of a DPoP proof JWT (RFC 9449).
+type Proof struct {
+	JKT      string // thumbprint of the proof key
+	JTI      string
+	Nonce    string
+	IssuedAt time.Time
+}
+
+// VerifyProof checks the proof's signature against its embedded key and
+// that it was made for method and url at about now. When accessToken is not
+// empty the proof must carry its hash in "ath". Replay and nonce checks are
+// left to the caller.
+func VerifyProof(proof, method, rawURL, accessToken string, now time.Time) (*Proof, error) {
+	var jwk map[string]interface{}
+	token, err := jwt.Parse(proof, func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, errors.New("typ must be dpop+jwt")
+		}
+		jwk, _ = t.Header["jwk"].(map[string]interface{})
+		if jwk == nil {
+			return nil, errors.New("missing jwk header")
+		}
+		return PublicKeyFromJWK(jwk)
+	}, jwt.WithValidMethods(algorithms), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	htm, _ := claims["htm"].(string)
+	htu, _ := claims["htu"].(string)
+	jti, _ := claims["jti"].(string)
+	iat, _ := claims["iat"].(float64)
+	switch {
+	case jti == "":
+		return nil, fmt.Errorf("%w: missing jti", ErrInvalidProof)
+	case htm != method:
+		return nil, fmt.Errorf("%w: htm does not match", ErrInvalidProof)
+	case NormalizeHTU(htu) != NormalizeHTU(rawURL):
+		return nil, fmt.Errorf("%w: htu does not match", ErrInvalidProof)
+	}
+
+	issuedAt := time.Unix(int64(iat), 0)
+	if age := now.Sub(issuedAt); age > ProofMaxAge || age < -ProofMaxAge {
+		return nil, fmt.Errorf("%w: iat outside the accepted window", ErrInvalidProof)
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if ath, _ := claims["ath"].(string); ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return nil, fmt.Errorf("%w: ath does not match the access token", ErrInvalidProof)
+		}
+	}
+
+	jkt, err := JWKThumbprint(jwk)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+	nonce, _ := claims["nonce"].(string)
+	return &Proof{JKT: jkt, JTI: jti, Nonce: nonce, IssuedAt: issuedAt}, nil
+}
+
+// NormalizeHTU drops the query and fragment and lower-cases scheme and host,
+// as the htu comparison of RFC 9449 section 4.3 requires.
+func NormalizeHTU(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.RawQuery, u.Fragment = "", ""
+	return u.String()
+}
+
+// JWKThumbprint computes the RFC 7638 SHA-256 thumbprint of a public JWK.
+func JWKThumbprint(jwk map[string]interface{}) (string, error) {
+	var members []string
+	switch jwk["kty"] {
+	case "EC":
+		members = []string{"crv", "kty", "x", "y"}
+	case "RSA":
+		members = []string{"e", "kty", "n"}
+	case "OKP":
+		members = []string{"crv", "kty", "x"}
+	default:
+		return "", errors.New("unsupported jwk kty")
+	}
+
+	// Required members in lexicographic order, no whitespace.
+	parts := make([]string, len(members))
+	for i, m := range members {
+		v, ok := jwk[m].(string)
+		if !ok {
+			return "", fmt.Errorf("jwk is missing %q", m)
+		}
+		b, _ := json.Marshal(v)
+		parts[i] = fmt.Sprintf("%q:%s", m, b)
+	}
+	sum := sha256.Sum256([]byte("{" + strings.Join(parts, ",") + "}"))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// PublicKeyFromJWK decodes an EC, RSA or Ed25519 public JWK. Keys that carry
+// private members are rejected.
+func PublicKeyFromJWK(jwk map[string]interface{}) (crypto.PublicKey, error) {
+	if _, ok := jwk["d"]; ok {
+		return nil, errors.New("jwk must not contain a private key")
+	}
+	member := func(name string) ([]byte, error) {
+		s, _ := jwk[name].(string)
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid jwk member %q", name)
+		}
+		return b, nil
+	}
+
+	switch jwk["kty"] {
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk["crv"] {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, errors.New("unsupported jwk crv")
+		}
+		x, err := member("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := member("y")
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("jwk point is not on the curve")
+		}
+		return key, nil
+	case "RSA":
+		n, err := member("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := member("e")
+		if err != nil {
+			return nil, err
+		}
+		if len(n) < 256 {
+			return nil, errors.New("rsa keys must be at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if jwk["crv"] != "Ed25519" {
+			return nil, errors.New("unsupported jwk crv")
+		}
+		x, err := member("x")
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid jwk member \"x\"")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, errors.New("unsupported jwk kty")
+}
@@ -0,0 +1,161 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newProof(t *testing.T, key *ecdsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	proof, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return proof
+}
+
+func TestJWKThumbprint_RFC7638Example(t *testing.T) {
+	jwk := map[string]interface{}{
+		"kty": "RSA",
+		"n":   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		"e":   "AQAB",
+		"alg": "RS256",
+		"kid": "2011-04-29",
+	}
+	jkt, err := JWKThumbprint(jwk)
+	assert.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jkt)
+}
+
+func TestVerifyProof(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	sum := sha256.Sum256([]byte("access"))
+	claims := jwt.MapClaims{
+		"htm": "POST",
+		"htu": "https://trade.example.com/trade/place",
+		"iat": now.Unix(),
+		"jti": "proof-1",
+		"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+	}
+	proof := newProof(t, key, claims)
+
+	p, err := VerifyProof(proof, "POST", "https://TRADE.example.com/trade/place?x=1", "access", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "proof-1", p.JTI)
+	assert.NotEmpty(t, p.JKT)
+
+	_, err = VerifyProof(proof, "GET", "https://trade.example.com/trade/place", "access", now)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+	_, err = VerifyProof(proof, "POST", "https://trade.example.com/other", "access", now)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+	_, err = VerifyProof(proof, "POST", "https://trade.example.com/trade/place", "stolen", now)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+	_, err = VerifyProof(proof, "POST", "https://trade.example.com/trade/place", "access", now.Add(time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	// A proof signed by another key than the embedded jwk is rejected
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forged := newProof(t, other, claims)
+	parts := strings.Split(forged, ".")
+	_, err = VerifyProof(strings.Split(proof, ".")[0]+"."+parts[1]+"."+parts[2], "POST", "https://trade.example.com/trade/place", "access", now)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+}
+
+func TestReplayCache(t *testing.T) {
+	cache := NewReplayCache()
+	now := time.Now()
+	assert.False(t, cache.Seen("a", now))
+	assert.True(t, cache.Seen("a", now))
+	assert.False(t, cache.Seen("b", now.Add(time.Minute)))
+
+	// Expired entries no longer count and are swept out eventually
+	later := now.Add(replayTTL + time.Second)
+	assert.False(t, cache.Seen("a", later))
+	assert.Len(t, cache.seen, 2)
+	assert.False(t, cache.Seen("c", later.Add(replayTTL+time.Second)))
+	assert.Len(t, cache.seen, 1)
+}
+
+func TestNonce(t *testing.T) {
+	key := []byte("secret")
+	now := time.Now()
+	nonce := NewNonce(key, now)
+	assert.True(t, ValidNonce(key, nonce, now.Add(time.Minute)))
+	assert.False(t, ValidNonce(key, nonce, now.Add(NonceMaxAge+time.Second)))
+	assert.False(t, ValidNonce([]byte("other"), nonce, now))
+	assert.False(t, ValidNonce(key, "garbage", now))
+}
+
+func TestCheck(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("secret")
+	replay := NewReplayCache()
+	now := time.Now()
+	sum := sha256.Sum256([]byte("access"))
+	proof := func(jti, nonce string) string {
+		return newProof(t, key, jwt.MapClaims{
+			"htm":   "GET",
+			"htu":   "https://auth.example.com/auth/me",
+			"iat":   now.Unix(),
+			"jti":   jti,
+			"ath":   base64.RawURLEncoding.EncodeToString(sum[:]),
+			"nonce": nonce,
+		})
+	}
+	jkt, err := JWKThumbprint(map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	})
+	assert.NoError(t, err)
+	req := Request{Scheme: "DPoP", Method: "GET", URL: "https://auth.example.com/auth/me", AccessToken: "access", JKT: jkt}
+	code := func(r Request) string {
+		err := Check(secret, replay, r, now)
+		if err == nil {
+			return ""
+		}
+		var checkErr *CheckError
+		assert.ErrorAs(t, err, &checkErr)
+		return checkErr.Code
+	}
+
+	// Unbound tokens go through as bearer tokens only
+	assert.Equal(t, "", code(Request{Scheme: "Bearer"}))
+	assert.Equal(t, CodeInvalidToken, code(Request{Scheme: "DPoP"}))
+
+	bearer := req
+	bearer.Scheme = "Bearer"
+	assert.Equal(t, CodeInvalidToken, code(bearer))
+
+	// A proof without a nonce asks for one and does not use up its jti
+	req.Proof = proof("p1", "")
+	assert.Equal(t, CodeUseNonce, code(req))
+	req.Proof = proof("p1", NewNonce([]byte("other"), now))
+	assert.Equal(t, CodeUseNonce, code(req))
+
+	req.Proof = proof("p1", NewNonce(secret, now))
+	assert.Equal(t, "", code(req))
+	assert.Equal(t, CodeInvalidProof, code(req))
+
+	other := req
+	other.JKT = "someone-else"
+	other.Proof = proof("p2", NewNonce(secret, now))
+	assert.Equal(t, CodeInvalidProof, code(other))
+}
@@ -0,0 +1,38 @@
+package dpop
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+)
+
+// NonceMaxAge is how long a nonce handed out by NewNonce is accepted.
+const NonceMaxAge = 5 * time.Minute
+
+// NewNonce returns a stateless nonce: the issue time authenticated with
+// an HMAC over key.
+func NewNonce(key []byte, now time.Time) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(now.Unix()))
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return base64.RawURLEncoding.EncodeToString(append(msg, mac.Sum(nil)[:16]...))
+}
+
+// ValidNonce reports whether nonce came from NewNonce with key and
+// has not expired.
+func ValidNonce(key []byte, nonce string, now time.Time) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(raw) != 24 {
+		return false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(raw[:8])
+	if !hmac.Equal(raw[8:], mac.Sum(nil)[:16]) {
+		return false
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(raw[:8])), 0)
+	return !issued.After(now.Add(time.Minute)) && now.Sub(issued) <= NonceMaxAge
+}
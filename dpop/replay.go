@@ -0,0 +1,43 @@
+package dpop
+
+import (
+	"sync"
+	"time"
+)
+
+// replayTTL is how long a jti is remembered: past it, the proof's iat can
+// no longer pass the ProofMaxAge check.
+const replayTTL = 2 * ProofMaxAge
+
+// ReplayCache remembers proof jti values until they could no longer pass
+// the iat check. It is per process.
+type ReplayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{seen: map[string]time.Time{}}
+}
+
+// Seen records jti and reports whether it had been recorded before. Expired
+// entries are ignored when looked up and dropped by a sweep that runs at
+// most once per ProofMaxAge, so a call is O(1) amortized.
+func (c *ReplayCache) Seen(jti string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.After(c.nextSweep) {
+		for k, exp := range c.seen {
+			if now.After(exp) {
+				delete(c.seen, k)
+			}
+		}
+		c.nextSweep = now.Add(ProofMaxAge)
+	}
+	if exp, ok := c.seen[jti]; ok && !now.After(exp) {
+		return true
+	}
+	c.seen[jti] = now.Add(replayTTL)
+	return false
+}
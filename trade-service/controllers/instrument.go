@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// instrumentTTL is how long the instruments of data-service are cached.
+// Tick sizes and precisions seldom change.
+const instrumentTTL = time.Minute
+
+// instrument is what trade-service needs to know of a data-service
+// instrument to validate trades.
+type instrument struct {
+	Symbol            string          `json:"symbol"`
+	TickSize          decimal.Decimal `json:"tick_size"`
+	QuantityPrecision int32           `json:"quantity_precision"`
+}
+
+// instrumentList is the cached list of the instruments a tenant sees.
+type instrumentList struct {
+	bySymbol map[string]instrument
+	expires  time.Time
+}
+
+var (
+	instrumentsMu sync.Mutex
+	// instruments holds an instrumentList per tenant ID: besides the shared
+	// instruments, every tenant sees its own.
+	instruments = map[string]instrumentList{}
+)
+
+// fetchInstrument returns the instrument of symbol as seen by tenantID,
+// whose token is given. Delisted instruments are unknown, as data-service
+// does not list them.
+func fetchInstrument(token, tenantID, symbol string) (instrument, error) {
+	instrumentsMu.Lock()
+	defer instrumentsMu.Unlock()
+
+	list, ok := instruments[tenantID]
+	if !ok || time.Now().After(list.expires) {
+		var body struct {
+			Instruments []instrument `json:"instruments"`
+		}
+		if err := dataServiceGet(token, "/data/instruments", &body); err != nil {
+			return instrument{}, err
+		}
+		list = instrumentList{bySymbol: map[string]instrument{}, expires: time.Now().Add(instrumentTTL)}
+		for _, i := range body.Instruments {
+			list.bySymbol[i.Symbol] = i
+		}
+		instruments[tenantID] = list
+	}
+
+	i, ok := list.bySymbol[symbol]
+	if !ok {
+		return instrument{}, errUnknownInstrument
+	}
+	return i, nil
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/trade-service/utils"
+	"github.com/shopspring/decimal"
+)
+
+// lowestPriceWindow is how far back data-service looks for the lowest
+// price.
+const lowestPriceWindow = 24 * time.Hour
+
+// lowestPrice is a cached lowest price of an instrument for a tenant, and
+// when it was quoted. Pending entries are being fetched from data-service;
+// events that arrive meanwhile are kept in them (seen) so that none is
+// lost.
+type lowestPrice struct {
+	value   decimal.Decimal
+	at      time.Time
+	pending bool
+	seen    bool
+}
+
+// The local view of the lowest prices, by symbol and tenant. It is only
+// kept while price events are received: the lowest price of the window
+// then changes only when a lower price is created or when it leaves the
+// window.
+var (
+	lowestMu     sync.Mutex
+	lowestLive   bool
+	lowestPrices = map[string]map[string]lowestPrice{}
+)
+
+// PriceEventsConnected switches the local view on or off. It is emptied
+// either way, as events may have been missed.
+func PriceEventsConnected(connected bool) {
+	lowestMu.Lock()
+	defer lowestMu.Unlock()
+	lowestLive = connected
+	lowestPrices = map[string]map[string]lowestPrice{}
+}
+
+// ApplyPriceEvent lowers the cached lowest prices a new price undercuts:
+// those of every tenant for shared prices, or of its own tenant.
+func ApplyPriceEvent(e utils.PriceEvent) {
+	lowestMu.Lock()
+	defer lowestMu.Unlock()
+	for tenantID, cached := range lowestPrices[e.Symbol] {
+		if e.TenantID != "" && e.TenantID != tenantID {
+			continue
+		}
+		if time.Since(e.Time) >= lowestPriceWindow {
+			continue
+		}
+		if (cached.pending && !cached.seen) || e.Value.LessThanOrEqual(cached.value) {
+			cached.value, cached.at, cached.seen = e.Value, e.Time, true
+			lowestPrices[e.Symbol][tenantID] = cached
+		}
+	}
+}
+
+// cachedLowestPrice returns the lowest price of symbol for a tenant from
+// the local view when it is current, and from data-service otherwise.
+func cachedLowestPrice(token, symbol, tenantID string) (decimal.Decimal, error) {
+	lowestMu.Lock()
+	cached, ok := lowestPrices[symbol][tenantID]
+	if ok && !cached.pending && time.Since(cached.at) < lowestPriceWindow {
+		lowestMu.Unlock()
+		return cached.value, nil
+	}
+	if lowestLive && !(ok && cached.pending) {
+		if lowestPrices[symbol] == nil {
+			lowestPrices[symbol] = map[string]lowestPrice{}
+		}
+		lowestPrices[symbol][tenantID] = lowestPrice{pending: true}
+	}
+	lowestMu.Unlock()
+
+	value, at, err := fetchLowestPrice(token, symbol)
+
+	lowestMu.Lock()
+	defer lowestMu.Unlock()
+	cached, ok = lowestPrices[symbol][tenantID]
+	if err != nil {
+		if ok && cached.pending {
+			delete(lowestPrices[symbol], tenantID)
+		}
+		return decimal.Zero, err
+	}
+	if ok && cached.pending {
+		if cached.seen && cached.value.LessThan(value) {
+			value, at = cached.value, cached.at
+		}
+		lowestPrices[symbol][tenantID] = lowestPrice{value: value, at: at}
+	}
+	return value, nil
+}
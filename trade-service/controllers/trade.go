@@ -2,10 +2,14 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/RanggaNehemia/golang-microservices/trade-service/database"
@@ -14,65 +18,234 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 )
 
+// TradeInput is the body of a trade. Price and quantity may be JSON
+// numbers or, to avoid any binary rounding on the client, decimal strings.
 type TradeInput struct {
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity"`
+	Symbol   string          `json:"symbol"`
+	Price    decimal.Decimal `json:"price"`
+	Quantity decimal.Decimal `json:"quantity"`
 }
 
-func fetchLowestPrice() (float64, error) {
-	token, err := utils.GetMachineToken()
-	if err != nil {
-		return 0, err
-	}
+// errUnknownInstrument is returned when data-service does not know the
+// symbol of a trade.
+var errUnknownInstrument = errors.New("unknown instrument")
 
-	req, _ := http.NewRequest("GET", os.Getenv("DATA_SERVICE_URL")+"/data/lowest", nil)
+// dataServiceGet decodes the JSON response of a data-service endpoint into
+// out, calling it with a token exchanged for the user's.
+func dataServiceGet(token, path string, out interface{}) error {
+	req, _ := http.NewRequest("GET", os.Getenv("DATA_SERVICE_URL")+path, nil)
 	req.Header.Set("Authorization", "Bearer "+token)
 
 	client := http.Client{Timeout: 5 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return errUnknownInstrument
+	}
 	if resp.StatusCode != 200 {
-		utils.Logger.Error("Error on fetching lowest price")
-		return 0, fmt.Errorf("error on fetching lowest price: %d", resp.StatusCode)
+		return fmt.Errorf("data-service returned %d for %s", resp.StatusCode, req.URL.Path)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		utils.Logger.Error("Failed to parse JSON", zap.Error(err))
+		return fmt.Errorf("failed to parse JSON: %w", err)
 	}
+	return nil
+}
 
+// fetchLowestPrice returns the lowest price of an instrument and when it
+// was quoted.
+func fetchLowestPrice(token, symbol string) (decimal.Decimal, time.Time, error) {
 	var body struct {
-		Value float64 `json:"Value"`
+		Value     decimal.Decimal `json:"Value"`
+		CreatedAt time.Time       `json:"CreatedAt"`
 	}
+	err := dataServiceGet(token, "/data/"+url.PathEscape(symbol)+"/lowest", &body)
+	return body.Value, body.CreatedAt, err
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
-		utils.Logger.Error("Failed to parse JSON", zap.Error(err))
-		return 0, fmt.Errorf("failed to parse JSON: %w", err)
+// priceStats is the part of data-service's /data/stats response the trade
+// checks use. The fields are null when the window holds no price.
+type priceStats struct {
+	Count  int64               `json:"count"`
+	Mean   decimal.NullDecimal `json:"mean"`
+	StdDev decimal.NullDecimal `json:"stddev"`
+	VWAP   decimal.NullDecimal `json:"vwap"`
+	Last   decimal.NullDecimal `json:"last"`
+}
+
+// fetchPriceStats returns the statistics of an instrument over a window.
+func fetchPriceStats(token, symbol string, window time.Duration) (priceStats, error) {
+	var stats priceStats
+	q := url.Values{"symbol": {symbol}, "window": {window.String()}}
+	err := dataServiceGet(token, "/data/stats?"+q.Encode(), &stats)
+	return stats, err
+}
+
+// priceRules are the optional checks of a trade price against recent
+// market data. A zero limit disables its check.
+type priceRules struct {
+	// Window is how far back the statistics reach.
+	Window time.Duration
+	// MaxDeviation is how far, in percent, a price may stray from the
+	// VWAP of the window, or from the last price when no volume was
+	// reported.
+	MaxDeviation float64
+	// MaxStdDevs is how many standard deviations a price may lie from the
+	// mean of the window.
+	MaxStdDevs float64
+}
+
+func loadPriceRules() priceRules {
+	rules := priceRules{Window: 24 * time.Hour}
+	if d, err := time.ParseDuration(os.Getenv("TRADE_STATS_WINDOW")); err == nil && d > 0 {
+		rules.Window = d
+	}
+	rules.MaxDeviation, _ = strconv.ParseFloat(os.Getenv("TRADE_MAX_DEVIATION_PERCENT"), 64)
+	rules.MaxStdDevs, _ = strconv.ParseFloat(os.Getenv("TRADE_MAX_STDDEVS"), 64)
+	return rules
+}
+
+func (r priceRules) enabled() bool {
+	return r.MaxDeviation > 0 || r.MaxStdDevs > 0
+}
+
+// check returns why price breaks a rule, or "" when it does not. Windows
+// without prices pass. The limits are tolerances, so the checks work in
+// floating point.
+func (r priceRules) check(price decimal.Decimal, stats priceStats) string {
+	if stats.Count == 0 {
+		return ""
+	}
+	p := price.InexactFloat64()
+	if r.MaxDeviation > 0 {
+		reference := stats.Last
+		if stats.VWAP.Valid {
+			reference = stats.VWAP
+		}
+		if ref := reference.Decimal.InexactFloat64(); reference.Valid && ref > 0 {
+			if deviation := math.Abs(p-ref) / ref * 100; deviation > r.MaxDeviation {
+				return fmt.Sprintf("Price deviates %.2f%% from the reference price %s, more than the allowed %.2f%%", deviation, reference.Decimal, r.MaxDeviation)
+			}
+		}
+	}
+	if r.MaxStdDevs > 0 && stats.Mean.Valid && stats.StdDev.Valid && stats.StdDev.Decimal.IsPositive() {
+		mean, stddev := stats.Mean.Decimal.InexactFloat64(), stats.StdDev.Decimal.InexactFloat64()
+		if n := math.Abs(p-mean) / stddev; n > r.MaxStdDevs {
+			return fmt.Sprintf("Price lies %.1f standard deviations from the mean price %.2f, more than the allowed %.1f", n, mean, r.MaxStdDevs)
+		}
+	}
+	return ""
+}
+
+// mfaTradeThreshold is the notional (price * quantity) above which a trade
+// requires a token obtained with multi-factor authentication. 0 disables it.
+func mfaTradeThreshold() decimal.Decimal {
+	v, err := decimal.NewFromString(os.Getenv("MFA_TRADE_THRESHOLD"))
+	if err != nil {
+		return decimal.Zero
 	}
+	return v
+}
 
-	return body.Value, nil
+// hasMFA reports whether the caller's token carries the "mfa" amr value.
+func hasMFA(c *gin.Context) bool {
+	amr, _ := c.Get("amr")
+	values, _ := amr.([]interface{})
+	for _, v := range values {
+		if v == "mfa" {
+			return true
+		}
+	}
+	return false
 }
 
 func PlaceTrade(c *gin.Context) {
 	var input TradeInput
-	if err := c.ShouldBindJSON(&input); err != nil || input.Price <= 0 {
+	if err := c.ShouldBindJSON(&input); err != nil || !input.Price.IsPositive() {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trade price"})
 		return
 	}
+	if !input.Quantity.IsPositive() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trade quantity"})
+		return
+	}
+	input.Symbol = strings.ToUpper(strings.TrimSpace(input.Symbol))
+	if input.Symbol == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Symbol is required"})
+		return
+	}
 
-	lowestPrice, err := fetchLowestPrice()
+	if threshold := mfaTradeThreshold(); threshold.IsPositive() && input.Price.Mul(input.Quantity).GreaterThan(threshold) && !hasMFA(c) {
+		utils.Logger.Warn("MFA required for high-value trade")
+		c.JSON(http.StatusForbidden, gin.H{"error": "mfa_required", "message": "Trades above the MFA threshold require a multi-factor authenticated token"})
+		return
+	}
+
+	token, err := utils.ExchangeUserToken(c.GetString("access_token"))
+	if err != nil {
+		utils.Logger.Error("Token exchange failed", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not authorize the data request"})
+		return
+	}
+
+	instrument, err := fetchInstrument(token, c.GetString("tenant_id"), input.Symbol)
+	if errors.Is(err, errUnknownInstrument) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown instrument"})
+		return
+	}
+	if err != nil {
+		utils.Logger.Error("Error on fetching instrument", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch instrument"})
+		return
+	}
+	if !input.Price.Mod(instrument.TickSize).IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Price must be a multiple of the tick size " + instrument.TickSize.String()})
+		return
+	}
+	if !input.Quantity.Equal(input.Quantity.Truncate(instrument.QuantityPrecision)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Quantity may have at most %d decimal places", instrument.QuantityPrecision)})
+		return
+	}
+
+	lowestPrice, err := cachedLowestPrice(token, input.Symbol, c.GetString("tenant_id"))
+	if errors.Is(err, errUnknownInstrument) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown instrument"})
+		return
+	}
 	if err != nil {
 		utils.Logger.Error("Error on fetching lowest price", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch lowest price"})
 		return
 	}
 
-	if input.Price < lowestPrice/2 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Price must be more than or equals to %.2f", lowestPrice/2)})
+	// Compared as price * 2 so that no division can round.
+	if input.Price.Mul(decimal.NewFromInt(2)).LessThan(lowestPrice) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Price must be more than or equals to " + lowestPrice.Div(decimal.NewFromInt(2)).String()})
 		return
 	}
 
+	if rules := loadPriceRules(); rules.enabled() {
+		stats, err := fetchPriceStats(token, input.Symbol, rules.Window)
+		if err != nil {
+			utils.Logger.Error("Error on fetching price stats", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not validate price"})
+			return
+		}
+		if reason := rules.check(input.Price, stats); reason != "" {
+			utils.Logger.Info("Trade price rejected", zap.String("symbol", input.Symbol), zap.String("reason", reason))
+			c.JSON(http.StatusBadRequest, gin.H{"error": reason})
+			return
+		}
+	}
+
 	userIDVal, exists := c.Get("user_id")
 	if !exists {
 		utils.Logger.Warn("Missing user token")
@@ -97,7 +270,9 @@ func PlaceTrade(c *gin.Context) {
 
 	// Now use userID (as uint) safely
 	trade := models.Trade{
+		TenantID: c.GetString("tenant_id"),
 		UserID:   userID,
+		Symbol:   input.Symbol,
 		Price:    input.Price,
 		Quantity: input.Quantity,
 	}
@@ -108,6 +283,34 @@ func PlaceTrade(c *gin.Context) {
 		return
 	}
 
-	utils.Logger.Info("Trade placed", zap.Uint("trade", trade.ID))
+	utils.Logger.Info("Trade placed", zap.Uint("trade", trade.ID), zap.String("symbol", trade.Symbol), zap.String("tenant_id", trade.TenantID))
 	c.JSON(http.StatusOK, gin.H{"message": "Trade placed", "trade": trade})
 }
+
+// ListTrades returns the caller's trades in their tenant, newest first.
+func ListTrades(c *gin.Context) {
+	var trades []models.Trade
+	err := database.DB.Scopes(models.TenantScope(c.GetString("tenant_id"))).
+		Where("user_id = ?", c.GetString("user_id")).
+		Order("created_at DESC").Find(&trades).Error
+	if err != nil {
+		utils.Logger.Error("Failed to list trades", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trades"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"trades": trades})
+}
+
+// GetTrade returns one of the caller's trades. Trades of other users or
+// tenants are reported as not found.
+func GetTrade(c *gin.Context) {
+	var trade models.Trade
+	err := database.DB.Scopes(models.TenantScope(c.GetString("tenant_id"))).
+		Where("user_id = ?", c.GetString("user_id")).
+		First(&trade, "id = ?", c.Param("id")).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trade not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"trade": trade})
+}
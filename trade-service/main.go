@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"os"
 
+	"github.com/RanggaNehemia/golang-microservices/trade-service/controllers"
 	"github.com/RanggaNehemia/golang-microservices/trade-service/database"
+	"github.com/RanggaNehemia/golang-microservices/trade-service/middleware"
 	"github.com/RanggaNehemia/golang-microservices/trade-service/routes"
 	"github.com/RanggaNehemia/golang-microservices/trade-service/tracing"
 	"github.com/RanggaNehemia/golang-microservices/trade-service/utils"
@@ -21,6 +24,17 @@ func main() {
 	utils.InitLogger()
 
 	database.InitDB()
+	middleware.Init()
+
+	// Keep a local view of the lowest prices from data-service's events,
+	// published in its database.
+	if dsn := os.Getenv("PRICE_EVENTS_DATABASE_URL"); dsn != "" {
+		channel := os.Getenv("EVENTS_CHANNEL")
+		if channel == "" {
+			channel = "data_events"
+		}
+		go utils.ListenPriceEvents(context.Background(), dsn, channel, controllers.PriceEventsConnected, controllers.ApplyPriceEvent)
+	}
 
 	router := gin.Default()
 
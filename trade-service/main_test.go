@@ -0,0 +1,411 @@
+// trade-service/main_test.go
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RanggaNehemia/golang-microservices/dpop"
+	"github.com/RanggaNehemia/golang-microservices/trade-service/controllers"
+	"github.com/RanggaNehemia/golang-microservices/trade-service/database"
+	"github.com/RanggaNehemia/golang-microservices/trade-service/middleware"
+	"github.com/RanggaNehemia/golang-microservices/trade-service/models"
+	"github.com/RanggaNehemia/golang-microservices/trade-service/routes"
+	"github.com/RanggaNehemia/golang-microservices/trade-service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/joho/godotenv"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSecret = "trade-service-test-secret"
+	testAPIKey = "tsk_0123456789ab_secret"
+)
+
+var router *gin.Engine
+
+// lowestCalls counts the requests for the lowest price of BTC-USD.
+var lowestCalls atomic.Int32
+
+func TestMain(m *testing.M) {
+	_ = godotenv.Load(".env.test")
+	utils.InitLogger()
+
+	// Stand-in for auth-service and data-service: every token is active,
+	// every exchange succeeds, BTC-USD trades in ticks of 0.5 and
+	// quantities of 0.01, its lowest price is 100 and its recent prices
+	// average 110 with a VWAP of 112. Exchanged tokens name the user's
+	// tenant, and only tenant 2 has the instrument ACME, lowest at 50.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/introspect":
+			if id, secret, ok := r.BasicAuth(); !ok || id != "trade-service" || secret != "tradeservicesecret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+				return
+			}
+			if r.FormValue("token") == "tsk_rate_limited" {
+				w.WriteHeader(http.StatusTooManyRequests)
+				json.NewEncoder(w).Encode(map[string]string{"error": "rate_limited"})
+				return
+			}
+			if r.FormValue("token") == testAPIKey {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"active": true, "sub": "9", "tenant_id": "1", "amr": []string{"api_key"},
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]bool{"active": r.FormValue("token") != "tsk_revoked"})
+		case "/oauth/token":
+			token := "exchanged"
+			if t, _, err := jwt.NewParser().ParseUnverified(r.FormValue("subject_token"), jwt.MapClaims{}); err == nil {
+				if tenantID, _ := t.Claims.(jwt.MapClaims)["tenant_id"].(string); tenantID != "" {
+					token += "-" + tenantID
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token, "expires_in": 300})
+		case "/data/BTC-USD/lowest":
+			lowestCalls.Add(1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"Value": "100", "CreatedAt": time.Now().Add(-time.Hour)})
+		case "/data/instruments":
+			instruments := []map[string]interface{}{
+				{"symbol": "BTC-USD", "tick_size": "0.5", "quantity_precision": 2},
+			}
+			if r.Header.Get("Authorization") == "Bearer exchanged-2" {
+				instruments = append(instruments, map[string]interface{}{"symbol": "ACME", "tick_size": "0.01", "quantity_precision": 0})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"instruments": instruments})
+		case "/data/ACME/lowest":
+			if r.Header.Get("Authorization") != "Bearer exchanged-2" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"Value": "50", "CreatedAt": time.Now().Add(-time.Hour)})
+		case "/data/stats":
+			if r.URL.Query().Get("symbol") != "BTC-USD" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"count": 10, "mean": "110", "stddev": "5", "vwap": "112", "last": "115",
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	os.Setenv("SECRET_KEY", testSecret)
+	os.Setenv("WEB_CLIENT_ID", "webclient")
+	os.Setenv("TRADE_SERVICE_CLIENT_ID", "trade-service")
+	os.Setenv("TRADE_SERVICE_CLIENT_SECRET", "tradeservicesecret")
+	os.Setenv("AUTH_URL", upstream.URL)
+	os.Setenv("DATA_SERVICE_URL", upstream.URL)
+	os.Setenv("PUBLIC_URL", "https://trade.example.com")
+	middleware.Init()
+
+	database.InitTestDB()
+	defer database.CloseTestDB()
+
+	gin.SetMode(gin.TestMode)
+	router = gin.New()
+	routes.RegisterTradeRoutes(router)
+
+	os.Exit(m.Run())
+}
+
+func userToken(t *testing.T, userID, tenantID string) string {
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"aud": "webclient",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	if tenantID != "" {
+		claims["tenant_id"] = tenantID
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(testSecret))
+	assert.NoError(t, err)
+	return token
+}
+
+func call(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestTrades_TenantIsolation(t *testing.T) {
+	database.DB.Exec("DELETE FROM trades")
+
+	// User 7 exists in tenant 1; the token of tenant 2 has the same subject
+	// to show that the tenant, not only the user, scopes every query.
+	tenant1 := userToken(t, "7", "1")
+	tenant2 := userToken(t, "7", "2")
+
+	w := call(http.MethodPost, "/trade/place", tenant1, map[string]interface{}{"symbol": "btc-usd", "price": 120, "quantity": 3})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var placed struct{ Trade models.Trade }
+	json.Unmarshal(w.Body.Bytes(), &placed)
+	assert.Equal(t, "1", placed.Trade.TenantID)
+	assert.Equal(t, "BTC-USD", placed.Trade.Symbol)
+
+	// Symbols data-service does not know are rejected
+	w = call(http.MethodPost, "/trade/place", tenant1, map[string]interface{}{"symbol": "NOPE", "price": 120, "quantity": 3})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	list := func(token string) []models.Trade {
+		w := call(http.MethodGet, "/trade/list", token, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp struct{ Trades []models.Trade }
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Trades
+	}
+	assert.Len(t, list(tenant1), 1)
+	assert.Empty(t, list(tenant2))
+
+	path := fmt.Sprintf("/trade/%d", placed.Trade.ID)
+	assert.Equal(t, http.StatusOK, call(http.MethodGet, path, tenant1, nil).Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, path, tenant2, nil).Code)
+	assert.Equal(t, http.StatusNotFound, call(http.MethodGet, path, userToken(t, "8", "1"), nil).Code)
+
+	// Instruments of a tenant stay unknown to the others, also once a
+	// trade of their tenant has cached them
+	acme := map[string]interface{}{"symbol": "ACME", "price": 60, "quantity": 1}
+	w = call(http.MethodPost, "/trade/place", tenant2, acme)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = call(http.MethodPost, "/trade/place", tenant1, acme)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Unknown instrument")
+
+	// Tokens without a tenant are refused outright
+	w = call(http.MethodGet, "/trade/list", userToken(t, "7", ""), nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestTrades_APIKey(t *testing.T) {
+	database.DB.Exec("DELETE FROM trades")
+
+	w := call(http.MethodPost, "/trade/place", testAPIKey, map[string]interface{}{"symbol": "BTC-USD", "price": 150, "quantity": 1})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = call(http.MethodGet, "/trade/list", testAPIKey, nil)
+	var resp struct{ Trades []models.Trade }
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if assert.Len(t, resp.Trades, 1) {
+		assert.Equal(t, uint(9), resp.Trades[0].UserID)
+		assert.Equal(t, "1", resp.Trades[0].TenantID)
+	}
+
+	// Unknown or revoked keys are refused
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/trade/list", "tsk_revoked", nil).Code)
+
+	// A refused introspection is not a revoked key
+	assert.Equal(t, http.StatusServiceUnavailable, call(http.MethodGet, "/trade/list", "tsk_rate_limited", nil).Code)
+}
+
+func TestTrades_PriceRules(t *testing.T) {
+	database.DB.Exec("DELETE FROM trades")
+	token := userToken(t, "7", "1")
+	place := func(price float64) *httptest.ResponseRecorder {
+		return call(http.MethodPost, "/trade/place", token, map[string]interface{}{"symbol": "BTC-USD", "price": price, "quantity": 1})
+	}
+
+	// Without rules only the lowest price bounds a trade
+	assert.Equal(t, http.StatusOK, place(60).Code)
+
+	os.Setenv("TRADE_MAX_DEVIATION_PERCENT", "10")
+	defer os.Unsetenv("TRADE_MAX_DEVIATION_PERCENT")
+	assert.Equal(t, http.StatusOK, place(120).Code)
+	w := place(125)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "112.00")
+
+	os.Setenv("TRADE_MAX_STDDEVS", "2")
+	defer os.Unsetenv("TRADE_MAX_STDDEVS")
+	assert.Equal(t, http.StatusOK, place(119).Code)
+	w = place(121)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "standard deviations")
+}
+
+func TestTrades_LowestPriceFromEvents(t *testing.T) {
+	database.DB.Exec("DELETE FROM trades")
+	controllers.PriceEventsConnected(true)
+	defer controllers.PriceEventsConnected(false)
+	place := func(token string, price float64) int {
+		w := call(http.MethodPost, "/trade/place", token, map[string]interface{}{"symbol": "BTC-USD", "price": price, "quantity": 1})
+		return w.Code
+	}
+	tenant1, tenant2 := userToken(t, "7", "1"), userToken(t, "7", "2")
+
+	// The first trade asks data-service, later ones use the local view
+	calls := lowestCalls.Load()
+	assert.Equal(t, http.StatusOK, place(tenant1, 60))
+	assert.Equal(t, http.StatusBadRequest, place(tenant1, 40))
+	assert.Equal(t, calls+1, lowestCalls.Load())
+
+	// A lower shared price lowers the floor of every tenant in view, and a
+	// tenant price only its own
+	controllers.ApplyPriceEvent(utils.PriceEvent{Symbol: "BTC-USD", TenantID: "2", Value: decimal.NewFromInt(10), Time: time.Now()})
+	assert.Equal(t, http.StatusBadRequest, place(tenant1, 40))
+	controllers.ApplyPriceEvent(utils.PriceEvent{Symbol: "BTC-USD", Value: decimal.NewFromInt(60), Time: time.Now()})
+	assert.Equal(t, http.StatusOK, place(tenant1, 40))
+	assert.Equal(t, calls+1, lowestCalls.Load())
+
+	assert.Equal(t, http.StatusOK, place(tenant2, 60))
+	assert.Equal(t, calls+2, lowestCalls.Load())
+
+	// Without events every trade asks data-service again
+	controllers.PriceEventsConnected(false)
+	assert.Equal(t, http.StatusBadRequest, place(tenant1, 40))
+	assert.Equal(t, calls+3, lowestCalls.Load())
+}
+
+func TestTrades_DecimalPriceAndQuantity(t *testing.T) {
+	database.DB.Exec("DELETE FROM trades")
+	token := userToken(t, "7", "1")
+	place := func(price, quantity interface{}) *httptest.ResponseRecorder {
+		return call(http.MethodPost, "/trade/place", token, map[string]interface{}{"symbol": "BTC-USD", "price": price, "quantity": quantity})
+	}
+
+	// Exactly half the lowest price is allowed, one tick less is not
+	w := place("50", "1.25")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var placed struct{ Trade models.Trade }
+	json.Unmarshal(w.Body.Bytes(), &placed)
+	assert.Equal(t, "50", placed.Trade.Price.String())
+	assert.Equal(t, "1.25", placed.Trade.Quantity.String())
+	assert.Contains(t, w.Body.String(), `"Quantity":"1.25"`)
+	assert.Equal(t, http.StatusBadRequest, place("49.5", "1").Code)
+
+	// Prices off the tick and quantities beyond the precision are refused
+	w = place("100.25", "1")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "tick size 0.5")
+	assert.Equal(t, http.StatusBadRequest, place(100.5, "1.005").Code)
+	assert.Equal(t, http.StatusOK, place(100.5, 2).Code)
+	assert.Equal(t, http.StatusBadRequest, place("100", "0").Code)
+	assert.Equal(t, http.StatusBadRequest, place("abc", "1").Code)
+
+	var stored models.Trade
+	database.DB.Where("price = ?", "100.5").First(&stored)
+	assert.Equal(t, "2", stored.Quantity.String())
+}
+
+func TestRequireUserToken_DPoP(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwk := map[string]interface{}{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	jkt, err := dpop.JWKThumbprint(jwk)
+	assert.NoError(t, err)
+
+	claims := jwt.MapClaims{
+		"sub":       "7",
+		"aud":       "webclient",
+		"tenant_id": "1",
+		"exp":       time.Now().Add(time.Minute).Unix(),
+		"cnf":       map[string]string{"jkt": jkt},
+	}
+	bound, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte(testSecret))
+	assert.NoError(t, err)
+
+	const htu = "https://trade.example.com/trade/list"
+	var jti atomic.Int64
+	proof := func(method, htu, accessToken, nonce string) string {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims := jwt.MapClaims{
+			"htm": method,
+			"htu": htu,
+			"iat": time.Now().Unix(),
+			"jti": fmt.Sprint("proof-", jti.Add(1)),
+			"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+		}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["typ"] = "dpop+jwt"
+		token.Header["jwk"] = jwk
+		signed, _ := token.SignedString(key)
+		return signed
+	}
+	list := func(scheme, token, proof string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/trade/list", nil)
+		req.Header.Set("Authorization", scheme+" "+token)
+		if proof != "" {
+			req.Header.Set("DPoP", proof)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	errorOf := func(w *httptest.ResponseRecorder) string {
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		s, _ := body["error"].(string)
+		return s
+	}
+
+	// A bound token needs the DPoP scheme
+	w := list("Bearer", bound, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "invalid_token", errorOf(w))
+
+	// The first proof is answered with a nonce to use
+	w = list("DPoP", bound, proof(http.MethodGet, htu, bound, ""))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "use_dpop_nonce", errorOf(w))
+	nonce := w.Header().Get("DPoP-Nonce")
+	assert.NotEmpty(t, nonce)
+	w = list("DPoP", bound, proof(http.MethodGet, htu, bound, "forged"))
+	assert.Equal(t, "use_dpop_nonce", errorOf(w))
+
+	good := proof(http.MethodGet, htu, bound, nonce)
+	w = list("DPoP", bound, good)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("DPoP-Nonce"))
+
+	// Proofs cannot be replayed
+	w = list("DPoP", bound, good)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "invalid_dpop_proof", errorOf(w))
+
+	// Proofs are tied to the method, the URL and the access token
+	for name, p := range map[string]string{
+		"htm": proof(http.MethodPost, htu, bound, nonce),
+		"htu": proof(http.MethodGet, "https://trade.example.com/trade/place", bound, nonce),
+		"ath": proof(http.MethodGet, htu, userToken(t, "7", "1"), nonce),
+	} {
+		w = list("DPoP", bound, p)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+		assert.Equal(t, "invalid_dpop_proof", errorOf(w), name)
+	}
+
+	// Tokens that are not bound cannot be sent with the DPoP scheme
+	unbound := userToken(t, "7", "1")
+	w = list("DPoP", unbound, proof(http.MethodGet, htu, unbound, nonce))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "invalid_token", errorOf(w))
+}
@@ -2,88 +2,263 @@ package middleware
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/RanggaNehemia/golang-microservices/dpop"
 	"github.com/RanggaNehemia/golang-microservices/trade-service/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 )
 
 var SecretKey []byte
-var expectedAud string
+var expectedAuds []string
 
-func init() {
-	err := godotenv.Load()
-	if err != nil {
-		utils.Logger.Panic("No .env file found", zap.Error(err))
-	}
+// publicURL is the externally visible base URL of trade-service; the htu of
+// DPoP proofs is checked against it.
+var publicURL string
+
+var dpopReplay = dpop.NewReplayCache()
+
+// introspectionClient bounds how long a request waits for auth-service.
+var introspectionClient = &http.Client{Timeout: 10 * time.Second}
+
+// Init reads the middleware settings from the environment. It must run
+// after the logger is initialized and .env is loaded.
+func Init() {
 	secret := os.Getenv("SECRET_KEY")
 	if secret == "" {
 		utils.Logger.Fatal("SECRET_KEY not set in environment")
 	}
 	SecretKey = []byte(secret)
-	expectedAud = os.Getenv("WEB_CLIENT_ID")
+	expectedAuds = []string{os.Getenv("WEB_CLIENT_ID")}
+	// Further clients whose user tokens trade-service accepts, e.g. the
+	// device flow client of the terminal app.
+	for _, id := range strings.Split(os.Getenv("USER_CLIENT_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			expectedAuds = append(expectedAuds, id)
+		}
+	}
+	publicURL = strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
 }
 
 func RequireUserToken() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		h := c.GetHeader("Authorization")
 		parts := strings.SplitN(h, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "DPoP") {
 			utils.Logger.Warn("Missing or bad auth header")
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing or bad auth header"})
 			return
 		}
 		tokenString := parts[1]
 
-		// Local signature + exp check
-		token, err := jwt.Parse(parts[1], func(t *jwt.Token) (interface{}, error) {
-			return SecretKey, nil
-		})
-		if err != nil || !token.Valid {
-			utils.Logger.Warn("Invalid token")
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		if utils.IsAPIKey(tokenString) {
+			if parts[0] != "Bearer" {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API keys use the Bearer scheme"})
+				return
+			}
+			requireAPIKey(c, tokenString)
 			return
 		}
 
-		// Validity Check
-		resp, err := http.PostForm(
-			os.Getenv("AUTH_URL")+"/oauth/introspect",
-			url.Values{"token": {tokenString}},
-		)
-		if err != nil {
-			utils.Logger.Warn("Introspection failed", zap.Error(err))
-			c.AbortWithStatusJSON(500, gin.H{"error": "Introspection failed"})
-			return
+		// Local signature + exp check
+		var claims jwt.MapClaims
+		isJWT := utils.IsJWT(tokenString)
+		if isJWT {
+			token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+				return SecretKey, nil
+			})
+			if err != nil || !token.Valid {
+				utils.Logger.Warn("Invalid token")
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				return
+			}
+			claims = token.Claims.(jwt.MapClaims)
 		}
-		defer resp.Body.Close()
 
-		var body struct {
-			Active bool `json:"active"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
-			utils.Logger.Error("Bad introspection response", zap.Error(err))
-			c.AbortWithStatusJSON(500, gin.H{"error": "Bad introspection response"})
+		// Validity Check
+		body, ok := introspect(c, tokenString)
+		if !ok {
 			return
 		}
 		if !body.Active {
 			c.AbortWithStatusJSON(401, gin.H{"error": "Token revoked"})
 			return
 		}
+		// Opaque tokens carry nothing themselves; the introspection response
+		// stands in for their claims.
+		if !isJWT {
+			claims = body.Claims
+			claims["aud"] = body.ClientID
+		}
 
 		// Audience Check
-		claims := token.Claims.(jwt.MapClaims)
-		if aud, _ := claims["aud"].(string); aud != expectedAud {
+		if aud, _ := claims["aud"].(string); !slices.Contains(expectedAuds, aud) {
 			utils.Logger.Warn("Wrong audience", zap.String("Audience", aud))
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Wrong audience"})
 			return
 		}
+
+		// Trades are always scoped to a tenant
+		tenantID, _ := claims["tenant_id"].(string)
+		if tenantID == "" {
+			utils.Logger.Warn("Token has no tenant")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token has no tenant"})
+			return
+		}
+
+		// Sender constraint
+		if err := checkDPoP(c, parts[0], tokenString, claims); err != nil {
+			return
+		}
 		c.Set("user_id", claims["sub"])
+		c.Set("tenant_id", tenantID)
+		c.Set("amr", claims["amr"])
+		c.Set("access_token", tokenString)
 		c.Next()
 	}
 }
+
+// introspection is the part of an RFC 7662 response trade-service uses.
+type introspection struct {
+	Active   bool     `json:"active"`
+	Sub      string   `json:"sub"`
+	ClientID string   `json:"client_id"`
+	Scope    string   `json:"scope"`
+	AMR      []string `json:"amr"`
+	TenantID string   `json:"tenant_id"`
+	// Claims is the whole response, used as the claims of opaque tokens.
+	Claims jwt.MapClaims `json:"-"`
+}
+
+// introspect asks auth-service about token. On failure it aborts the request.
+func introspect(c *gin.Context, token string) (*introspection, bool) {
+	resp, err := postIntrospection(token)
+	if err != nil {
+		utils.Logger.Warn("Introspection failed", zap.Error(err))
+		c.AbortWithStatusJSON(500, gin.H{"error": "Introspection failed"})
+		return nil, false
+	}
+	defer resp.Body.Close()
+	// A rate limited or failing auth-service says nothing about the token.
+	if resp.StatusCode != http.StatusOK {
+		utils.Logger.Error("Introspection unavailable", zap.Int("status", resp.StatusCode))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Introspection unavailable"})
+		return nil, false
+	}
+
+	var body introspection
+	raw, err := io.ReadAll(resp.Body)
+	if err == nil {
+		err = json.Unmarshal(raw, &body)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, &body.Claims)
+	}
+	if err != nil {
+		utils.Logger.Error("Bad introspection response", zap.Error(err))
+		c.AbortWithStatusJSON(500, gin.H{"error": "Bad introspection response"})
+		return nil, false
+	}
+	return &body, true
+}
+
+// postIntrospection sends the introspection request. trade-service
+// authenticates as its client, so that auth-service rate limits its
+// introspections by client rather than by address.
+func postIntrospection(token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, os.Getenv("AUTH_URL")+"/oauth/introspect",
+		strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if id := os.Getenv("TRADE_SERVICE_CLIENT_ID"); id != "" {
+		req.SetBasicAuth(id, os.Getenv("TRADE_SERVICE_CLIENT_SECRET"))
+	}
+	return introspectionClient.Do(req)
+}
+
+// requireAPIKey authenticates a personal API key sent directly instead of
+// an access token. Keys are opaque, so introspection is authoritative.
+func requireAPIKey(c *gin.Context, key string) {
+	body, ok := introspect(c, key)
+	if !ok {
+		return
+	}
+	if !body.Active || body.Sub == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return
+	}
+	if body.TenantID == "" {
+		utils.Logger.Warn("API key has no tenant")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token has no tenant"})
+		return
+	}
+
+	amr := make([]interface{}, len(body.AMR))
+	for i, v := range body.AMR {
+		amr[i] = v
+	}
+	c.Set("user_id", body.Sub)
+	c.Set("tenant_id", body.TenantID)
+	c.Set("amr", amr)
+	c.Set("access_token", key)
+	c.Next()
+}
+
+// checkDPoP enforces RFC 9449 for tokens bound to a key with cnf.jkt, see
+// dpop.Check. Every DPoP response carries the next nonce. On failure it
+// aborts the request and returns the error.
+func checkDPoP(c *gin.Context, scheme, tokenString string, claims jwt.MapClaims) error {
+	cnf, _ := claims["cnf"].(map[string]interface{})
+	jkt, _ := cnf["jkt"].(string)
+	now := time.Now()
+	err := dpop.Check(SecretKey, dpopReplay, dpop.Request{
+		Scheme:      scheme,
+		Proof:       c.GetHeader("DPoP"),
+		Method:      c.Request.Method,
+		URL:         requestURL(c),
+		AccessToken: tokenString,
+		JKT:         jkt,
+	}, now)
+	if err != nil {
+		code := dpop.CodeInvalidProof
+		var checkErr *dpop.CheckError
+		if errors.As(err, &checkErr) {
+			code = checkErr.Code
+		}
+		utils.Logger.Warn("DPoP check failed", zap.String("error", code), zap.Error(err))
+		c.Header("DPoP-Nonce", dpop.NewNonce(SecretKey, now))
+		c.Header("WWW-Authenticate", dpop.Challenge(code))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": code, "error_description": err.Error()})
+		return err
+	}
+	if jkt != "" {
+		c.Header("DPoP-Nonce", dpop.NewNonce(SecretKey, now))
+	}
+	return nil
+}
+
+// requestURL is the URL the client used, as needed for the htu check.
+func requestURL(c *gin.Context) string {
+	if publicURL != "" {
+		return publicURL + c.Request.URL.Path
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + c.Request.Host + c.Request.URL.Path
+}
@@ -1,10 +1,28 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
 
 type Trade struct {
 	gorm.Model
-	UserID   uint
-	Price    float64
-	Quantity int
+	// TenantID is the tenant_id claim of the token the trade was placed with.
+	TenantID string `gorm:"index;not null;default:'1'"`
+	UserID   uint   `gorm:"index"`
+	// Symbol is the data-service instrument traded. Trades that predate
+	// instruments are on its default instrument.
+	Symbol string `gorm:"index;not null;default:'DEFAULT'"`
+	// Price is a multiple of the instrument's tick size and Quantity has
+	// at most its quantity precision in decimal places.
+	Price    decimal.Decimal `gorm:"type:numeric"`
+	Quantity decimal.Decimal `gorm:"type:numeric"`
+}
+
+// TenantScope limits a trade query to one tenant. Every trade query must
+// use it, so that no trade is visible outside its tenant.
+func TenantScope(tenantID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenantID)
+	}
 }
@@ -11,4 +11,6 @@ func RegisterTradeRoutes(router *gin.Engine) {
 	trade.Use(middleware.RequireUserToken())
 
 	trade.POST("/place", controllers.PlaceTrade)
+	trade.GET("/list", controllers.ListTrades)
+	trade.GET("/:id", controllers.GetTrade)
 }
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+// PriceEvent is a price.created event published by data-service.
+type PriceEvent struct {
+	ID       uint            `json:"id"`
+	Symbol   string          `json:"symbol"`
+	TenantID string          `json:"tenant_id"`
+	Value    decimal.Decimal `json:"value"`
+	Volume   decimal.Decimal `json:"volume"`
+	Time     time.Time       `json:"time"`
+}
+
+const topicPriceCreated = "price.created"
+
+// ListenPriceEvents LISTENs on channel in the data-service database at
+// dsn and calls handle with every price event until ctx is done. Lost
+// connections are reopened with backoff. Events published while
+// disconnected are not replayed, so connected is called with false when
+// the connection is lost and with true once listening again, before any
+// event, for the caller to drop what it derived from earlier events.
+func ListenPriceEvents(ctx context.Context, dsn, channel string, connected func(bool), handle func(PriceEvent)) {
+	backoff := time.Second
+	for ctx.Err() == nil {
+		err := listenPriceEvents(ctx, dsn, channel, func() {
+			backoff = time.Second
+			connected(true)
+		}, handle)
+		connected(false)
+		if ctx.Err() != nil {
+			return
+		}
+		Logger.Warn("Price events connection lost", zap.Error(err), zap.Duration("retry_in", backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+func listenPriceEvents(ctx context.Context, dsn, channel string, listening func(), handle func(PriceEvent)) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	Logger.Info("Listening for price events", zap.String("channel", channel))
+	listening()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var event struct {
+			Topic   string     `json:"topic"`
+			Payload PriceEvent `json:"payload"`
+		}
+		if err := json.Unmarshal([]byte(n.Payload), &event); err != nil {
+			Logger.Warn("Malformed event", zap.Error(err))
+			continue
+		}
+		if event.Topic == topicPriceCreated {
+			handle(event.Payload)
+		}
+	}
+}
@@ -2,21 +2,52 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 var (
 	mu          sync.Mutex
 	cachedToken string
 	expiry      time.Time
+
+	// exchangeMu only guards exchangeCache; exchanges themselves run
+	// without it, deduplicated per token by exchangeGroup.
+	exchangeMu    sync.Mutex
+	exchangeCache = map[string]cachedExchange{}
+	exchangeGroup singleflight.Group
+
+	// exchangeClient bounds how long a request waits for auth-service.
+	exchangeClient = &http.Client{Timeout: 10 * time.Second}
 )
 
+type cachedExchange struct {
+	token  string
+	expiry time.Time
+}
+
+// IsAPIKey reports whether token is a personal API key issued by
+// auth-service rather than a JWT access token.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, "tsk_")
+}
+
+// IsJWT reports whether token has the shape of a compact JWS. Other access
+// tokens are opaque references that only introspection can resolve.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
 func GetMachineToken() (string, error) {
 	clientID := os.Getenv("TRADE_SERVICE_CLIENT_ID")
 	clientSecret := os.Getenv("TRADE_SERVICE_CLIENT_SECRET")
@@ -51,3 +82,84 @@ func GetMachineToken() (string, error) {
 	expiry = time.Now().Add(time.Duration(body.ExpiresIn-10) * time.Second)
 	return cachedToken, nil
 }
+
+// ExchangeUserToken swaps a user's access token or API key for a short-lived
+// token with trade-service as audience that still names the user as subject
+// (RFC 8693 token exchange), so downstream services know who the call is for.
+// Concurrent calls for the same token share one exchange.
+func ExchangeUserToken(userToken string) (string, error) {
+	sum := sha256.Sum256([]byte(userToken))
+	key := hex.EncodeToString(sum[:])
+
+	if token, ok := cachedExchangeToken(key); ok {
+		return token, nil
+	}
+	token, err, _ := exchangeGroup.Do(key, func() (interface{}, error) {
+		// The exchange may have completed since the cache was checked.
+		if token, ok := cachedExchangeToken(key); ok {
+			return token, nil
+		}
+		return exchangeToken(key, userToken)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token.(string), nil
+}
+
+func cachedExchangeToken(key string) (string, bool) {
+	exchangeMu.Lock()
+	defer exchangeMu.Unlock()
+	if cached, ok := exchangeCache[key]; ok && time.Now().Before(cached.expiry) {
+		return cached.token, true
+	}
+	return "", false
+}
+
+// exchangeToken asks auth-service for the exchange and caches the result
+// under key.
+func exchangeToken(key, userToken string) (string, error) {
+	data := url.Values{}
+	data.Set("grant_type", "urn:ietf:params:oauth:grant-type:token-exchange")
+	data.Set("client_id", os.Getenv("TRADE_SERVICE_CLIENT_ID"))
+	data.Set("client_secret", os.Getenv("TRADE_SERVICE_CLIENT_SECRET"))
+	data.Set("subject_token", userToken)
+	if IsAPIKey(userToken) {
+		data.Set("subject_token_type", "urn:auth-service:token-type:api-key")
+	} else {
+		data.Set("subject_token_type", "urn:ietf:params:oauth:token-type:access_token")
+	}
+	if scope := os.Getenv("TOKEN_EXCHANGE_SCOPE"); scope != "" {
+		data.Set("scope", scope)
+	}
+
+	now := time.Now()
+	resp, err := exchangeClient.PostForm(os.Getenv("AUTH_URL")+"/oauth/token", data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", errors.New("token exchange failed: " + resp.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	exchangeMu.Lock()
+	defer exchangeMu.Unlock()
+	for k, cached := range exchangeCache {
+		if !now.Before(cached.expiry) {
+			delete(exchangeCache, k)
+		}
+	}
+	exchangeCache[key] = cachedExchange{
+		token:  body.AccessToken,
+		expiry: now.Add(time.Duration(body.ExpiresIn-10) * time.Second),
+	}
+	return body.AccessToken, nil
+}
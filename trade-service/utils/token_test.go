@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExchangeUserToken_SharesConcurrentExchanges(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		subject := r.FormValue("subject_token")
+		if subject == "shared-user-token" {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "exchanged-" + subject, "expires_in": 300})
+	}))
+	defer auth.Close()
+	t.Setenv("AUTH_URL", auth.URL)
+	exchangeCache = map[string]cachedExchange{}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i], _ = ExchangeUserToken("shared-user-token")
+		}()
+	}
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+
+	// Other tokens are not held up by the pending exchange
+	other, err := ExchangeUserToken("other-user-token")
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged-other-user-token", other)
+
+	close(release)
+	wg.Wait()
+	for _, token := range tokens {
+		assert.Equal(t, "exchanged-shared-user-token", token)
+	}
+	assert.Equal(t, int32(2), calls.Load())
+
+	// Cached afterwards
+	token, err := ExchangeUserToken("shared-user-token")
+	assert.NoError(t, err)
+	assert.Equal(t, "exchanged-shared-user-token", token)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestExchangeUserToken_Timeout(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer auth.Close()
+	t.Setenv("AUTH_URL", auth.URL)
+
+	prev := exchangeClient
+	exchangeClient = &http.Client{Timeout: 20 * time.Millisecond}
+	defer func() { exchangeClient = prev }()
+
+	_, err := ExchangeUserToken("slow-user-token")
+	assert.Error(t, err)
+}